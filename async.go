@@ -0,0 +1,187 @@
+package riak
+
+import (
+	"context"
+	"sync"
+
+	"github.com/tpjg/goriakpbc/pb"
+)
+
+/*
+A Future is the result of an asynchronous request. The asynchronous requests
+(GetAsync, StoreAsync, DeleteAsync and FetchAsync) send the request and return
+a future without waiting for the response. The
+requests are pipelined: many requests are sent on a single connection per
+node before the responses come back, so a small pool can keep the network busy
+for bulk loads:
+
+	futures := make([]*riak.ObjectFuture, len(keys))
+	for i, key := range keys {
+		futures[i] = bucket.GetAsync(key)
+	}
+	for _, f := range futures {
+		obj, err := f.Object()
+		...
+	}
+
+Sending blocks while the pipeline already has the maximum number of requests
+in flight (see SetPipelineDepth) or while no connection is available. When
+the connection breaks all requests in flight fail with the same error, the
+asynchronous requests are not retried.
+*/
+type Future struct {
+	done chan struct{}
+	once sync.Once
+	err  error
+}
+
+func newFuture() *Future {
+	return &Future{done: make(chan struct{})}
+}
+
+// Complete the future with the error returned by fn, only the first call has
+// an effect.
+func (f *Future) complete(fn func() error) {
+	f.once.Do(func() {
+		f.err = fn()
+		close(f.done)
+	})
+}
+
+// Returns a channel that is closed when the request completed
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait for the request to complete and return its error
+func (f *Future) Wait() error {
+	<-f.done
+	return f.err
+}
+
+// The result of GetAsync
+type ObjectFuture struct {
+	Future
+	obj *RObject
+}
+
+// Wait for the object, the result is the same as for Get
+func (f *ObjectFuture) Object() (*RObject, error) {
+	err := f.Wait()
+	return f.obj, err
+}
+
+// The result of FetchAsync
+type DataTypeFuture struct {
+	Future
+	obj RDataType
+}
+
+// Wait for the data type, the result is the same as for FetchCounter,
+// FetchSet or FetchMap
+func (f *DataTypeFuture) DataType() (RDataType, error) {
+	err := f.Wait()
+	return f.obj, err
+}
+
+// Get an object asynchronously
+func (b *Bucket) GetAsync(key string, options ...map[string]uint32) *ObjectFuture {
+	return b.GetAsyncContext(context.Background(), key, options...)
+}
+
+// Get an object asynchronously, the future completes with the error of the
+// context when the context is done before the response arrived
+func (b *Bucket) GetAsyncContext(ctx context.Context, key string, options ...map[string]uint32) *ObjectFuture {
+	f := &ObjectFuture{Future: Future{done: make(chan struct{})}}
+	req, err := b.getRequest("Get", key, false, options)
+	if err != nil {
+		f.complete(func() error { return err })
+		return f
+	}
+	resp := &pb.RpbGetResp{}
+	b.client.async(ctx, &f.Future, req, rpbGetReq, resp, func(err error) error {
+		if err != nil {
+			return err
+		}
+		f.obj, err = b.getResult(key, options, resp)
+		if err == nil && !req.GetHead() {
+			// The resolved value is not written back, that would need a
+			// connection while the pipeline holds one
+			err = f.obj.resolveSiblings(ctx, false)
+		}
+		return err
+	})
+	return f
+}
+
+// Store an RObject asynchronously, the object must not be used until the
+// future completed as the vclock (and the key) are set from the response
+func (obj *RObject) StoreAsync() *Future {
+	return obj.StoreAsyncContext(context.Background())
+}
+
+// Store an RObject asynchronously, the future completes with the error of the
+// context when the context is done before the response arrived
+func (obj *RObject) StoreAsyncContext(ctx context.Context) *Future {
+	f := newFuture()
+	req, err := obj.putRequest(nil)
+	if err != nil {
+		f.complete(func() error { return err })
+		return f
+	}
+	resp := &pb.RpbPutResp{}
+	obj.Bucket.client.async(ctx, f, req, rpbPutReq, resp, func(err error) error {
+		if err != nil {
+			return conditionError(err)
+		}
+		obj.putResult(resp)
+		return nil
+	})
+	return f
+}
+
+// Delete a key/value from the bucket asynchronously
+func (b *Bucket) DeleteAsync(key string, options ...map[string]uint32) *Future {
+	return b.DeleteAsyncContext(context.Background(), key, options...)
+}
+
+// Delete a key/value from the bucket asynchronously, the future completes
+// with the error of the context when the context is done before the response
+// arrived
+func (b *Bucket) DeleteAsyncContext(ctx context.Context, key string, options ...map[string]uint32) *Future {
+	f := newFuture()
+	req, err := b.delRequest(key, options)
+	if err != nil {
+		f.complete(func() error { return err })
+		return f
+	}
+	b.client.async(ctx, f, req, rpbDelReq, req, func(err error) error {
+		return err
+	})
+	return f
+}
+
+// Fetch a data type asynchronously
+func (b *Bucket) FetchAsync(key string, options ...map[string]uint32) *DataTypeFuture {
+	return b.FetchAsyncContext(context.Background(), key, options...)
+}
+
+// Fetch a data type asynchronously, the future completes with the error of
+// the context when the context is done before the response arrived
+func (b *Bucket) FetchAsyncContext(ctx context.Context, key string, options ...map[string]uint32) *DataTypeFuture {
+	f := &DataTypeFuture{Future: Future{done: make(chan struct{})}}
+	req, err := b.fetchRequest(key, options)
+	if err != nil {
+		f.complete(func() error { return err })
+		return f
+	}
+	resp := &pb.DtFetchResp{}
+	b.client.async(ctx, &f.Future, req, dtFetchReq, resp, func(err error) error {
+		if err != nil {
+			return err
+		}
+		f.obj, err = b.fetchResult(key, options, resp)
+		return err
+	})
+	return f
+}
@@ -0,0 +1,175 @@
+package riak
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/bmizerany/assert"
+	"github.com/golang/protobuf/proto"
+	"testing"
+	"time"
+
+	"github.com/tpjg/goriakpbc/pb"
+	"github.com/tpjg/goriakpbc/riaktest"
+)
+
+func TestAsync(t *testing.T) {
+	server, client, done := setupServer(t, 1)
+	defer done()
+	bucket, err := client.NewBucket("async_test.go")
+	assert.T(t, err == nil)
+
+	// Store, get and delete many objects on a single connection
+	stores := make([]*Future, 100)
+	for i := range stores {
+		obj := bucket.NewObject(fmt.Sprintf("key%d", i))
+		obj.ContentType = "text/plain"
+		obj.Data = []byte(fmt.Sprintf("data%d", i))
+		stores[i] = obj.StoreAsync()
+	}
+	for _, f := range stores {
+		assert.T(t, f.Wait() == nil)
+	}
+	gets := make([]*ObjectFuture, 101)
+	for i := range gets {
+		gets[i] = bucket.GetAsync(fmt.Sprintf("key%d", i))
+	}
+	for i, f := range gets[:100] {
+		obj, err := f.Object()
+		assert.T(t, err == nil)
+		assert.T(t, string(obj.Data) == fmt.Sprintf("data%d", i))
+		assert.T(t, len(obj.Vclock) > 0)
+	}
+	_, err = gets[100].Object()
+	assert.T(t, err == NotFound)
+	assert.T(t, bucket.DeleteAsync("key0").Wait() == nil)
+	_, err = bucket.Get("key0")
+	assert.T(t, err == NotFound)
+
+	// Only the request that got an error response fails
+	server.SetFault(func(code byte, req proto.Message) error {
+		if code == riaktest.GetReq && string(req.(*pb.RpbGetReq).Key) == "key2" {
+			return errors.New("overload")
+		}
+		return nil
+	})
+	gets = []*ObjectFuture{bucket.GetAsync("key1"), bucket.GetAsync("key2"), bucket.GetAsync("key3")}
+	_, err = gets[0].Object()
+	assert.T(t, err == nil)
+	_, err = gets[1].Object()
+	assert.T(t, errors.Is(err, Overload))
+	_, err = gets[2].Object()
+	assert.T(t, err == nil)
+}
+
+func TestAsyncPipelining(t *testing.T) {
+	server, client, done := setupServer(t, 1)
+	defer done()
+	client.SetPipelineDepth(4)
+	bucket, err := client.NewBucket("async_test.go")
+	assert.T(t, err == nil)
+
+	// Block the server, the requests are sent without waiting for a response
+	// until the pipeline is full
+	release := make(chan struct{})
+	server.SetFault(func(code byte, req proto.Message) error {
+		<-release
+		return nil
+	})
+	gets := make([]*ObjectFuture, 4)
+	for i := range gets {
+		gets[i] = bucket.GetAsync("key")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = bucket.GetAsyncContext(ctx, "key").Object()
+	assert.T(t, err == context.DeadlineExceeded)
+	close(release)
+	for _, f := range gets {
+		_, err = f.Object()
+		assert.T(t, err == NotFound)
+	}
+
+	// A context that is done completes the future, the other requests are
+	// not affected
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = bucket.GetAsyncContext(ctx, "key").Object()
+	assert.T(t, err == context.Canceled)
+	_, err = bucket.GetAsync("key").Object()
+	assert.T(t, err == NotFound)
+
+	// All requests in flight fail when the connection breaks, the next ones
+	// use a new connection
+	release = make(chan struct{})
+	server.SetFault(func(code byte, req proto.Message) error {
+		<-release
+		return riaktest.CloseConnection
+	})
+	for i := range gets {
+		gets[i] = bucket.GetAsync("key")
+	}
+	close(release)
+	for _, f := range gets {
+		_, err = f.Object()
+		var nerr *NetworkError
+		assert.T(t, errors.As(err, &nerr))
+	}
+	_, err = bucket.FetchAsync("key").DataType()
+	assert.T(t, err != nil)
+	server.SetFault(nil)
+	sets, err := client.NewBucketType("sets", "async_test.go")
+	assert.T(t, err == nil)
+	set, err := sets.FetchSet("set")
+	assert.T(t, err == NotFound)
+	set.Add([]byte("a"))
+	assert.T(t, set.Store() == nil)
+	dt, err := sets.FetchAsync("set").DataType()
+	assert.T(t, err == nil)
+	assert.T(t, len(dt.(*RDtSet).GetValue()) == 1)
+}
+
+// Calls start for every request that is sent
+type startHook struct {
+	*recorder
+	start func(req *RequestInfo)
+}
+
+func (h startHook) RequestStart(req *RequestInfo) {
+	h.start(req)
+}
+
+func TestAsyncCancelWrite(t *testing.T) {
+	server, client, done := setupServer(t, 1)
+	defer done()
+	bucket, err := client.NewBucket("async_test.go")
+	assert.T(t, err == nil)
+
+	// Cancel a large request while it is written to a server that does not
+	// read, it is still written completely so the other requests on the
+	// connection are not affected
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client.SetInstrumentation(startHook{&recorder{}, func(req *RequestInfo) {
+		if req.Code == rpbPutReq {
+			cancel()
+		}
+	}})
+	release := make(chan struct{})
+	server.SetFault(func(code byte, req proto.Message) error {
+		if code == riaktest.GetReq {
+			<-release
+		}
+		return nil
+	})
+	get := bucket.GetAsync("key")
+	large := bucket.NewObject("large")
+	large.ContentType = "application/octet-stream"
+	large.Data = make([]byte, 16<<20)
+	assert.T(t, large.StoreAsyncContext(ctx).Wait() == context.Canceled)
+	close(release)
+	_, err = get.Object()
+	assert.T(t, err == NotFound)
+	obj, err := bucket.GetAsync("large").Object()
+	assert.T(t, err == nil && len(obj.Data) == len(large.Data))
+}
@@ -0,0 +1,213 @@
+package riak
+
+import (
+	"context"
+	"sync"
+)
+
+// The result of a batch request for a single key, Index is the position of
+// the key (or object) in the batch
+type BatchResult struct {
+	Index  int
+	Key    string
+	Object *RObject // The object that was fetched or stored, nil for deletes
+	Err    error
+}
+
+/*
+Set the maximum number of requests of a batch (GetMany, StoreMany and
+DeleteMany) that run at the same time. The default (0) is the maximum number
+of connections of all nodes together, so a batch can use the whole pool.
+*/
+func (c *Client) SetBatchConcurrency(concurrency int) {
+	c.batchConcurrency = concurrency
+}
+
+/*
+Get many objects at once, the requests are spread over the connection pool.
+The results are in the same order as the keys, a key that does not exist has
+NotFound as error (and an Object with only the vclock, like Get). An error for
+one key does not stop the others.
+*/
+func (b *Bucket) GetMany(keys []string, options ...map[string]uint32) []BatchResult {
+	return b.GetManyContext(context.Background(), keys, options...)
+}
+
+// Get many objects at once, the keys that were not fetched when the context
+// is done have the error of the context
+func (b *Bucket) GetManyContext(ctx context.Context, keys []string, options ...map[string]uint32) []BatchResult {
+	results := newBatchResults(len(keys), func(i int) BatchResult {
+		return BatchResult{Index: i, Key: keys[i]}
+	})
+	err := b.GetManyFunc(ctx, keys, results.add, options...)
+	return results.get(err)
+}
+
+/*
+Get many objects at once and call fn for every result as soon as it arrives,
+so the results of a large batch do not have to be kept in memory. The results
+are not in the order of the keys, use the Index to find the key. fn is not
+called concurrently, when it returns an error the batch stops and the error
+is returned.
+*/
+func (b *Bucket) GetManyFunc(ctx context.Context, keys []string, fn func(BatchResult) error, options ...map[string]uint32) error {
+	return b.client.batch(ctx, len(keys), func(ctx context.Context, i int) BatchResult {
+		obj, err := b.GetContext(ctx, keys[i], options...)
+		return BatchResult{Index: i, Key: keys[i], Object: obj, Err: err}
+	}, fn)
+}
+
+// Store many objects at once, the results are in the same order as the
+// objects. An error for one object does not stop the others.
+func (b *Bucket) StoreMany(objs []*RObject) []BatchResult {
+	return b.StoreManyContext(context.Background(), objs)
+}
+
+// Store many objects at once, the objects that were not stored when the
+// context is done have the error of the context
+func (b *Bucket) StoreManyContext(ctx context.Context, objs []*RObject) []BatchResult {
+	results := newBatchResults(len(objs), func(i int) BatchResult {
+		return BatchResult{Index: i, Key: objs[i].Key, Object: objs[i]}
+	})
+	err := b.StoreManyFunc(ctx, objs, results.add)
+	return results.get(err)
+}
+
+// Store many objects at once and call fn for every result as soon as it
+// arrives, see GetManyFunc
+func (b *Bucket) StoreManyFunc(ctx context.Context, objs []*RObject, fn func(BatchResult) error) error {
+	return b.client.batch(ctx, len(objs), func(ctx context.Context, i int) BatchResult {
+		err := objs[i].StoreContext(ctx)
+		return BatchResult{Index: i, Key: objs[i].Key, Object: objs[i], Err: err}
+	}, fn)
+}
+
+// Delete many keys at once, the results are in the same order as the keys.
+// An error for one key does not stop the others.
+func (b *Bucket) DeleteMany(keys []string, options ...map[string]uint32) []BatchResult {
+	return b.DeleteManyContext(context.Background(), keys, options...)
+}
+
+// Delete many keys at once, the keys that were not deleted when the context
+// is done have the error of the context
+func (b *Bucket) DeleteManyContext(ctx context.Context, keys []string, options ...map[string]uint32) []BatchResult {
+	results := newBatchResults(len(keys), func(i int) BatchResult {
+		return BatchResult{Index: i, Key: keys[i]}
+	})
+	err := b.DeleteManyFunc(ctx, keys, results.add, options...)
+	return results.get(err)
+}
+
+// Delete many keys at once and call fn for every result as soon as it
+// arrives, see GetManyFunc
+func (b *Bucket) DeleteManyFunc(ctx context.Context, keys []string, fn func(BatchResult) error, options ...map[string]uint32) error {
+	return b.client.batch(ctx, len(keys), func(ctx context.Context, i int) BatchResult {
+		err := b.DeleteContext(ctx, keys[i], options...)
+		return BatchResult{Index: i, Key: keys[i], Err: err}
+	}, fn)
+}
+
+// The results of a batch in the order of the keys (or objects)
+type batchResults struct {
+	results []BatchResult
+	done    []bool
+}
+
+// Create the results of a batch of n items, item returns the result for an
+// item that was not done
+func newBatchResults(n int, item func(i int) BatchResult) *batchResults {
+	r := &batchResults{results: make([]BatchResult, n), done: make([]bool, n)}
+	for i := range r.results {
+		r.results[i] = item(i)
+	}
+	return r
+}
+
+// Add a result, it is the callback for the batch
+func (r *batchResults) add(result BatchResult) error {
+	r.results[result.Index] = result
+	r.done[result.Index] = true
+	return nil
+}
+
+// Return the results, the items that were not done have the error the batch
+// stopped with
+func (r *batchResults) get(err error) []BatchResult {
+	for i := range r.results {
+		if !r.done[i] {
+			r.results[i].Err = err
+		}
+	}
+	return r.results
+}
+
+/*
+Run do for the items 0 to n-1 with at most the batch concurrency at the same
+time and pass the results to fn, one at a time. Stops when fn returns an
+error or the context is done, the items that are in progress then are
+cancelled.
+*/
+func (c *Client) batch(ctx context.Context, n int, do func(ctx context.Context, i int) BatchResult, fn func(BatchResult) error) error {
+	if n == 0 {
+		return nil
+	}
+	concurrency := c.batchConcurrency
+	if concurrency <= 0 {
+		concurrency = c.conn_count * len(c.nodes)
+	}
+	if concurrency > n {
+		concurrency = n
+	} else if concurrency < 1 {
+		concurrency = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	items := make(chan int)
+	results := make(chan BatchResult)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range items {
+				result := do(ctx, i)
+				select {
+				case results <- result:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		defer close(items)
+		for i := 0; i < n; i++ {
+			select {
+			case items <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var err error
+	done := 0
+	for result := range results {
+		if err != nil {
+			continue
+		}
+		if err = fn(result); err != nil {
+			cancel()
+		}
+		done++
+	}
+	if err == nil && done < n {
+		err = ctx.Err()
+	}
+	return err
+}
@@ -0,0 +1,118 @@
+package riak
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/bmizerany/assert"
+	"github.com/golang/protobuf/proto"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tpjg/goriakpbc/pb"
+	"github.com/tpjg/goriakpbc/riaktest"
+)
+
+func TestBatch(t *testing.T) {
+	server, client, done := setupServer(t, 4)
+	defer done()
+	bucket, err := client.NewBucket("batch_test.go")
+	assert.T(t, err == nil)
+
+	objs := make([]*RObject, 50)
+	keys := make([]string, len(objs)+1)
+	for i := range objs {
+		objs[i] = bucket.NewObject(fmt.Sprintf("key%d", i))
+		objs[i].ContentType = "text/plain"
+		objs[i].Data = []byte(fmt.Sprintf("data%d", i))
+		keys[i] = objs[i].Key
+	}
+	keys[len(objs)] = "missing"
+	for i, result := range bucket.StoreMany(objs) {
+		assert.T(t, result.Index == i)
+		assert.T(t, result.Err == nil)
+		assert.T(t, result.Object == objs[i])
+		assert.T(t, len(objs[i].Vclock) > 0)
+	}
+
+	// The results are in the order of the keys, errors are per key
+	server.SetFault(func(code byte, req proto.Message) error {
+		if code == riaktest.GetReq && string(req.(*pb.RpbGetReq).Key) == "key7" {
+			return errors.New("overload")
+		}
+		return nil
+	})
+	results := bucket.GetMany(keys)
+	assert.T(t, len(results) == len(keys))
+	for i, result := range results[:len(objs)] {
+		assert.T(t, result.Key == keys[i])
+		if i == 7 {
+			assert.T(t, errors.Is(result.Err, Overload))
+			continue
+		}
+		assert.T(t, result.Err == nil)
+		assert.T(t, string(result.Object.Data) == fmt.Sprintf("data%d", i))
+	}
+	assert.T(t, results[len(objs)].Err == NotFound)
+	server.SetFault(nil)
+
+	for _, result := range bucket.DeleteMany(keys[:10]) {
+		assert.T(t, result.Err == nil)
+	}
+	results = bucket.GetMany(keys[:11])
+	for _, result := range results[:10] {
+		assert.T(t, result.Err == NotFound)
+	}
+	assert.T(t, results[10].Err == nil)
+}
+
+func TestBatchConcurrency(t *testing.T) {
+	server, client, done := setupServer(t, 8)
+	defer done()
+	client.SetBatchConcurrency(3)
+	bucket, err := client.NewBucket("batch_test.go")
+	assert.T(t, err == nil)
+
+	var running, max int32
+	server.SetFault(func(code byte, req proto.Message) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&max)
+			if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+				break
+			}
+		}
+		time.Sleep(2 * time.Millisecond)
+		return nil
+	})
+	keys := make([]string, 30)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+	}
+	results := bucket.DeleteMany(keys)
+	assert.T(t, len(results) == len(keys))
+	assert.T(t, max > 1 && max <= 3)
+
+	// Stream the results, the batch stops when the callback returns an error
+	stop := errors.New("stop")
+	count := 0
+	err = bucket.GetManyFunc(context.Background(), keys, func(result BatchResult) error {
+		assert.T(t, result.Key == keys[result.Index])
+		assert.T(t, result.Err == NotFound)
+		if count++; count == 5 {
+			return stop
+		}
+		return nil
+	})
+	assert.T(t, err == stop)
+	assert.T(t, count == 5)
+
+	// The keys that were not done when the context is done get its error
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	results = bucket.GetManyContext(ctx, keys)
+	assert.T(t, results[0].Err == NotFound)
+	assert.T(t, results[len(keys)-1].Err == context.DeadlineExceeded)
+}
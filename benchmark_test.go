@@ -5,7 +5,7 @@ import (
 )
 
 func BenchmarkStoreObject(b *testing.B) {
-	client := New("127.0.0.1:8087")
+	client := New(riakhost)
 	err := client.Connect()
 	if err != nil {
 		b.FailNow()
@@ -31,7 +31,7 @@ func BenchmarkStoreObject(b *testing.B) {
 }
 
 func BenchmarkGetObject(b *testing.B) {
-	client := New("127.0.0.1:8087")
+	client := New(riakhost)
 	err := client.Connect()
 	if err != nil {
 		b.FailNow()
@@ -61,7 +61,7 @@ func BenchmarkGetObject(b *testing.B) {
 }
 
 func BenchmarkSaveModel(b *testing.B) {
-	client := New("127.0.0.1:8087")
+	client := New(riakhost)
 	err := client.Connect()
 	if err != nil {
 		b.FailNow()
@@ -84,7 +84,7 @@ func BenchmarkSaveModel(b *testing.B) {
 }
 
 func BenchmarkLoadModel(b *testing.B) {
-	client := New("127.0.0.1:8087")
+	client := New(riakhost)
 	err := client.Connect()
 	if err != nil {
 		b.FailNow()
@@ -110,3 +110,34 @@ func BenchmarkLoadModel(b *testing.B) {
 		}
 	}
 }
+
+func BenchmarkGetObjectAsync(b *testing.B) {
+	client := New(riakhost)
+	err := client.Connect()
+	if err != nil {
+		b.FailNow()
+	}
+	bucket, err := client.Bucket("client_test.go")
+	if err != nil {
+		b.FailNow()
+	}
+	obj := bucket.New("abc", PW1, DW1, R1)
+	obj.ContentType = "text/plain"
+	obj.Data = []byte("some more data")
+	err = obj.Store()
+	if err != nil {
+		b.FailNow()
+	}
+
+	futures := make([]*ObjectFuture, b.N)
+	for i := 0; i < b.N; i++ {
+		futures[i] = bucket.GetAsync("abc", R1, PR1)
+	}
+	for _, f := range futures {
+		obj, err = f.Object()
+		if err != nil || obj.Data == nil {
+			b.Log(err)
+			b.Fail()
+		}
+	}
+}
@@ -1,86 +1,65 @@
 package riak
 
 import (
+	"context"
+	"strconv"
+
 	"github.com/tpjg/goriakpbc/pb"
 )
 
 // Implements access to a bucket and its properties
 type Bucket struct {
-	bucket_type   string
-	name          string
-	client        *Client
-	nval          uint32
-	allowMult     bool
-	lastWriteWins bool
-	search        bool
-	searchIndex   string
-	datatype      string
-	consistent    bool
+	bucket_type string
+	name        string
+	client      *Client
+	props       BucketProps
+	resolver    ConflictResolver
 }
 
 // Return a new bucket object
 func (c *Client) NewBucket(name string) (*Bucket, error) {
+	return c.NewBucketContext(context.Background(), name)
+}
+
+// Return a new bucket object, the request is aborted when the context is done
+func (c *Client) NewBucketContext(ctx context.Context, name string) (*Bucket, error) {
 	if name == "" {
 		return nil, NoBucketName
 	}
-	req := &pb.RpbGetBucketReq{
-		Bucket: []byte(name),
-	}
-	err, conn := c.request(req, rpbGetBucketReq)
-
-	if err != nil {
-		return nil, err
-	}
-	resp := &pb.RpbGetBucketResp{}
-	err = c.response(conn, resp)
-
+	props, err := c.bucketProps(ctx, "", name)
 	if err != nil {
 		return nil, err
 	}
 
 	bucket := &Bucket{
-		name:          name,
-		client:        c,
-		nval:          resp.Props.GetNVal(),
-		allowMult:     resp.Props.GetAllowMult(),
-		lastWriteWins: resp.Props.GetLastWriteWins(),
-		search: 			resp.Props.GetSearch(),
-		searchIndex:	string(resp.Props.GetSearchIndex()),
-		bucket_type:   `default`,
+		name:        name,
+		client:      c,
+		props:       props,
+		bucket_type: `default`,
 	}
 
 	return bucket, nil
 }
 
 func (c *Client) NewBucketType(btype, name string) (*Bucket, error) {
+	return c.NewBucketTypeContext(context.Background(), btype, name)
+}
+
+// NewBucketTypeContext is the same as NewBucketType, but the request is aborted when the context is done
+func (c *Client) NewBucketTypeContext(ctx context.Context, btype, name string) (*Bucket, error) {
 	if name == "" || btype == "" {
 		return nil, NoBucketName
 	}
-	req := &pb.RpbGetBucketReq{
-		Bucket: []byte(name),
-		Type:   []byte(btype),
-	}
-	err, conn := c.request(req, rpbGetBucketReq)
-
-	if err != nil {
-		return nil, err
-	}
-	resp := &pb.RpbGetBucketResp{}
-	err = c.response(conn, resp)
-
+	props, err := c.bucketProps(ctx, btype, name)
 	if err != nil {
 		return nil, err
 	}
 
 	bucket := &Bucket{
-		name:          name,
-		bucket_type:   btype,
-		client:        c,
-		nval:          resp.Props.GetNVal(),
-		allowMult:     resp.Props.GetAllowMult(),
-		lastWriteWins: resp.Props.GetLastWriteWins(),
-		datatype:      string(resp.Props.GetDatatype()),
-		consistent:    resp.Props.GetConsistent(),
+		name:        name,
+		bucket_type: btype,
+		client:      c,
+		props:       props,
 	}
 
 	return bucket, nil
@@ -98,150 +77,132 @@ func (b *Bucket) Name() string {
 
 // Return the nval property of a bucket
 func (b *Bucket) NVal() uint32 {
-	return b.nval
+	return b.props.NVal
 }
 
 // Return the allowMult property of a bucket
 func (b *Bucket) AllowMult() bool {
-	return b.allowMult
+	return b.props.AllowMult
 }
 
 // Return the lastWriteWins property of a bucket
 func (b *Bucket) LastWriteWins() bool {
-	return b.lastWriteWins
+	return b.props.LastWriteWins
 }
 
 // Return the search property of a bucket
 func (b *Bucket) Search() bool {
-	return b.search
+	return b.props.Search
 }
 
 // Set the search property of a bucket
 func (b *Bucket) SetSearch(search bool) (err error) {
-	props := &pb.RpbBucketProps{NVal: &b.nval, AllowMult: &b.allowMult, LastWriteWins: &b.lastWriteWins, Search: &search}
-	req := &pb.RpbSetBucketReq{Bucket: []byte(b.name), Type: []byte(b.bucket_type), Props: props}
-	err, conn := b.client.request(req, rpbSetBucketReq)
-	if err != nil {
-		return err
-	}
-	err = b.client.response(conn, req)
-	if err != nil {
-		return err
-	}
-	b.search = search
-	return nil
+	return b.SetSearchContext(context.Background(), search)
+}
+
+// Set the search property of a bucket, the request is aborted when the context is done
+func (b *Bucket) SetSearchContext(ctx context.Context, search bool) (err error) {
+	props := b.props
+	props.Search = search
+	return b.setProps(ctx, props, &pb.RpbBucketProps{Search: &search})
 }
 
 // Set the search_index property of a bucket
 func (b *Bucket) SetSearchIndex(searchIndex string) (err error) {
-	props := &pb.RpbBucketProps{SearchIndex: []byte(searchIndex)}
-	req := &pb.RpbSetBucketReq{Bucket: []byte(b.name), Type: []byte(b.bucket_type), Props: props}
-	err, conn := b.client.request(req, rpbSetBucketReq)
-	if err != nil {
-		return err
-	}
-	err = b.client.response(conn, req)
-	if err != nil {
-		return err
-	}
-	b.searchIndex = searchIndex
-	return nil
+	return b.SetSearchIndexContext(context.Background(), searchIndex)
+}
+
+// Set the search_index property of a bucket, the request is aborted when the context is done
+func (b *Bucket) SetSearchIndexContext(ctx context.Context, searchIndex string) (err error) {
+	props := b.props
+	props.SearchIndex = searchIndex
+	return b.setProps(ctx, props, &pb.RpbBucketProps{SearchIndex: []byte(searchIndex)})
 }
 
 // Return the search_index property of a bucket
 func (b *Bucket) SearchIndex() string {
-	return b.searchIndex
+	return b.props.SearchIndex
 }
 
 // Set the nval property of a bucket
 func (b *Bucket) SetNVal(nval uint32) (err error) {
-	props := &pb.RpbBucketProps{NVal: &nval, AllowMult: &b.allowMult, LastWriteWins: &b.lastWriteWins, Search: &b.search}
-	req := &pb.RpbSetBucketReq{Bucket: []byte(b.name), Type: []byte(b.bucket_type), Props: props}
-	err, conn := b.client.request(req, rpbSetBucketReq)
-	if err != nil {
-		return err
-	}
-	err = b.client.response(conn, req)
-	if err != nil {
-		return err
-	}
-	b.nval = nval
-	return nil
+	return b.SetNValContext(context.Background(), nval)
+}
+
+// Set the nval property of a bucket, the request is aborted when the context is done
+func (b *Bucket) SetNValContext(ctx context.Context, nval uint32) (err error) {
+	props := b.props
+	props.NVal = nval
+	return b.setProps(ctx, props, &pb.RpbBucketProps{NVal: &nval})
 }
 
 // Set the allowMult property of a bucket
 func (b *Bucket) SetAllowMult(allowMult bool) (err error) {
-	props := &pb.RpbBucketProps{NVal: &b.nval, AllowMult: &allowMult, LastWriteWins: &b.lastWriteWins, Search: &b.search}
-	req := &pb.RpbSetBucketReq{Bucket: []byte(b.name), Type: []byte(b.bucket_type), Props: props}
-	err, conn := b.client.request(req, rpbSetBucketReq)
-	if err != nil {
-		return err
-	}
-	err = b.client.response(conn, req)
-	if err != nil {
-		return err
-	}
-	b.allowMult = allowMult
-	return nil
+	return b.SetAllowMultContext(context.Background(), allowMult)
+}
+
+// Set the allowMult property of a bucket, the request is aborted when the context is done
+func (b *Bucket) SetAllowMultContext(ctx context.Context, allowMult bool) (err error) {
+	props := b.props
+	props.AllowMult = allowMult
+	return b.setProps(ctx, props, &pb.RpbBucketProps{AllowMult: &allowMult})
 }
 
 // Set the lastWriteWins property of a bucket
 func (b *Bucket) SetLastWriteWins(lastWriteWins bool) (err error) {
-	props := &pb.RpbBucketProps{NVal: &b.nval, AllowMult: &b.allowMult, LastWriteWins: &lastWriteWins, Search: &b.search}
-	req := &pb.RpbSetBucketReq{Bucket: []byte(b.name), Type: []byte(b.bucket_type), Props: props}
-	err, conn := b.client.request(req, rpbSetBucketReq)
+	return b.SetLastWriteWinsContext(context.Background(), lastWriteWins)
+}
+
+// Set the lastWriteWins property of a bucket, the request is aborted when the context is done
+func (b *Bucket) SetLastWriteWinsContext(ctx context.Context, lastWriteWins bool) (err error) {
+	props := b.props
+	props.LastWriteWins = lastWriteWins
+	return b.setProps(ctx, props, &pb.RpbBucketProps{LastWriteWins: &lastWriteWins})
+}
+
+// Delete a key/value from the bucket
+func (b *Bucket) Delete(key string, options ...map[string]uint32) (err error) {
+	return b.DeleteContext(context.Background(), key, options...)
+}
+
+// Delete a key/value from the bucket, the request is aborted when the context is done
+func (b *Bucket) DeleteContext(ctx context.Context, key string, options ...map[string]uint32) (err error) {
+	req, err := b.delRequest(key, options)
 	if err != nil {
 		return err
 	}
-	err = b.client.response(conn, req)
+
+	err = b.client.do(ctx, false, req, rpbDelReq, req)
 	if err != nil {
 		return err
 	}
-	b.lastWriteWins = lastWriteWins
 	return nil
 }
 
-// Delete a key/value from the bucket
-func (b *Bucket) Delete(key string, options ...map[string]uint32) (err error) {
+// Build the request to delete a key/value from the bucket
+func (b *Bucket) delRequest(key string, options []map[string]uint32) (*pb.RpbDelReq, error) {
 	req := &pb.RpbDelReq{Bucket: []byte(b.name), Type: []byte(b.bucket_type), Key: []byte(key)}
-	for _, omap := range options {
-		for k, v := range omap {
-			switch k {
-			case "r":
-				req.R = &v
-			case "pr":
-				req.Pr = &v
-			case "rw":
-				req.Rw = &v
-			case "w":
-				req.W = &v
-			case "dw":
-				req.Dw = &v
-			case "pw":
-				req.Pw = &v
-			}
-		}
-	}
-
-	err, conn := b.client.request(req, rpbDelReq)
-	if err != nil {
-		return err
-	}
-	err = b.client.response(conn, req)
+	opts, err := parseOptions("Delete", delOptionKeys, options)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	opts.applyDel(req)
+	return req, nil
 }
 
 // Delete directly from a bucket, without creating a bucket object first
 func (c *Client) DeleteFrom(bucketname string, key string, options ...map[string]uint32) (err error) {
+	return c.DeleteFromContext(context.Background(), bucketname, key, options...)
+}
+
+// Delete directly from a bucket, without creating a bucket object first, the request is aborted when the context is done
+func (c *Client) DeleteFromContext(ctx context.Context, bucketname string, key string, options ...map[string]uint32) (err error) {
 	var bucket *Bucket
-	bucket, err = c.Bucket(bucketname)
+	bucket, err = c.NewBucketContext(ctx, bucketname)
 	if err != nil {
 		return
 	}
-	return bucket.Delete(key, options...)
+	return bucket.DeleteContext(ctx, key, options...)
 }
 
 // Create a new RObject
@@ -261,7 +222,12 @@ func (b *Bucket) New(key string, options ...map[string]uint32) *RObject {
 
 // Create a new RObject in a bucket directly, without creating a bucket object first
 func (c *Client) NewObjectIn(bucketname string, key string, options ...map[string]uint32) (*RObject, error) {
-	bucket, err := c.Bucket(bucketname)
+	return c.NewObjectInContext(context.Background(), bucketname, key, options...)
+}
+
+// Create a new RObject in a bucket directly, without creating a bucket object first, the request is aborted when the context is done
+func (c *Client) NewObjectInContext(ctx context.Context, bucketname string, key string, options ...map[string]uint32) (*RObject, error) {
+	bucket, err := c.NewBucketContext(ctx, bucketname)
 	if err != nil {
 		return nil, err
 	}
@@ -270,55 +236,55 @@ func (c *Client) NewObjectIn(bucketname string, key string, options ...map[strin
 
 // Test if an object exists
 func (b *Bucket) Exists(key string, options ...map[string]uint32) (exists bool, err error) {
-	t := true
-	req := &pb.RpbGetReq{
-		Bucket:     []byte(b.name),
-		Type:       []byte(b.bucket_type),
-		Key:        []byte(key),
-		NotfoundOk: &t,
-		Head:       &t}
-	for _, omap := range options {
-		for k, v := range omap {
-			switch k {
-			case "r":
-				req.R = &v
-			case "pr":
-				req.Pr = &v
-			}
-		}
-	}
-
-	err, conn := b.client.request(req, rpbGetReq)
-	if err != nil {
+	return b.ExistsContext(context.Background(), key, options...)
+}
+
+// Test if an object exists, the request is aborted when the context is done
+func (b *Bucket) ExistsContext(ctx context.Context, key string, options ...map[string]uint32) (exists bool, err error) {
+	if _, err = parseOptions("Exists", getOptionKeys, options); err != nil {
 		return false, err
 	}
-	resp := &pb.RpbGetResp{}
-	err = b.client.response(conn, resp)
+	_, err = b.HeadContext(ctx, key, options...)
+	if err == NotFound {
+		return false, nil
+	}
 	if err != nil {
 		return false, err
 	}
-	return len(resp.Content) != 0, nil
+	return true, nil
 }
 
 // Test if an object exists in a bucket directly, without creating a bucket object first
 func (c *Client) ExistsIn(bucketname string, key string, options ...map[string]uint32) (exists bool, err error) {
-	bucket, err := c.Bucket(bucketname)
+	return c.ExistsInContext(context.Background(), bucketname, key, options...)
+}
+
+// Test if an object exists in a bucket directly, without creating a bucket object first, the request is aborted when the context is done
+func (c *Client) ExistsInContext(ctx context.Context, bucketname string, key string, options ...map[string]uint32) (exists bool, err error) {
+	bucket, err := c.NewBucketContext(ctx, bucketname)
 	if err != nil {
 		return false, err
 	}
-	return bucket.Exists(key, options...)
+	return bucket.ExistsContext(ctx, key, options...)
 }
 
 // Return a list of keys using the index for a single key
-func (b *Bucket) IndexQuery(index string, key string) (keys []string, err error) {
+func (b *Bucket) IndexQuery(index string, key string, options ...map[string]uint32) (keys []string, err error) {
+	return b.IndexQueryContext(context.Background(), index, key, options...)
+}
+
+// Return a list of keys using the index for a single key, the request is aborted when the context is done
+func (b *Bucket) IndexQueryContext(ctx context.Context, index string, key string, options ...map[string]uint32) (keys []string, err error) {
 	req := &pb.RpbIndexReq{Bucket: []byte(b.name), Type: []byte(b.bucket_type), Index: []byte(index),
 		Qtype: pb.RpbIndexReq_eq.Enum(), Key: []byte(key)}
-	err, conn := b.client.request(req, rpbIndexReq)
+	opts, err := parseOptions("IndexQuery", indexOptionKeys, options)
 	if err != nil {
 		return nil, err
 	}
+	opts.applyIndex(req)
+
 	resp := &pb.RpbIndexResp{}
-	err = b.client.response(conn, resp)
+	err = b.client.do(ctx, true, req, rpbIndexReq, resp)
 	if err != nil {
 		return nil, err
 	}
@@ -330,7 +296,12 @@ func (b *Bucket) IndexQuery(index string, key string) (keys []string, err error)
 }
 
 // Return a page of keys using the index for a single key
-func (b *Bucket) IndexQueryPage(index string, key string, results uint32, continuation string) (keys []string, next string, err error) {
+func (b *Bucket) IndexQueryPage(index string, key string, results uint32, continuation string, options ...map[string]uint32) (keys []string, next string, err error) {
+	return b.IndexQueryPageContext(context.Background(), index, key, results, continuation, options...)
+}
+
+// Return a page of keys using the index for a single key, the request is aborted when the context is done
+func (b *Bucket) IndexQueryPageContext(ctx context.Context, index string, key string, results uint32, continuation string, options ...map[string]uint32) (keys []string, next string, err error) {
 	req := &pb.RpbIndexReq{Bucket: []byte(b.name), Type: []byte(b.bucket_type), Index: []byte(index),
 		Qtype: pb.RpbIndexReq_eq.Enum(), Key: []byte(key),
 		MaxResults: &results}
@@ -339,12 +310,14 @@ func (b *Bucket) IndexQueryPage(index string, key string, results uint32, contin
 		req.Continuation = []byte(continuation)
 	}
 
-	err, conn := b.client.request(req, rpbIndexReq)
+	opts, err := parseOptions("IndexQueryPage", indexOptionKeys, options)
 	if err != nil {
 		return nil, "", err
 	}
+	opts.applyIndex(req)
+
 	resp := &pb.RpbIndexResp{}
-	err = b.client.response(conn, resp)
+	err = b.client.do(ctx, true, req, rpbIndexReq, resp)
 	if err != nil {
 		return nil, "", err
 	}
@@ -358,16 +331,23 @@ func (b *Bucket) IndexQueryPage(index string, key string, results uint32, contin
 }
 
 // Return a list of keys using the index range query
-func (b *Bucket) IndexQueryRange(index string, min string, max string) (keys []string, err error) {
+func (b *Bucket) IndexQueryRange(index string, min string, max string, options ...map[string]uint32) (keys []string, err error) {
+	return b.IndexQueryRangeContext(context.Background(), index, min, max, options...)
+}
+
+// Return a list of keys using the index range query, the request is aborted when the context is done
+func (b *Bucket) IndexQueryRangeContext(ctx context.Context, index string, min string, max string, options ...map[string]uint32) (keys []string, err error) {
 	req := &pb.RpbIndexReq{Bucket: []byte(b.name), Type: []byte(b.bucket_type), Index: []byte(index),
 		Qtype:    pb.RpbIndexReq_range.Enum(),
 		RangeMin: []byte(min), RangeMax: []byte(max)}
-	err, conn := b.client.request(req, rpbIndexReq)
+	opts, err := parseOptions("IndexQueryRange", indexOptionKeys, options)
 	if err != nil {
 		return nil, err
 	}
+	opts.applyIndex(req)
+
 	resp := &pb.RpbIndexResp{}
-	err = b.client.response(conn, resp)
+	err = b.client.do(ctx, true, req, rpbIndexReq, resp)
 	if err != nil {
 		return nil, err
 	}
@@ -378,8 +358,26 @@ func (b *Bucket) IndexQueryRange(index string, min string, max string) (keys []s
 	return
 }
 
+// Return a list of keys using a range query on an integer ("_int") index
+func (b *Bucket) IndexQueryIntRange(index string, min int64, max int64, options ...map[string]uint32) (keys []string, err error) {
+	return b.IndexQueryIntRangeContext(context.Background(), index, min, max, options...)
+}
+
+// Return a list of keys using a range query on an integer index, the request is aborted when the context is done
+func (b *Bucket) IndexQueryIntRangeContext(ctx context.Context, index string, min int64, max int64, options ...map[string]uint32) (keys []string, err error) {
+	if !isIntIndex(index) {
+		return nil, NotIntIndex
+	}
+	return b.IndexQueryRangeContext(ctx, index, strconv.FormatInt(min, 10), strconv.FormatInt(max, 10), options...)
+}
+
 // Return a page of keys using the index range query
-func (b *Bucket) IndexQueryRangePage(index string, min string, max string, results uint32, continuation string) (keys []string, next string, err error) {
+func (b *Bucket) IndexQueryRangePage(index string, min string, max string, results uint32, continuation string, options ...map[string]uint32) (keys []string, next string, err error) {
+	return b.IndexQueryRangePageContext(context.Background(), index, min, max, results, continuation, options...)
+}
+
+// Return a page of keys using the index range query, the request is aborted when the context is done
+func (b *Bucket) IndexQueryRangePageContext(ctx context.Context, index string, min string, max string, results uint32, continuation string, options ...map[string]uint32) (keys []string, next string, err error) {
 	req := &pb.RpbIndexReq{Bucket: []byte(b.name), Type: []byte(b.bucket_type), Index: []byte(index),
 		Qtype:    pb.RpbIndexReq_range.Enum(),
 		RangeMin: []byte(min), RangeMax: []byte(max),
@@ -389,12 +387,14 @@ func (b *Bucket) IndexQueryRangePage(index string, min string, max string, resul
 		req.Continuation = []byte(continuation)
 	}
 
-	err, conn := b.client.request(req, rpbIndexReq)
+	opts, err := parseOptions("IndexQueryRangePage", indexOptionKeys, options)
 	if err != nil {
 		return nil, "", err
 	}
+	opts.applyIndex(req)
+
 	resp := &pb.RpbIndexResp{}
-	err = b.client.response(conn, resp)
+	err = b.client.do(ctx, true, req, rpbIndexReq, resp)
 	if err != nil {
 		return nil, "", err
 	}
@@ -407,14 +407,53 @@ func (b *Bucket) IndexQueryRangePage(index string, min string, max string, resul
 	return
 }
 
-// List all keys from bucket
-func (b *Bucket) ListKeys() (response [][]byte, err error) {
-	req := &pb.RpbListKeysReq{Bucket: []byte(b.name), Type: []byte(b.bucket_type)}
+// Return the keys with their terms using the index range query, only the
+// terms that match termRegex are returned unless it is empty
+func (b *Bucket) IndexQueryRangeTerms(index string, min string, max string, termRegex string, options ...map[string]uint32) (terms []IndexTerm, err error) {
+	return b.IndexQueryRangeTermsContext(context.Background(), index, min, max, termRegex, options...)
+}
 
-	err, conn := b.client.request(req, rpbListKeysReq)
+// Return the keys with their terms using the index range query, the request is aborted when the context is done
+func (b *Bucket) IndexQueryRangeTermsContext(ctx context.Context, index string, min string, max string, termRegex string, options ...map[string]uint32) (terms []IndexTerm, err error) {
+	returnTerms := true
+	req := &pb.RpbIndexReq{Bucket: []byte(b.name), Type: []byte(b.bucket_type), Index: []byte(index),
+		Qtype:    pb.RpbIndexReq_range.Enum(),
+		RangeMin: []byte(min), RangeMax: []byte(max),
+		ReturnTerms: &returnTerms}
+	if termRegex != "" {
+		req.TermRegex = []byte(termRegex)
+	}
+	opts, err := parseOptions("IndexQueryRangeTerms", indexOptionKeys, options)
 	if err != nil {
 		return nil, err
 	}
+	opts.applyIndex(req)
 
-	return b.client.mp_response(conn)
+	resp := &pb.RpbIndexResp{}
+	err = b.client.do(ctx, true, req, rpbIndexReq, resp)
+	if err != nil {
+		return nil, err
+	}
+	terms = make([]IndexTerm, len(resp.Results))
+	for i, r := range resp.Results {
+		terms[i] = IndexTerm{Term: string(r.Key), Key: string(r.Value)}
+	}
+	return
+}
+
+// List all keys from bucket
+func (b *Bucket) ListKeys(options ...map[string]uint32) (response [][]byte, err error) {
+	return b.ListKeysContext(context.Background(), options...)
+}
+
+// List all keys from bucket, the request is aborted when the context is done
+func (b *Bucket) ListKeysContext(ctx context.Context, options ...map[string]uint32) (response [][]byte, err error) {
+	err = b.streamKeys(ctx, "ListKeys", func(keys [][]byte) error {
+		response = append(response, keys...)
+		return nil
+	}, options)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
 }
@@ -2,9 +2,13 @@ package riak
 
 import (
 	"github.com/bmizerany/assert"
+	"github.com/golang/protobuf/proto"
 	"strconv"
 	"strings"
 	"testing"
+
+	"github.com/tpjg/goriakpbc/pb"
+	"github.com/tpjg/goriakpbc/riaktest"
 )
 
 func parseVersion(version string) (major, minor int) {
@@ -72,3 +76,102 @@ func TestBucket(t *testing.T) {
 		assert.T(t, bucket3.LastWriteWins() == true)
 	}
 }
+
+func TestBucketProps(t *testing.T) {
+	server, client, done := setupServer(t, 1)
+	defer done()
+	bucket, err := client.NewBucket("bucket_test.go")
+	assert.T(t, err == nil)
+	assert.T(t, bucket.Props().NVal == 3)
+	assert.T(t, bucket.Props().NotfoundOk)
+
+	// Only the changed properties are sent, in a single request
+	var sent []*pb.RpbBucketProps
+	server.SetFault(func(code byte, req proto.Message) error {
+		if code == riaktest.SetBucketReq {
+			sent = append(sent, req.(*pb.RpbSetBucketReq).Props)
+		}
+		return nil
+	})
+	props := bucket.Props()
+	props.R = QuorumOne
+	props.DW = 2
+	props.BasicQuorum = true
+	props.Backend = "leveldb"
+	props.Precommit = []CommitHook{{Name: "validate"}, {ModFun: &ModFun{"mymodule", "precommit"}}}
+	props.ChashKeyfun = &ModFun{"riak_core_util", "chash_std_keyfun"}
+	assert.T(t, bucket.SetProps(props) == nil)
+	assert.T(t, len(sent) == 1)
+	assert.T(t, sent[0].NVal == nil && sent[0].AllowMult == nil && sent[0].W == nil)
+	assert.T(t, sent[0].GetR() == QuorumOne && sent[0].GetDw() == 2)
+	assert.T(t, sent[0].GetHasPrecommit() && len(sent[0].Precommit) == 2)
+	assert.T(t, bucket.SetProps(props) == nil)
+	assert.T(t, len(sent) == 1)
+	assert.T(t, bucket.SetNVal(2) == nil)
+	assert.T(t, len(sent) == 2)
+	assert.T(t, sent[1].GetNVal() == 2 && sent[1].R == nil)
+	server.SetFault(nil)
+
+	bucket2, err := client.NewBucket("bucket_test.go")
+	assert.T(t, err == nil)
+	props.NVal = 2
+	assert.Equal(t, bucket2.Props(), props)
+
+	// The hooks are replaced
+	props.Precommit = nil
+	assert.T(t, bucket.SetProps(props) == nil)
+	bucket2, err = client.NewBucket("bucket_test.go")
+	assert.T(t, err == nil)
+	assert.T(t, len(bucket2.Props().Precommit) == 0)
+
+	// Reset returns to the defaults of the bucket type
+	assert.T(t, bucket.Reset() == nil)
+	assert.T(t, bucket.NVal() == 3)
+	assert.T(t, bucket.Props().R == 0 && bucket.Props().ChashKeyfun == nil)
+	bucket2, err = client.NewBucket("bucket_test.go")
+	assert.T(t, err == nil)
+	assert.Equal(t, bucket2.Props(), bucket.Props())
+
+	// NewBucketType fetches all properties as well
+	bucket3, err := client.NewBucketType("sets", "bucket_test.go")
+	assert.T(t, err == nil)
+	assert.T(t, bucket3.Props().Datatype == "set")
+	assert.T(t, bucket3.Props().NVal == 3)
+}
+
+func TestBucketTypeProps(t *testing.T) {
+	server, client, done := setupServer(t, 1)
+	defer done()
+
+	props, err := client.BucketTypeProps("maps")
+	assert.T(t, err == nil)
+	assert.T(t, props.Datatype == "map")
+	assert.T(t, props.NVal == 3)
+	_, err = client.BucketTypeProps("missing")
+	assert.T(t, err != nil)
+
+	// Only the changed properties are sent, the datatype is left alone
+	var sent *pb.RpbBucketProps
+	server.SetFault(func(code byte, req proto.Message) error {
+		if code == riaktest.SetBucketTypeReq {
+			sent = req.(*pb.RpbSetBucketTypeReq).Props
+		}
+		return nil
+	})
+	props.NVal = 5
+	assert.T(t, client.SetBucketTypeProps("maps", props) == nil)
+	assert.T(t, sent.GetNVal() == 5 && sent.Datatype == nil && sent.AllowMult == nil)
+	server.SetFault(nil)
+	props, err = client.BucketTypeProps("maps")
+	assert.T(t, err == nil)
+	assert.T(t, props.NVal == 5)
+
+	// The buckets of the type get the new defaults
+	bucket, err := client.NewBucketType("maps", "bucket_test.go")
+	assert.T(t, err == nil)
+	assert.T(t, bucket.NVal() == 5)
+
+	// The datatype cannot be changed
+	props.Datatype = "set"
+	assert.T(t, client.SetBucketTypeProps("maps", props) != nil)
+}
@@ -5,6 +5,7 @@ It implements a connection to Riak using protobuf.
 package riak
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -29,15 +30,32 @@ protoc --go_out=. riak.proto
 
 // riak.Client the client interface
 type Client struct {
-	addr         string
-	tcpaddr      *net.TCPAddr
-	readTimeout  time.Duration
-	writeTimeout time.Duration
-	conn_count   int
-	conns        chan *net.TCPConn
-	chanWait     time.Duration
-	connTimeout  time.Duration
-	connMutex    sync.RWMutex
+	nodes            []*node
+	balancer         Balancer
+	next             uint32
+	readTimeout      time.Duration
+	writeTimeout     time.Duration
+	conn_count       int // Maximum number of connections per node
+	minConns         int // Number of connections per node that are kept open
+	idleTimeout      time.Duration
+	maxLifetime      time.Duration
+	keepalive        time.Duration
+	chanWait         time.Duration
+	connTimeout      time.Duration
+	probeInterval    time.Duration
+	security         *security
+	retryPolicy      *RetryPolicy
+	instrumentation  Instrumentation
+	pipelineDepth    int // Maximum number of requests in flight on a pipelined connection
+	pipeWriteTimeout time.Duration
+	batchConcurrency int // Maximum number of requests of a batch at the same time
+	updateAttempts   int // Maximum number of attempts of Update
+	resolver         ConflictResolver
+	bucketResolvers  *sync.Map // Resolvers for specific buckets, by bucketName
+	compression      *sync.Map // Compression policies of buckets, by bucketName
+	codecs           *sync.Map // Registered codecs, by content encoding
+	contentCodecs    *sync.Map // Registered content codecs, by content type
+	contentTypes     *sync.Map // Content types of buckets, by bucketName
 }
 
 /*
@@ -82,13 +100,7 @@ func New(addr string) *Client {
 
 // Returns a new Client with multiple connections to Riak
 func NewClientPool(addr string, count int) *Client {
-	okCountSize := count
-	if count < 1 {
-		okCountSize = 1
-	}
-	ret := &Client{addr: addr, readTimeout: 1e8, writeTimeout: 1e8, conn_count: count, conns: make(chan *net.TCPConn, okCountSize)}
-	ret.conns <- nil
-	return ret
+	return NewClusterClient([]string{addr}, count)
 }
 
 // Returns a new Client with multiple connections to Riak. DEPRECATED, use NewClientPool instead
@@ -102,188 +114,213 @@ func (c *Client) SetConnectTimeout(timeout time.Duration) {
 	c.connTimeout = timeout
 }
 
-// Connects to a Riak server.
-func (c *Client) Connect() error {
-	d := new(net.Dialer)
-	if c.connTimeout > 0 {
-		d.Timeout = c.connTimeout
+// Connects to a Riak server, or to all nodes of a cluster. Only returns an
+// error if none of the nodes could be reached.
+func (c *Client) Connect() (err error) {
+	if len(c.nodes) == 0 {
+		return NoNodes
 	}
-	return c.tcpConnect(d)
+	connected := false
+	for _, n := range c.nodes {
+		if nerr := c.connectNode(n); nerr != nil {
+			n.markDown()
+			err = nerr
+		} else {
+			n.markUp()
+			connected = true
+		}
+	}
+	if connected {
+		return nil
+	}
+	return err
 }
 
-func (c *Client) tcpConnect(dialer *net.Dialer) (err error) {
-	c.connMutex.RLock()
-	defer c.connMutex.RUnlock()
-	tcpaddr, err := net.ResolveTCPAddr("tcp", c.addr)
-	if err != nil {
-		return err
-	}
-	c.tcpaddr = tcpaddr
+// Connects to a single node
+func (c *Client) connectNode(n *node) error {
+	return n.connect(c.dialer())
+}
 
-	if c.conn_count <= 0 {
-		return BadNumberOfConnections
-	} else if conn := <-c.conns; conn == nil {
-		// Create multiple connections to Riak and send these to the conns channel for later use
-		for i := 0; i < c.conn_count; i++ {
-			conn, err := dialer.Dial("tcp", tcpaddr.String())
-			if err != nil {
-				// Empty the conns channel before returning, in case an error appeared after a few
-				// successful connections.
-				for j := 0; j < i; j++ {
-					(<-c.conns).Close()
-				}
-				c.conns <- nil
-				return err
-			}
-			c.conns <- conn.(*net.TCPConn)
-		}
-	} else {
-		c.conns <- conn
+// Returns a dialer that honours the connect timeout
+func (c *Client) dialer() *net.Dialer {
+	d := new(net.Dialer)
+	if c.connTimeout > 0 {
+		d.Timeout = c.connTimeout
 	}
-	return nil
+	return d
 }
 
 // Close the connection
 func (c *Client) Close() {
-	c.connMutex.Lock()
-	defer c.connMutex.Unlock()
-	if conn := <-c.conns; conn == nil {
-		c.conns <- nil
-		return
+	for _, n := range c.nodes {
+		n.close()
 	}
-
-	// Close all the connections
-	for i := 0; i < c.conn_count-1; i++ {
-		conn := <-c.conns
-		conn.Close()
-	}
-	c.conns <- nil
 }
 
-// Write data to the connection
-func (c *Client) write(conn *net.TCPConn, request []byte) (err error) {
-	_, err = conn.Write(request)
-
+// Write data to the connection, a failed write leaves the connection broken
+func (c *Client) write(conn *nodeConn, request []byte) (err error) {
+	// Remember the message code for errors
+	if len(request) > 4 {
+		conn.op = request[4]
+		conn.startRequest(conn.op, nil)
+	}
+	n, err := conn.Write(request)
+	if conn.req != nil {
+		conn.req.BytesSent += n
+	}
+	if err != nil {
+		conn.broken = true
+		if cerr := conn.ctxErr(err); cerr != nil {
+			err = cerr
+		} else {
+			err = conn.netError(err)
+		}
+	}
 	return err
 }
 
-// Read data from the connection
-func (c *Client) read(conn *net.TCPConn, size int) (response []byte, err error) {
+// Read data from the connection, a failed read leaves the connection broken
+func (c *Client) read(conn *nodeConn, size int) (response []byte, err error) {
 	response = make([]byte, size)
 	s := 0
 	for i := 0; (size > 0) && (i < size); {
 		s, err = conn.Read(response[i:size])
 		i += s
+		if conn.req != nil {
+			conn.req.BytesReceived += s
+		}
 		if err != nil {
+			conn.broken = true
+			if cerr := conn.ctxErr(err); cerr != nil {
+				err = cerr
+			} else {
+				err = conn.netError(err)
+			}
 			return
 		}
 	}
 	return
 }
 
-// Gets the TCP connection for a client (either the only one, or one from the pool)
-func (c *Client) getConn() (err error, conn *net.TCPConn) {
-	timeout := time.After(c.chanWait)
-retry:
-	if c.chanWait > 0 {
-		select {
-		case conn = <-c.conns:
-			break
-		case <-timeout:
-			return ChanWaitTimeout, nil
-		}
-	} else {
-		conn = <-c.conns
+// Read the header of a message: <length:32> <msg_code:8>. Returns the message
+// code and the size of the protocol buffer message that follows, a message
+// without a code leaves the connection broken.
+func (c *Client) readHeader(conn *nodeConn) (code byte, size int, err error) {
+	header, err := c.read(conn, 5)
+	if err != nil {
+		return 0, 0, err
 	}
-	// Connect if necessary
-	if conn == nil {
-		c.conns <- nil
-		err = c.Connect()
-		if err != nil {
-			return err, nil
-		}
-		goto retry
+	size = int(header[0])<<24 + int(header[1])<<16 + int(header[2])<<8 + int(header[3]) - 1
+	if size < 0 {
+		conn.broken = true
+		return 0, 0, BadResponseLength
+	}
+	return header[4], size, nil
+}
+
+// Gets a connection from the pool of one of the nodes, the connection is
+// bound to the context until it is released.
+func (c *Client) getConn(ctx context.Context) (err error, conn *nodeConn) {
+	n, err := c.pickNode()
+	if err != nil {
+		return err, nil
 	}
-	return nil, conn
+	return n.getConn(ctx)
 }
 
-// Releases the TCP connection for use by subsequent requests
-func (c *Client) releaseConn(conn *net.TCPConn) {
+// Releases the connection for use by subsequent requests
+func (c *Client) releaseConn(conn *nodeConn) {
 	// Return this connection down the channel for re-use
-	c.conns <- conn
+	conn.node.releaseConn(conn)
+}
+
+// Handles an I/O error on a connection, the connection is released and, as it
+// is broken, closed so it is redialed the next time it is taken from the pool.
+// The other connections to the node are not affected. A closed or reset
+// connection or a broken pipe only means this connection went stale, other
+// errors mark the node as down. Errors caused by the request context only
+// discard the connection.
+func (c *Client) ioError(conn *nodeConn, err error) {
+	c.finish(conn, err)
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return
+	}
+	if !errors.Is(err, io.EOF) && !errors.Is(err, syscall.EPIPE) && !errors.Is(err, syscall.ECONNRESET) {
+		conn.node.markDown()
+	}
 }
 
 // Request serializes the data (using protobuf), adds the header and sends it to Riak.
-func (c *Client) request(req proto.Message, code byte) (err error, conn *net.TCPConn) {
-	err, conn = c.getConn()
+func (c *Client) request(req proto.Message, code byte) (err error, conn *nodeConn) {
+	return c.requestContext(context.Background(), req, code)
+}
+
+// Same as request, but the connection is bound to the context so the request
+// and the response can be cancelled.
+func (c *Client) requestContext(ctx context.Context, req proto.Message, code byte) (err error, conn *nodeConn) {
+	err, conn = c.getConn(ctx)
 	if err != nil {
 		return err, nil
 	}
-	// Serialize the request using protobuf
-	pbmsg, err := proto.Marshal(req)
+	conn.startRequest(code, req)
+	msgbuf, err := frame(req, code)
 	if err != nil {
+		c.finish(conn, err)
 		return err, conn
 	}
-	// Build message with header: <length:32> <msg_code:8> <pbmsg>
-	i := int32(len(pbmsg) + 1)
-	msgbuf := []byte{byte(i >> 24), byte(i >> 16), byte(i >> 8), byte(i), code}
-	msgbuf = append(msgbuf, pbmsg...)
 	// Send to Riak
 	err = c.write(conn, msgbuf)
-	// If an error occurred when sending request
 	if err != nil {
-		// Make sure connection will be released in the end
-		defer c.releaseConn(conn)
-
-		var errno syscall.Errno
-
-		// If the error is not recoverable like a broken pipe, close all connections,
-		// so next time when getConn() is called it will Connect() again
-		if operr, ok := err.(*net.OpError); ok {
-			if errno, ok = operr.Err.(syscall.Errno); ok {
-				if errno == syscall.EPIPE {
-					c.Close()
-				}
-			}
-		}
+		c.ioError(conn, err)
 	}
 	return err, conn
 }
 
+// Serialize the request using protobuf and build the message with header:
+// <length:32> <msg_code:8> <pbmsg>. A nil request has no pbmsg.
+func frame(req proto.Message, code byte) ([]byte, error) {
+	var pbmsg []byte
+	if req != nil {
+		var err error
+		if pbmsg, err = proto.Marshal(req); err != nil {
+			return nil, err
+		}
+	}
+	i := int32(len(pbmsg) + 1)
+	msgbuf := []byte{byte(i >> 24), byte(i >> 16), byte(i >> 8), byte(i), code}
+	return append(msgbuf, pbmsg...), nil
+}
+
 // Reponse deserializes the data and returns a struct.
-func (c *Client) response(conn *net.TCPConn, response proto.Message) (err error) {
+func (c *Client) response(conn *nodeConn, response proto.Message) (err error) {
 	// Read the response from Riak
-	msgbuf, err := c.read(conn, 5)
+	msgcode, size, err := c.readHeader(conn)
 	if err != nil {
-		c.releaseConn(conn)
-		if err == io.EOF {
-			// Connection was closed, try to re-open the connection so subsequent
-			// i/o can succeed. Does report the error for this response.
-			c.Close()
-		}
+		// The broken connection is replaced so subsequent i/o can succeed. Does
+		// report the error for this response.
+		c.ioError(conn, err)
 		return err
 	}
-	defer c.releaseConn(conn)
+	defer func() { c.finish(conn, err) }()
 
-	// Check the length
-	if len(msgbuf) < 5 {
-		return BadResponseLength
-	}
-	// Read the message length, read the rest of the message if necessary
-	msglen := int(msgbuf[0])<<24 + int(msgbuf[1])<<16 + int(msgbuf[2])<<8 + int(msgbuf[3])
-	pbmsg, err := c.read(conn, msglen-1)
+	// Read the rest of the message
+	pbmsg, err := c.read(conn, size)
 	if err != nil {
 		return err
 	}
 
-	// Deserialize, by default the calling method should provide the expected RbpXXXResp
-	msgcode := msgbuf[4]
+	return decode(msgcode, pbmsg, response)
+}
+
+// Deserialize a response message, by default the calling method should provide
+// the expected RbpXXXResp. Error responses are returned as a RiakError.
+func decode(msgcode byte, pbmsg []byte, response proto.Message) (err error) {
 	switch msgcode {
 	case rpbErrorResp:
 		errResp := &pb.RpbErrorResp{}
 		err = proto.Unmarshal(pbmsg, errResp)
 		if err == nil {
-			err = errors.New(string(errResp.GetErrmsg()))
+			err = riakError(errResp)
 		}
 	case rpbPingResp, rpbSetClientIdResp, rpbSetBucketResp, rpbDelResp:
 		return nil
@@ -295,26 +332,20 @@ func (c *Client) response(conn *net.TCPConn, response proto.Message) (err error)
 
 // Reponse deserializes the data from a MapReduce response and returns the data,
 // this can come from multiple response messages
-func (c *Client) mr_response(conn *net.TCPConn) (response [][]byte, err error) {
-	defer c.releaseConn(conn)
+func (c *Client) mr_response(conn *nodeConn) (response [][]byte, err error) {
+	defer func() { c.finish(conn, err) }()
 	// Read the response from Riak
-	msgbuf, err := c.read(conn, 5)
+	msgcode, size, err := c.readHeader(conn)
 	if err != nil {
 		return nil, err
 	}
-	// Check the length
-	if len(msgbuf) < 5 {
-		return nil, BadResponseLength
-	}
-	// Read the message length, read the rest of the message if necessary
-	msglen := int(msgbuf[0])<<24 + int(msgbuf[1])<<16 + int(msgbuf[2])<<8 + int(msgbuf[3])
-	pbmsg, err := c.read(conn, msglen-1)
+	// Read the rest of the message
+	pbmsg, err := c.read(conn, size)
 	if err != nil {
 		return nil, err
 	}
 
 	// Deserialize, by default the calling method should provide the expected RbpXXXResp
-	msgcode := msgbuf[4]
 	if msgcode == rpbMapRedResp {
 		partial := &pb.RpbMapRedResp{}
 		err = proto.Unmarshal(pbmsg, partial)
@@ -331,17 +362,11 @@ func (c *Client) mr_response(conn *net.TCPConn) (response [][]byte, err error) {
 		for done == nil {
 			partial = &pb.RpbMapRedResp{}
 			// Read another response
-			msgbuf, err = c.read(conn, 5)
+			_, size, err = c.readHeader(conn)
 			if err != nil {
 				return nil, err
 			}
-			// Check the length
-			if len(msgbuf) < 5 {
-				return nil, BadResponseLength
-			}
-			// Read the message length, read the rest of the message if necessary
-			msglen := int(msgbuf[0])<<24 + int(msgbuf[1])<<16 + int(msgbuf[2])<<8 + int(msgbuf[3])
-			pbmsg, err := c.read(conn, msglen-1)
+			pbmsg, err := c.read(conn, size)
 			if err != nil {
 				return nil, err
 			}
@@ -360,7 +385,7 @@ func (c *Client) mr_response(conn *net.TCPConn) (response [][]byte, err error) {
 		errResp := &pb.RpbErrorResp{}
 		err = proto.Unmarshal(pbmsg, errResp)
 		if err == nil {
-			err = errors.New(string(errResp.Errmsg))
+			err = riakError(errResp)
 		} else {
 			err = fmt.Errorf("Cannot deserialize error response from Riak - %v", err)
 		}
@@ -371,73 +396,24 @@ func (c *Client) mr_response(conn *net.TCPConn) (response [][]byte, err error) {
 	return
 }
 
-// Deserializes the data from possibly multiple packets,
-// currently only for pb.RpbListKeysResp.
-func (c *Client) mp_response(conn *net.TCPConn) (response [][]byte, err error) {
-	defer c.releaseConn(conn)
-	var (
-		partial *pb.RpbListKeysResp
-		msgcode byte
-	)
-
-	for {
-		// Read the response from Riak
-		msgbuf, err := c.read(conn, 5)
-		if err != nil {
-			return nil, err
-		}
-		// Check the length
-		if len(msgbuf) < 5 {
-			return nil, BadResponseLength
-		}
-		// Read the message length, read the rest of the message if necessary
-		msglen := int(msgbuf[0])<<24 + int(msgbuf[1])<<16 + int(msgbuf[2])<<8 + int(msgbuf[3])
-		pbmsg, err := c.read(conn, msglen-1)
-		if err != nil {
-			return nil, err
-		}
-
-		// Deserialize, by default the calling method should provide the expected RbpXXXResp
-		msgcode = msgbuf[4]
-
-		if msgcode == rpbListKeysResp {
-			partial = &pb.RpbListKeysResp{}
-			err = proto.Unmarshal(pbmsg, partial)
-			if err != nil {
-				return nil, err
-			}
-
-			response = append(response, partial.Keys...)
-
-			if partial.Done != nil {
-				break
-			}
-		} else if msgcode == rpbErrorResp {
-			errResp := &pb.RpbErrorResp{}
-			err = proto.Unmarshal(pbmsg, errResp)
-			if err == nil {
-				err = errors.New(string(errResp.Errmsg))
-			} else {
-				err = fmt.Errorf("Cannot deserialize error response from Riak - %v", err)
-			}
-			return nil, err
-		} else {
-			return nil, err
-		}
-	}
-
-	return
-}
-
 // Ping the server
 func (c *Client) Ping() (err error) {
+	return c.PingContext(context.Background())
+}
+
+// Ping the server, the request is aborted when the context is done
+func (c *Client) PingContext(ctx context.Context) (err error) {
 	// Use hardcoded request, no need to serialize
 	msg := []byte{0, 0, 0, 1, rpbPingReq}
-	err, conn := c.getConn()
+	err, conn := c.getConn(ctx)
+	if err != nil {
+		return err
+	}
+	err = c.write(conn, msg)
 	if err != nil {
+		c.ioError(conn, err)
 		return err
 	}
-	c.write(conn, msg)
 	// Get response and return error if there was one
 	err = c.response(conn, nil)
 
@@ -446,13 +422,22 @@ func (c *Client) Ping() (err error) {
 
 // Get the client Id
 func (c *Client) Id() (id string, err error) {
+	return c.IdContext(context.Background())
+}
+
+// Get the client Id, the request is aborted when the context is done
+func (c *Client) IdContext(ctx context.Context) (id string, err error) {
 	// Use hardcoded request, no need to serialize
 	msg := []byte{0, 0, 0, 1, rpbGetClientIdReq}
-	err, conn := c.getConn()
+	err, conn := c.getConn(ctx)
+	if err != nil {
+		return id, err
+	}
+	err = c.write(conn, msg)
 	if err != nil {
+		c.ioError(conn, err)
 		return id, err
 	}
-	c.write(conn, msg)
 	resp := &pb.RpbGetClientIdResp{}
 	err = c.response(conn, resp)
 	if err == nil {
@@ -463,8 +448,13 @@ func (c *Client) Id() (id string, err error) {
 
 // Set the client Id
 func (c *Client) SetId(id string) (err error) {
+	return c.SetIdContext(context.Background(), id)
+}
+
+// Set the client Id, the request is aborted when the context is done
+func (c *Client) SetIdContext(ctx context.Context, id string) (err error) {
 	req := &pb.RpbSetClientIdReq{ClientId: []byte(id)}
-	err, conn := c.request(req, rpbSetClientIdReq)
+	err, conn := c.requestContext(ctx, req, rpbSetClientIdReq)
 	if err != nil {
 		return err
 	}
@@ -474,12 +464,21 @@ func (c *Client) SetId(id string) (err error) {
 
 // Get the server version
 func (c *Client) ServerVersion() (node string, version string, err error) {
+	return c.ServerVersionContext(context.Background())
+}
+
+// Get the server version, the request is aborted when the context is done
+func (c *Client) ServerVersionContext(ctx context.Context) (node string, version string, err error) {
 	msg := []byte{0, 0, 0, 1, rpbGetServerInfoReq}
-	err, conn := c.getConn()
+	err, conn := c.getConn(ctx)
+	if err != nil {
+		return node, version, err
+	}
+	err = c.write(conn, msg)
 	if err != nil {
+		c.ioError(conn, err)
 		return node, version, err
 	}
-	c.write(conn, msg)
 	resp := &pb.RpbGetServerInfoResp{}
 	err = c.response(conn, resp)
 	if err == nil {
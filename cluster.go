@@ -0,0 +1,111 @@
+package riak
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Policy used to spread requests over the nodes in a cluster
+type Balancer int
+
+const (
+	// Use the healthy nodes in turn
+	RoundRobin Balancer = iota
+	// Use the healthy node with the fewest requests in progress
+	LeastOutstanding
+)
+
+// Default time to wait before probing a node that was marked as down
+const DefaultProbeInterval = 5 * time.Second
+
+var (
+	NoNodes = errors.New("No Riak nodes configured")
+)
+
+/*
+Returns a new Client that spreads requests over multiple Riak nodes, each node
+gets its own pool of count connections. Nodes that fail to connect or return
+I/O errors are marked as down and skipped until a Ping succeeds again, for
+example:
+
+	client := riak.NewClusterClient([]string{"10.0.0.1:8087", "10.0.0.2:8087"}, 4)
+	err := client.Connect()
+
+All operations (Bucket, RObject, Model, MapReduce, Search) work exactly as with
+a single node Client.
+*/
+func NewClusterClient(addrs []string, count int) *Client {
+	ret := &Client{readTimeout: 1e8, writeTimeout: 1e8, conn_count: count, minConns: count, probeInterval: DefaultProbeInterval, bucketResolvers: new(sync.Map),
+		compression: new(sync.Map), codecs: new(sync.Map), contentCodecs: new(sync.Map), contentTypes: new(sync.Map)}
+	for _, addr := range addrs {
+		ret.nodes = append(ret.nodes, newNode(ret, addr, count))
+	}
+	return ret
+}
+
+// Set the policy used to pick a node for each request, default is RoundRobin
+func (c *Client) SetBalancer(balancer Balancer) {
+	c.balancer = balancer
+}
+
+// Set the time to wait before a node that is down is probed again
+func (c *Client) SetProbeInterval(interval time.Duration) {
+	c.probeInterval = interval
+}
+
+// Return the status of all nodes in the cluster
+func (c *Client) Nodes() []NodeStatus {
+	status := make([]NodeStatus, len(c.nodes))
+	for i, n := range c.nodes {
+		status[i] = n.status()
+	}
+	return status
+}
+
+// Pick a node to send the next request to. Nodes that are down are skipped,
+// but when all nodes are down the node that has been down the longest is
+// returned so a request can still try to reconnect.
+func (c *Client) pickNode() (*node, error) {
+	if len(c.nodes) == 0 {
+		return nil, NoNodes
+	}
+	if len(c.nodes) == 1 {
+		return c.nodes[0], nil
+	}
+	var picked *node
+	switch c.balancer {
+	case LeastOutstanding:
+		for _, n := range c.nodes {
+			if !n.isUp() {
+				n.maybeProbe()
+				continue
+			}
+			if picked == nil || atomic.LoadInt32(&n.outstanding) < atomic.LoadInt32(&picked.outstanding) {
+				picked = n
+			}
+		}
+	default:
+		start := int(atomic.AddUint32(&c.next, 1))
+		for i := 0; i < len(c.nodes); i++ {
+			n := c.nodes[(start+i)%len(c.nodes)]
+			if !n.isUp() {
+				n.maybeProbe()
+				continue
+			}
+			if picked == nil {
+				picked = n
+			}
+		}
+	}
+	if picked == nil {
+		// All nodes are down, use the one that went down first.
+		for _, n := range c.nodes {
+			if picked == nil || n.status().DownSince.Before(picked.status().DownSince) {
+				picked = n
+			}
+		}
+	}
+	return picked, nil
+}
@@ -0,0 +1,98 @@
+package riak
+
+import (
+	"github.com/bmizerany/assert"
+	"github.com/tpjg/goriakpbc/riaktest"
+	"testing"
+	"time"
+)
+
+func TestPickNodeRoundRobin(t *testing.T) {
+	client := NewClusterClient([]string{"10.0.0.1:8087", "10.0.0.2:8087", "10.0.0.3:8087"}, 1)
+	client.SetProbeInterval(time.Hour)
+
+	// Every node is used in turn
+	seen := make(map[string]int)
+	for i := 0; i < 6; i++ {
+		n, err := client.pickNode()
+		assert.T(t, err == nil)
+		seen[n.addr] += 1
+	}
+	assert.T(t, len(seen) == 3)
+	assert.T(t, seen["10.0.0.2:8087"] == 2)
+
+	// Nodes that are down are skipped
+	client.nodes[1].markDown()
+	for i := 0; i < 6; i++ {
+		n, _ := client.pickNode()
+		assert.T(t, n.addr != "10.0.0.2:8087")
+	}
+	status := client.Nodes()
+	assert.T(t, status[1].Up == false)
+	assert.T(t, !status[1].DownSince.IsZero())
+
+	// When all nodes are down the node that went down first is returned
+	time.Sleep(time.Millisecond)
+	client.nodes[0].markDown()
+	client.nodes[2].markDown()
+	n, _ := client.pickNode()
+	assert.T(t, n.addr == "10.0.0.2:8087")
+}
+
+func TestPickNodeLeastOutstanding(t *testing.T) {
+	client := NewClusterClient([]string{"10.0.0.1:8087", "10.0.0.2:8087"}, 1)
+	client.SetBalancer(LeastOutstanding)
+	client.nodes[0].outstanding = 3
+	client.nodes[1].outstanding = 1
+	n, err := client.pickNode()
+	assert.T(t, err == nil)
+	assert.T(t, n.addr == "10.0.0.2:8087")
+
+	// No nodes at all
+	client = NewClusterClient([]string{}, 1)
+	_, err = client.pickNode()
+	assert.T(t, err == NoNodes)
+}
+
+func TestClusterWithDownNode(t *testing.T) {
+	// Port 8088 should not accept connections, the node is marked as down and
+	// all requests go to the remaining node.
+	client := NewClusterClient([]string{"127.0.0.1:8088", riakhost}, 2)
+	client.SetProbeInterval(time.Hour)
+	err := client.Connect()
+	assert.T(t, err == nil)
+	status := client.Nodes()
+	assert.T(t, status[0].Up == false)
+	assert.T(t, status[1].Up == true)
+
+	for i := 0; i < 10; i++ {
+		assert.T(t, client.Ping() == nil)
+	}
+	bucket, err := client.NewBucket("cluster_test.go")
+	assert.T(t, err == nil)
+	obj := bucket.NewObject("abc")
+	obj.ContentType = "text/plain"
+	obj.Data = []byte("cluster data")
+	assert.T(t, obj.Store() == nil)
+	assert.T(t, obj.Destroy() == nil)
+	client.Close()
+
+	// No node can be reached
+	client = NewClusterClient([]string{"127.0.0.1:8088", "127.0.0.1:8089"}, 1)
+	err = client.Connect()
+	assert.T(t, err != nil)
+}
+
+func TestRedialMarksUp(t *testing.T) {
+	// A single node is never probed, a connection that is dialed again marks
+	// it as up
+	server, client, done := setupServer(t, 1)
+	defer done()
+	var count int32
+	server.SetFault(failFirst(riaktest.PingReq, 1, riaktest.CloseConnection, &count))
+	assert.T(t, client.Ping() != nil)
+	client.nodes[0].markDown()
+	assert.T(t, client.Nodes()[0].Up == false)
+	assert.T(t, client.Ping() == nil)
+	assert.T(t, client.Nodes()[0].Up == true)
+}
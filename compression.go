@@ -0,0 +1,178 @@
+package riak
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+)
+
+/*
+A Codec compresses the values of objects, the name of its encoding is stored
+as the content_encoding of the value so it can be decompressed when it is
+read. Gzip is built in, other codecs must be registered with the client
+(RegisterCodec or SetBucketCompression) to decompress their values.
+*/
+type Codec interface {
+	Encoding() string // The content encoding, e.g. "gzip"
+	Encode(data []byte) ([]byte, error)
+	Decode(data []byte) ([]byte, error)
+}
+
+// Compresses values with gzip, using the default compression level
+var Gzip Codec = gzipCodec{gzip.DefaultCompression}
+
+// Return a gzip codec with the given compression level, see compress/gzip
+func GzipLevel(level int) Codec {
+	return gzipCodec{level}
+}
+
+type gzipCodec struct {
+	level int
+}
+
+func (gzipCodec) Encoding() string {
+	return "gzip"
+}
+
+func (g gzipCodec) Encode(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, g.level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCodec) Decode(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+/*
+A compression policy for a bucket: values of at least MinSize bytes are
+compressed with the Codec when they are stored. Smaller values are stored as
+is, as compressing them hardly saves anything.
+*/
+type Compression struct {
+	Codec   Codec
+	MinSize int
+}
+
+// Returned when a value could not be compressed or decompressed
+type CodecError struct {
+	Encoding string
+	Err      error
+}
+
+func (e *CodecError) Error() string {
+	return fmt.Sprintf("Content encoding %q: %v", e.Encoding, e.Err)
+}
+
+func (e *CodecError) Unwrap() error {
+	return e.Err
+}
+
+/*
+Set the compression policy for the objects in a bucket, for all Bucket values
+of the bucket (including the ones used by RFile), nil turns compression off.
+Values that are already compressed can still be read, the codec of the policy
+is registered with the client.
+*/
+func (c *Client) SetBucketCompression(btype string, bucket string, compression *Compression) {
+	if btype == "" {
+		btype = "default"
+	}
+	if compression == nil {
+		c.compression.Delete(bucketName{btype, bucket})
+		return
+	}
+	if compression.Codec != nil {
+		c.RegisterCodec(compression.Codec)
+	}
+	c.compression.Store(bucketName{btype, bucket}, compression)
+}
+
+// Register a codec to decompress values with its content encoding, e.g. for
+// values that are compressed by another application
+func (c *Client) RegisterCodec(codec Codec) {
+	c.codecs.Store(codec.Encoding(), codec)
+}
+
+// Return the codec for a content encoding, nil if it is unknown
+func (c *Client) codec(encoding string) Codec {
+	if codec, ok := c.codecs.Load(encoding); ok {
+		return codec.(Codec)
+	}
+	if encoding == Gzip.Encoding() {
+		return Gzip
+	}
+	return nil
+}
+
+// Set the compression policy of the bucket, see Client.SetBucketCompression
+func (b *Bucket) SetCompression(compression *Compression) {
+	b.client.SetBucketCompression(b.bucket_type, b.name, compression)
+}
+
+// Return the compression policy of the bucket, nil if values are not compressed
+func (b *Bucket) Compression() *Compression {
+	if c, ok := b.client.compression.Load(bucketName{b.bucket_type, b.name}); ok {
+		return c.(*Compression)
+	}
+	return nil
+}
+
+/*
+Encode a value for storing, returns the value and its content encoding. A
+value that has an encoding (e.g. because it was compressed when it was
+fetched) is compressed with the same codec, otherwise the compression policy
+of the bucket decides. Values with an unknown encoding are stored as is.
+*/
+func (b *Bucket) encode(encoding string, data []byte) ([]byte, string, error) {
+	var codec Codec
+	if len(data) == 0 {
+		return data, encoding, nil
+	} else if encoding != "" {
+		if codec = b.client.codec(encoding); codec == nil {
+			return data, encoding, nil
+		}
+	} else if c := b.Compression(); c != nil && c.Codec != nil && len(data) >= c.MinSize {
+		codec = c.Codec
+		encoding = codec.Encoding()
+	} else {
+		return data, "", nil
+	}
+	value, err := codec.Encode(data)
+	if err != nil {
+		return nil, "", &CodecError{encoding, err}
+	}
+	return value, encoding, nil
+}
+
+// Decode a fetched value, values with an unknown encoding are returned as is.
+// The value is empty for Head.
+func (b *Bucket) decode(encoding string, value []byte) ([]byte, error) {
+	if encoding == "" || len(value) == 0 {
+		return value, nil
+	}
+	codec := b.client.codec(encoding)
+	if codec == nil {
+		return value, nil
+	}
+	data, err := codec.Decode(value)
+	if err != nil {
+		return nil, &CodecError{encoding, err}
+	}
+	return data, nil
+}
@@ -0,0 +1,150 @@
+package riak
+
+import (
+	"bytes"
+	"errors"
+	"github.com/bmizerany/assert"
+	"github.com/golang/protobuf/proto"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/tpjg/goriakpbc/pb"
+	"github.com/tpjg/goriakpbc/riaktest"
+)
+
+// Reverses the bytes of a value, to test a custom codec
+type reverseCodec struct{}
+
+func (reverseCodec) Encoding() string { return "reverse" }
+
+func (reverseCodec) Encode(data []byte) ([]byte, error) {
+	out := make([]byte, len(data))
+	for i, b := range data {
+		out[len(data)-1-i] = b
+	}
+	return out, nil
+}
+
+func (r reverseCodec) Decode(data []byte) ([]byte, error) {
+	if bytes.HasPrefix(data, []byte("bad")) {
+		return nil, errors.New("bad value")
+	}
+	return r.Encode(data)
+}
+
+func TestCompression(t *testing.T) {
+	server, client, done := setupServer(t, 1)
+	defer done()
+	bucket, err := client.NewBucket("compression_test.go")
+	assert.T(t, err == nil)
+	bucket.SetCompression(&Compression{Codec: Gzip, MinSize: 100})
+	assert.T(t, bucket.Compression().Codec == Gzip)
+	var stored *pb.RpbContent
+	server.SetFault(func(code byte, req proto.Message) error {
+		if code == riaktest.PutReq {
+			stored = req.(*pb.RpbPutReq).Content
+		}
+		return nil
+	})
+
+	// Small values are not compressed, large values are
+	large := strings.Repeat(`{"name": "value"}`, 100)
+	obj := bucket.NewObject("small")
+	obj.ContentType = "application/json"
+	obj.Charset = "utf-8"
+	obj.Data = []byte(`{"name": "value"}`)
+	assert.T(t, obj.Store() == nil)
+	assert.T(t, stored.ContentEncoding == nil && string(stored.Charset) == "utf-8")
+	obj = bucket.NewObject("large")
+	obj.ContentType = "application/json"
+	obj.Data = []byte(large)
+	assert.T(t, obj.Store() == nil)
+	assert.T(t, string(stored.ContentEncoding) == "gzip" && len(stored.Value) < len(large)/10)
+	assert.T(t, obj.ContentEncoding == "gzip" && string(obj.Data) == large)
+
+	// Values are decompressed when they are read, by any Bucket value
+	other, err := client.NewBucket("compression_test.go")
+	assert.T(t, err == nil)
+	obj, err = other.Get("large")
+	assert.T(t, err == nil)
+	assert.T(t, obj.ContentEncoding == "gzip" && string(obj.Data) == large)
+	obj, err = bucket.Get("small")
+	assert.T(t, err == nil)
+	assert.T(t, obj.ContentEncoding == "" && obj.Charset == "utf-8")
+	obj, err = bucket.Head("large")
+	assert.T(t, err == nil && len(obj.Data) == 0)
+
+	// A compressed value stays compressed, even without the policy
+	bucket.SetCompression(nil)
+	assert.T(t, bucket.Compression() == nil)
+	obj, err = bucket.Get("large")
+	assert.T(t, err == nil)
+	obj.Data = []byte(large + large)
+	assert.T(t, obj.Store() == nil)
+	assert.T(t, string(stored.ContentEncoding) == "gzip")
+	assert.T(t, obj.Reload() == nil)
+	assert.T(t, string(obj.Data) == large+large)
+	server.SetFault(nil)
+
+	// Siblings are decompressed as well
+	assert.T(t, bucket.SetAllowMult(true) == nil)
+	for _, data := range []string{"a", large} {
+		obj = bucket.NewObject("siblings")
+		obj.ContentType = "text/plain"
+		obj.ContentEncoding = "gzip"
+		obj.Data = []byte(data)
+		assert.T(t, obj.Store() == nil)
+	}
+	obj, err = bucket.Get("siblings")
+	assert.T(t, err == nil && obj.Conflict())
+	assert.T(t, string(obj.Siblings[0].Data) == "a" && string(obj.Siblings[1].Data) == large)
+	assert.T(t, obj.Siblings[1].ContentEncoding == "gzip")
+
+	// A custom codec, values with an unknown encoding are not decoded
+	custom, err := client.NewBucket("custom")
+	assert.T(t, err == nil)
+	obj = custom.NewObject("key")
+	obj.ContentType = "text/plain"
+	obj.ContentEncoding = "reverse"
+	obj.Data = []byte("bad")
+	assert.T(t, obj.Store() == nil)
+	obj, err = custom.Get("key")
+	assert.T(t, err == nil)
+	assert.T(t, obj.ContentEncoding == "reverse" && string(obj.Data) == "bad")
+	client.RegisterCodec(reverseCodec{})
+	obj, err = custom.Get("key")
+	var cerr *CodecError
+	assert.T(t, errors.As(err, &cerr) && cerr.Encoding == "reverse")
+	custom.SetCompression(&Compression{Codec: reverseCodec{}})
+	obj = custom.NewObject("key")
+	obj.ContentType = "text/plain"
+	obj.Data = []byte("good")
+	assert.T(t, obj.Store() == nil)
+	obj, err = client.GetFrom("custom", "key")
+	assert.T(t, err == nil)
+	assert.T(t, obj.ContentEncoding == "reverse" && string(obj.Data) == "good")
+}
+
+func TestCompressedFile(t *testing.T) {
+	client := setupConnection(t)
+	defer client.Close()
+	client.SetBucketCompression("", "compression_test.go.files", &Compression{Codec: GzipLevel(9), MinSize: 10})
+
+	// The chunks are compressed when written and decompressed when read
+	f, err := client.CreateFile("compression_test.go.files", "file", "text/plain", 1000)
+	assert.T(t, err == nil)
+	data := []byte(strings.Repeat("0123456789", 250))
+	n, err := f.Write(data)
+	assert.T(t, err == nil && n == len(data))
+	obj, err := client.GetFrom("compression_test.go.files", "file-000001")
+	assert.T(t, err == nil && obj.ContentEncoding == "gzip")
+
+	f, err = client.OpenFile("compression_test.go.files", "file")
+	assert.T(t, err == nil)
+	assert.T(t, f.Size() == len(data))
+	read := make([]byte, len(data))
+	n, err = f.Read(read)
+	assert.T(t, err == nil || err == io.EOF)
+	assert.T(t, n == len(data) && bytes.Equal(read, data))
+}
@@ -0,0 +1,259 @@
+package riak
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"mime"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+)
+
+/*
+A ContentCodec marshals values to and from the data of objects with a certain
+content type, see RObject.Encode and RObject.Decode. Codecs for JSON, gob,
+protocol buffers, binary data and plain text are built in, others can be
+registered with Client.RegisterContentCodec.
+*/
+type ContentCodec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// The content types of the built-in codecs
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeGob      = "application/x-gob"
+	ContentTypeProtobuf = "application/x-protobuf"
+	ContentTypeBinary   = "application/octet-stream"
+	ContentTypeText     = "text/plain"
+)
+
+// Built-in codecs
+var (
+	// Marshals values with encoding/json
+	JSONCodec ContentCodec = jsonCodec{}
+	// Marshals values with encoding/gob
+	GobCodec ContentCodec = gobCodec{}
+	// Marshals values that implement proto.Message
+	ProtobufCodec ContentCodec = protobufCodec{}
+	// Stores a []byte as is, decodes into a *[]byte
+	BinaryCodec ContentCodec = binaryCodec{}
+	// Stores a string or a []byte as is, decodes into a *string or a *[]byte
+	TextCodec ContentCodec = textCodec{}
+)
+
+var defaultContentCodecs = map[string]ContentCodec{
+	ContentTypeJSON:     JSONCodec,
+	ContentTypeGob:      GobCodec,
+	ContentTypeProtobuf: ProtobufCodec,
+	ContentTypeBinary:   BinaryCodec,
+	ContentTypeText:     TextCodec,
+}
+
+// Error definitions
+var (
+	UnknownContentType = errors.New("No codec registered for the content type")
+	UnsupportedValue   = errors.New("Value type is not supported by the codec")
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type protobufCodec struct{}
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, UnsupportedValue
+	}
+	return proto.Marshal(msg)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return UnsupportedValue
+	}
+	return proto.Unmarshal(data, msg)
+}
+
+type binaryCodec struct{}
+
+func (binaryCodec) Marshal(v interface{}) ([]byte, error) {
+	if data, ok := v.([]byte); ok {
+		return data, nil
+	}
+	return nil, UnsupportedValue
+}
+
+func (binaryCodec) Unmarshal(data []byte, v interface{}) error {
+	if p, ok := v.(*[]byte); ok {
+		*p = append([]byte(nil), data...)
+		return nil
+	}
+	return UnsupportedValue
+}
+
+type textCodec struct{}
+
+func (textCodec) Marshal(v interface{}) ([]byte, error) {
+	if s, ok := v.(string); ok {
+		return []byte(s), nil
+	}
+	return binaryCodec{}.Marshal(v)
+}
+
+func (textCodec) Unmarshal(data []byte, v interface{}) error {
+	if p, ok := v.(*string); ok {
+		*p = string(data)
+		return nil
+	}
+	return binaryCodec{}.Unmarshal(data, v)
+}
+
+// Register a codec for a content type, it takes precedence over a built-in
+// codec for the same type. A nil codec removes it.
+func (c *Client) RegisterContentCodec(contentType string, codec ContentCodec) {
+	contentType = mediaType(contentType)
+	if codec == nil {
+		c.contentCodecs.Delete(contentType)
+	} else {
+		c.contentCodecs.Store(contentType, codec)
+	}
+}
+
+// Return the codec for a content type, parameters like the charset are
+// ignored. Returns nil if there is no codec.
+func (c *Client) contentCodec(contentType string) ContentCodec {
+	contentType = mediaType(contentType)
+	if codec, ok := c.contentCodecs.Load(contentType); ok {
+		return codec.(ContentCodec)
+	}
+	return defaultContentCodecs[contentType]
+}
+
+// Return the media type of a content type, without the parameters
+func mediaType(contentType string) string {
+	if t, _, err := mime.ParseMediaType(contentType); err == nil {
+		return t
+	}
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
+
+/*
+Set the content type that Encode uses for the objects in a bucket that have
+no content type yet, for all Bucket values of the bucket. An empty content
+type removes it.
+*/
+func (c *Client) SetBucketContentType(btype string, bucket string, contentType string) {
+	if btype == "" {
+		btype = "default"
+	}
+	if contentType == "" {
+		c.contentTypes.Delete(bucketName{btype, bucket})
+	} else {
+		c.contentTypes.Store(bucketName{btype, bucket}, contentType)
+	}
+}
+
+// Set the content type of the bucket, see Client.SetBucketContentType
+func (b *Bucket) SetContentType(contentType string) {
+	b.client.SetBucketContentType(b.bucket_type, b.name, contentType)
+}
+
+// Return the content type of the bucket, empty if there is none
+func (b *Bucket) ContentType() string {
+	if t, ok := b.client.contentTypes.Load(bucketName{b.bucket_type, b.name}); ok {
+		return t.(string)
+	}
+	return ""
+}
+
+/*
+Marshal a value into the data of the object with the codec of its content
+type. If the object has no content type yet the content type of the bucket is
+used, or one that suits the value: protocol buffers for a proto.Message,
+binary data for a []byte, plain text for a string and JSON for anything else.
+
+	obj := bucket.NewObject("key")
+	err := obj.Encode(&user)
+	...
+	err = obj.Store()
+*/
+func (obj *RObject) Encode(v interface{}) error {
+	contentType := obj.ContentType
+	if contentType == "" {
+		contentType = obj.Bucket.ContentType()
+	}
+	if contentType == "" {
+		contentType = contentTypeOf(v)
+	}
+	codec := obj.Bucket.client.contentCodec(contentType)
+	if codec == nil {
+		return UnknownContentType
+	}
+	data, err := codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	obj.ContentType = contentType
+	obj.Data = data
+	return nil
+}
+
+// Return the content type of the built-in codec that suits a value
+func contentTypeOf(v interface{}) string {
+	switch v.(type) {
+	case proto.Message:
+		return ContentTypeProtobuf
+	case []byte:
+		return ContentTypeBinary
+	case string:
+		return ContentTypeText
+	}
+	return ContentTypeJSON
+}
+
+/*
+Unmarshal the data of the object into v (usually a pointer) with the codec of
+its content type, or the content type of the bucket if the object has none.
+Returns Unresolved if the object has siblings, resolve them first.
+*/
+func (obj *RObject) Decode(v interface{}) error {
+	if obj.conflict {
+		return Unresolved
+	}
+	contentType := obj.ContentType
+	if contentType == "" {
+		contentType = obj.Bucket.ContentType()
+	}
+	codec := obj.Bucket.client.contentCodec(contentType)
+	if codec == nil {
+		return UnknownContentType
+	}
+	return codec.Unmarshal(obj.Data, v)
+}
@@ -0,0 +1,119 @@
+package riak
+
+import (
+	"github.com/bmizerany/assert"
+	"strings"
+	"testing"
+
+	"github.com/tpjg/goriakpbc/pb"
+)
+
+type contentUser struct {
+	Name string
+	Age  int
+}
+
+// Stores a []string as comma separated values, to test a custom codec
+type csvCodec struct{}
+
+func (csvCodec) Marshal(v interface{}) ([]byte, error) {
+	fields, ok := v.([]string)
+	if !ok {
+		return nil, UnsupportedValue
+	}
+	return []byte(strings.Join(fields, ",")), nil
+}
+
+func (csvCodec) Unmarshal(data []byte, v interface{}) error {
+	p, ok := v.(*[]string)
+	if !ok {
+		return UnsupportedValue
+	}
+	*p = strings.Split(string(data), ",")
+	return nil
+}
+
+func TestContentCodecs(t *testing.T) {
+	client := setupConnection(t)
+	defer client.Close()
+	bucket, err := client.NewBucket("content_test.go")
+	assert.T(t, err == nil)
+
+	// The content type is chosen to suit the value and is stored
+	user := contentUser{"ann", 42}
+	values := map[string]interface{}{"json": &user, "proto": &pb.RpbPair{Key: []byte("k"), Value: []byte("v")},
+		"binary": []byte{0, 1, 2}, "text": "hello"}
+	for key, v := range values {
+		obj := bucket.NewObject(key)
+		assert.T(t, obj.Encode(v) == nil)
+		assert.T(t, obj.Store() == nil)
+	}
+	obj, err := bucket.Get("json")
+	assert.T(t, err == nil && obj.ContentType == ContentTypeJSON)
+	assert.T(t, string(obj.Data) == `{"Name":"ann","Age":42}`)
+	var u contentUser
+	assert.T(t, obj.Decode(&u) == nil)
+	assert.Equal(t, u, user)
+	obj, err = bucket.Get("proto")
+	assert.T(t, err == nil && obj.ContentType == ContentTypeProtobuf)
+	var pair pb.RpbPair
+	assert.T(t, obj.Decode(&pair) == nil)
+	assert.T(t, string(pair.Key) == "k" && string(pair.Value) == "v")
+	obj, err = bucket.Get("binary")
+	assert.T(t, err == nil && obj.ContentType == ContentTypeBinary)
+	var data []byte
+	assert.T(t, obj.Decode(&data) == nil)
+	assert.Equal(t, data, []byte{0, 1, 2})
+	assert.T(t, obj.Decode(&u) == UnsupportedValue)
+	obj, err = bucket.Get("text")
+	assert.T(t, err == nil && obj.ContentType == ContentTypeText)
+	var s string
+	assert.T(t, obj.Decode(&s) == nil && s == "hello")
+
+	// The content type of the object or of the bucket takes precedence,
+	// parameters are ignored
+	bucket.SetContentType(ContentTypeGob)
+	assert.T(t, bucket.ContentType() == ContentTypeGob)
+	obj = bucket.NewObject("gob")
+	assert.T(t, obj.Encode(&user) == nil)
+	assert.T(t, obj.ContentType == ContentTypeGob)
+	obj.ContentType = ""
+	u = contentUser{}
+	assert.T(t, obj.Decode(&u) == nil)
+	assert.Equal(t, u, user)
+	obj = bucket.NewObject("charset")
+	obj.ContentType = "text/plain; charset=utf-8"
+	assert.T(t, obj.Encode("hello") == nil)
+	assert.T(t, obj.Decode(&s) == nil && s == "hello")
+	bucket.SetContentType("")
+	assert.T(t, bucket.ContentType() == "")
+
+	// Custom codecs
+	obj = bucket.NewObject("csv")
+	obj.ContentType = "text/csv"
+	assert.T(t, obj.Encode([]string{"a", "b"}) == UnknownContentType)
+	client.RegisterContentCodec("text/csv", csvCodec{})
+	assert.T(t, obj.Encode([]string{"a", "b"}) == nil)
+	assert.T(t, obj.Store() == nil)
+	other, err := client.NewBucket("content_test.go")
+	assert.T(t, err == nil)
+	obj, err = other.Get("csv")
+	assert.T(t, err == nil)
+	var fields []string
+	assert.T(t, obj.Decode(&fields) == nil)
+	assert.Equal(t, fields, []string{"a", "b"})
+	client.RegisterContentCodec("text/csv", nil)
+	assert.T(t, obj.Decode(&fields) == UnknownContentType)
+
+	// Siblings must be resolved first
+	assert.T(t, bucket.SetAllowMult(true) == nil)
+	for _, text := range []string{"a", "b"} {
+		obj = bucket.NewObject("siblings")
+		assert.T(t, obj.Encode(text) == nil)
+		assert.T(t, obj.Store() == nil)
+	}
+	obj, err = bucket.Get("siblings")
+	assert.T(t, err == nil)
+	assert.T(t, obj.Decode(&s) == Unresolved)
+	assert.T(t, bucket.SetAllowMult(false) == nil)
+}
@@ -1,6 +1,8 @@
 package riak
 
 import (
+	"context"
+
 	"github.com/tpjg/goriakpbc/pb"
 )
 
@@ -13,6 +15,11 @@ type Counter struct {
 
 // Reload the value of a counter
 func (c *Counter) Reload() (err error) {
+	return c.ReloadContext(context.Background())
+}
+
+// Reload the value of a counter, the request is aborted when the context is done
+func (c *Counter) ReloadContext(ctx context.Context) (err error) {
 	t := true
 
 	req := &pb.RpbCounterGetReq{
@@ -21,24 +28,14 @@ func (c *Counter) Reload() (err error) {
 		NotfoundOk: &t,
 	}
 
-	for _, omap := range c.Options {
-		for k, v := range omap {
-			switch k {
-			case "r":
-				req.R = &v
-			case "pr":
-				req.Pr = &v
-			}
-		}
-	}
-
-	err, conn := c.Bucket.client.request(req, rpbCounterGetReq)
+	opts, err := keptOptions("Reload", counterGetOptionKeys, c.Options, counterOperations...)
 	if err != nil {
 		return err
 	}
+	opts.applyCounterGet(req)
 
 	resp := &pb.RpbCounterGetResp{}
-	err = c.Bucket.client.response(conn, resp)
+	err = c.Bucket.client.do(ctx, true, req, rpbCounterGetReq, resp)
 	if err != nil {
 		return err
 	}
@@ -50,25 +47,45 @@ func (c *Counter) Reload() (err error) {
 
 // Increment a counter by a given amount
 func (c *Counter) Increment(amount int64) (err error) {
-	return c.increment(amount, false)
+	return c.IncrementContext(context.Background(), amount)
+}
+
+// Increment a counter by a given amount, the request is aborted when the context is done
+func (c *Counter) IncrementContext(ctx context.Context, amount int64) (err error) {
+	return c.increment(ctx, amount, false)
 }
 
 // Increment a counter by a given amount and reload its value
 func (c *Counter) IncrementAndReload(amount int64) (err error) {
-	return c.increment(amount, true)
+	return c.IncrementAndReloadContext(context.Background(), amount)
+}
+
+// Increment a counter by a given amount and reload its value, the request is aborted when the context is done
+func (c *Counter) IncrementAndReloadContext(ctx context.Context, amount int64) (err error) {
+	return c.increment(ctx, amount, true)
 }
 
 // Decrement a counter by a given amount
 func (c *Counter) Decrement(amount int64) (err error) {
-	return c.increment(-amount, false)
+	return c.DecrementContext(context.Background(), amount)
+}
+
+// Decrement a counter by a given amount, the request is aborted when the context is done
+func (c *Counter) DecrementContext(ctx context.Context, amount int64) (err error) {
+	return c.increment(ctx, -amount, false)
 }
 
 // Decrement a counter by a given amount and reload its value
 func (c *Counter) DecrementAndReload(amount int64) (err error) {
-	return c.increment(-amount, true)
+	return c.DecrementAndReloadContext(context.Background(), amount)
+}
+
+// Decrement a counter by a given amount and reload its value, the request is aborted when the context is done
+func (c *Counter) DecrementAndReloadContext(ctx context.Context, amount int64) (err error) {
+	return c.increment(ctx, -amount, true)
 }
 
-func (c *Counter) increment(amount int64, reload bool) (err error) {
+func (c *Counter) increment(ctx context.Context, amount int64, reload bool) (err error) {
 	req := &pb.RpbCounterUpdateReq{
 		Bucket:      []byte(c.Bucket.name),
 		Key:         []byte(c.Key),
@@ -76,26 +93,14 @@ func (c *Counter) increment(amount int64, reload bool) (err error) {
 		Returnvalue: &reload,
 	}
 
-	for _, omap := range c.Options {
-		for k, v := range omap {
-			switch k {
-			case "w":
-				req.W = &v
-			case "dw":
-				req.Dw = &v
-			case "pw":
-				req.Pw = &v
-			}
-		}
-	}
-
-	err, conn := c.Bucket.client.request(req, rpbCounterUpdateReq)
+	opts, err := keptOptions("Increment", counterUpdateOptionKeys, c.Options, counterOperations...)
 	if err != nil {
 		return err
 	}
+	opts.applyCounterUpdate(req)
 
 	resp := &pb.RpbCounterUpdateResp{}
-	err = c.Bucket.client.response(conn, resp)
+	err = c.Bucket.client.do(ctx, false, req, rpbCounterUpdateReq, resp)
 	if err != nil {
 		return err
 	}
@@ -109,6 +114,11 @@ func (c *Counter) increment(amount int64, reload bool) (err error) {
 
 // Destroy the counter
 func (c *Counter) Destroy() (err error) {
+	return c.DestroyContext(context.Background())
+}
+
+// Destroy the counter, the request is aborted when the context is done
+func (c *Counter) DestroyContext(ctx context.Context) (err error) {
 	all := QuorumAll
 	f := false
 
@@ -122,30 +132,13 @@ func (c *Counter) Destroy() (err error) {
 		SloppyQuorum: &f,
 	}
 
-	for _, omap := range c.Options {
-		for k, v := range omap {
-			switch k {
-			case "r":
-				req.R = &v
-			case "pr":
-				req.Pr = &v
-			case "rw":
-				req.Rw = &v
-			case "w":
-				req.W = &v
-			case "dw":
-				req.Dw = &v
-			case "pw":
-				req.Pw = &v
-			}
-		}
-	}
-
-	err, conn := c.Bucket.client.request(req, rpbDelReq)
+	opts, err := keptOptions("Destroy", delOptionKeys, c.Options, counterOperations...)
 	if err != nil {
 		return err
 	}
-	err = c.Bucket.client.response(conn, req)
+	opts.applyDel(req)
+
+	err = c.Bucket.client.do(ctx, false, req, rpbDelReq, req)
 	if err != nil {
 		return err
 	}
@@ -154,13 +147,21 @@ func (c *Counter) Destroy() (err error) {
 
 // Get a counter
 func (b *Bucket) GetCounter(key string, options ...map[string]uint32) (c *Counter, err error) {
+	return b.GetCounterContext(context.Background(), key, options...)
+}
+
+// Get a counter, the request is aborted when the context is done
+func (b *Bucket) GetCounterContext(ctx context.Context, key string, options ...map[string]uint32) (c *Counter, err error) {
+	if _, err = parseOptions("GetCounter", counterGetOptionKeys, options); err != nil {
+		return nil, err
+	}
 	c = &Counter{
 		Bucket:  b,
 		Key:     key,
 		Options: options,
 	}
 
-	err = c.Reload()
+	err = c.ReloadContext(ctx)
 
 	return
 }
@@ -177,10 +178,15 @@ func (b *Bucket) GetCounterWithoutLoad(key string, options ...map[string]uint32)
 
 // Get counter directly from a bucket, without creating a bucket first
 func (c *Client) GetCounterFrom(bucketname string, key string, options ...map[string]uint32) (counter *Counter, err error) {
+	return c.GetCounterFromContext(context.Background(), bucketname, key, options...)
+}
+
+// Get counter directly from a bucket, without creating a bucket first, the request is aborted when the context is done
+func (c *Client) GetCounterFromContext(ctx context.Context, bucketname string, key string, options ...map[string]uint32) (counter *Counter, err error) {
 	var bucket *Bucket
-	bucket, err = c.Bucket(bucketname)
+	bucket, err = c.NewBucketContext(ctx, bucketname)
 	if err != nil {
 		return
 	}
-	return bucket.GetCounter(key, options...)
+	return bucket.GetCounterContext(ctx, key, options...)
 }
@@ -6,12 +6,12 @@ import (
 )
 
 func setupDefaultConnection(t *testing.T) {
-	err := ConnectClient("127.0.0.1:8087")
+	err := ConnectClient(riakhost)
 	assert.T(t, err == nil)
 }
 
 func setupDefaultConnections(t *testing.T, count int) {
-	err := ConnectClientPool("127.0.0.1:8087", count)
+	err := ConnectClientPool(riakhost, count)
 	assert.T(t, err == nil)
 }
 
@@ -60,6 +60,7 @@ func TestDefaultGetAndDeleteDirectly(t *testing.T) {
 }
 
 func TestDefaultRunMapReduce(t *testing.T) {
+	needsRiak(t)
 	// Preparations
 	setupDefaultConnection(t)
 	bucket, _ := NewBucket("client_test.go")
@@ -91,6 +92,7 @@ func TestDefaultRunMapReduce(t *testing.T) {
 }
 
 func TestDefaultMapReduce(t *testing.T) {
+	needsRiak(t)
 	// Preparations
 	setupDefaultConnection(t)
 	bucket, _ := NewBucket("client_test.go")
@@ -0,0 +1,96 @@
+package riak
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tpjg/goriakpbc/pb"
+)
+
+// Error classes, these can be used with errors.Is to check what kind of error
+// was returned, e.g. errors.Is(err, riak.Overload). NotFound, ObjectExists and
+// ObjectModified can be used the same way.
+var (
+	Overload           = errors.New("Riak is overloaded")
+	RequestTimeout     = errors.New("Request timed out in Riak")
+	PreconditionFailed = errors.New("Precondition failed")
+	SiblingConflict    = errors.New("Object has siblings")
+)
+
+/*
+RiakError is an error response from Riak, it holds the error code and the
+message. Riak mostly uses the message to tell what went wrong, so errors.Is
+uses the message to match a RiakError with the error classes:
+
+	if errors.Is(err, riak.Overload) {
+		// Back off
+	}
+*/
+type RiakError struct {
+	Code    uint32
+	Message string
+}
+
+func (e *RiakError) Error() string {
+	return e.Message
+}
+
+func (e *RiakError) Is(target error) bool {
+	switch target {
+	case NotFound:
+		return e.Message == "notfound"
+	case Overload:
+		return e.Message == "overload"
+	case RequestTimeout:
+		return e.Message == "timeout"
+	case ObjectExists:
+		return e.Message == "match_found"
+	case ObjectModified:
+		return e.Message == "modified"
+	case PreconditionFailed:
+		return e.Message == "match_found" || e.Message == "modified" || strings.Contains(e.Message, "precondition")
+	case SiblingConflict:
+		return strings.Contains(e.Message, "siblings")
+	}
+	return false
+}
+
+// Create a RiakError from an error response
+func riakError(resp *pb.RpbErrorResp) error {
+	return &RiakError{Code: resp.GetErrcode(), Message: string(resp.GetErrmsg())}
+}
+
+// NetworkError is an I/O error that happened while talking to a node, Op is
+// the operation that was in progress, e.g. "Get" or "Connect".
+type NetworkError struct {
+	Node string
+	Op   string
+	Err  error
+}
+
+func (e *NetworkError) Error() string {
+	return fmt.Sprintf("%v on %v: %v", e.Op, e.Node, e.Err)
+}
+
+func (e *NetworkError) Unwrap() error {
+	return e.Err
+}
+
+// An error that is also matched by a more general error class
+type classifiedError struct {
+	msg   string
+	class error
+}
+
+func classified(msg string, class error) error {
+	return &classifiedError{msg: msg, class: class}
+}
+
+func (e *classifiedError) Error() string {
+	return e.msg
+}
+
+func (e *classifiedError) Is(target error) bool {
+	return target == e.class
+}
@@ -0,0 +1,95 @@
+package riak
+
+import (
+	"errors"
+	"fmt"
+	"github.com/bmizerany/assert"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/tpjg/goriakpbc/json"
+)
+
+func TestRiakErrorClasses(t *testing.T) {
+	var err error = &RiakError{Code: 0, Message: "overload"}
+	assert.T(t, errors.Is(err, Overload))
+	assert.T(t, !errors.Is(err, RequestTimeout))
+	assert.T(t, err.Error() == "overload")
+
+	// Wrapped errors are still matched
+	err = fmt.Errorf("Store failed: %w", &RiakError{Message: "timeout"})
+	assert.T(t, errors.Is(err, RequestTimeout))
+	var rerr *RiakError
+	assert.T(t, errors.As(err, &rerr))
+	assert.T(t, rerr.Message == "timeout")
+
+	assert.T(t, errors.Is(&RiakError{Message: "notfound"}, NotFound))
+	assert.T(t, errors.Is(&RiakError{Message: "match_found"}, ObjectExists))
+	assert.T(t, errors.Is(&RiakError{Message: "match_found"}, PreconditionFailed))
+	assert.T(t, errors.Is(&RiakError{Message: "modified"}, ObjectModified))
+	assert.T(t, errors.Is(&RiakError{Message: "{precondition,{not_present,<<\"a\">>}}"}, PreconditionFailed))
+
+	// Errors of the client itself
+	assert.T(t, errors.Is(ObjectExists, PreconditionFailed))
+	assert.T(t, errors.Is(ObjectModified, PreconditionFailed))
+	assert.T(t, !errors.Is(NotFound, PreconditionFailed))
+	assert.T(t, errors.Is(ResolveNotImplemented, SiblingConflict))
+}
+
+func TestModelWarning(t *testing.T) {
+	dest := &DocumentModel{}
+	dv, dt, _, _, err := check_dest(dest)
+	assert.T(t, err == nil)
+	client := NewClient(riakhost)
+
+	err = client.mapData(dv, dt, []byte(`{"_type":"DocumentModel","string_field":"text"}`), nil, dest)
+	assert.T(t, err == nil)
+
+	err = client.mapData(dv, dt, []byte(`{"_type":"Other","string_field":"text"}`), nil, dest)
+	assert.T(t, IsWarning(err))
+	assert.T(t, errors.Is(err, ModelDoesNotMatch))
+
+	err = client.mapData(dv, dt, []byte(`{"_type":"DocumentModel","float_field":"notfloat"}`), nil, dest)
+	assert.T(t, IsWarning(err))
+	assert.T(t, !errors.Is(err, ModelDoesNotMatch))
+	var jserr *json.UnmarshalTypeError
+	assert.T(t, errors.As(err, &jserr))
+
+	// Invalid JSON is not a warning
+	err = client.mapData(dv, dt, []byte(`{"_type":`), nil, dest)
+	assert.T(t, err != nil)
+	assert.T(t, !IsWarning(err))
+}
+
+func TestNetworkError(t *testing.T) {
+	// A server that closes the connection after reading the request
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.T(t, err == nil)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			io.ReadFull(conn, make([]byte, 5))
+			conn.Close()
+		}
+	}()
+
+	client := NewClient(ln.Addr().String())
+	err = client.Ping()
+	var nerr *NetworkError
+	assert.T(t, errors.As(err, &nerr))
+	assert.T(t, nerr.Node == ln.Addr().String())
+	assert.T(t, nerr.Op == "Ping")
+	assert.T(t, errors.Is(err, io.EOF))
+
+	// Connection errors are wrapped as well
+	ln.Close()
+	client = NewClient(ln.Addr().String())
+	err = client.Ping()
+	assert.T(t, errors.As(err, &nerr))
+	assert.T(t, nerr.Op == "Connect")
+}
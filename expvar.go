@@ -0,0 +1,184 @@
+package riak
+
+import (
+	"expvar"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ExpvarInstrumentation publishes the metrics of a Client with the expvar
+package, so they are served as JSON on /debug/vars:
+
+	client.SetInstrumentation(riak.NewExpvarInstrumentation("riak"))
+
+The published map has these variables:
+
+	requests        number of requests by operation
+	errors          number of failed requests by operation
+	latency         histogram of the request duration by operation
+	bytes_sent      total bytes sent
+	bytes_received  total bytes received
+	dials           number of connections dialed
+	dial_errors     number of connections that failed to dial
+	closes          number of connections closed
+	pool_wait       histogram of the time spent waiting for a connection
+	pool_timeouts   number of requests that gave up waiting for a connection
+
+A histogram has the count, the sum in seconds and the cumulative count of
+durations up to each bucket, like Prometheus histograms.
+*/
+type ExpvarInstrumentation struct {
+	vars          *expvar.Map
+	requests      *expvar.Map
+	errors        *expvar.Map
+	latency       *expvar.Map
+	bytesSent     *expvar.Int
+	bytesReceived *expvar.Int
+	dials         *expvar.Int
+	dialErrors    *expvar.Int
+	closes        *expvar.Int
+	poolWait      *Histogram
+	poolTimeouts  *expvar.Int
+	mutex         sync.Mutex
+}
+
+// Create an ExpvarInstrumentation and publish its variables as a map with the
+// given name, it panics if the name is already in use (like expvar.NewMap).
+func NewExpvarInstrumentation(name string) *ExpvarInstrumentation {
+	e := &ExpvarInstrumentation{
+		vars:          expvar.NewMap(name),
+		requests:      new(expvar.Map).Init(),
+		errors:        new(expvar.Map).Init(),
+		latency:       new(expvar.Map).Init(),
+		bytesSent:     new(expvar.Int),
+		bytesReceived: new(expvar.Int),
+		dials:         new(expvar.Int),
+		dialErrors:    new(expvar.Int),
+		closes:        new(expvar.Int),
+		poolWait:      NewHistogram(DefaultBuckets),
+		poolTimeouts:  new(expvar.Int),
+	}
+	e.vars.Set("requests", e.requests)
+	e.vars.Set("errors", e.errors)
+	e.vars.Set("latency", e.latency)
+	e.vars.Set("bytes_sent", e.bytesSent)
+	e.vars.Set("bytes_received", e.bytesReceived)
+	e.vars.Set("dials", e.dials)
+	e.vars.Set("dial_errors", e.dialErrors)
+	e.vars.Set("closes", e.closes)
+	e.vars.Set("pool_wait", e.poolWait)
+	e.vars.Set("pool_timeouts", e.poolTimeouts)
+	return e
+}
+
+// Return the map with all variables
+func (e *ExpvarInstrumentation) Vars() *expvar.Map {
+	return e.vars
+}
+
+func (e *ExpvarInstrumentation) RequestStart(req *RequestInfo) {
+}
+
+func (e *ExpvarInstrumentation) RequestEnd(req *RequestInfo) {
+	e.requests.Add(req.Operation, 1)
+	if req.Err != nil {
+		e.errors.Add(req.Operation, 1)
+	}
+	e.bytesSent.Add(int64(req.BytesSent))
+	e.bytesReceived.Add(int64(req.BytesReceived))
+	e.histogram(req.Operation).Observe(req.Duration)
+}
+
+func (e *ExpvarInstrumentation) Dial(node string, duration time.Duration, err error) {
+	if err != nil {
+		e.dialErrors.Add(1)
+	} else {
+		e.dials.Add(1)
+	}
+}
+
+func (e *ExpvarInstrumentation) Close(node string) {
+	e.closes.Add(1)
+}
+
+func (e *ExpvarInstrumentation) PoolWait(node string, duration time.Duration, err error) {
+	if err == ChanWaitTimeout {
+		e.poolTimeouts.Add(1)
+	}
+	e.poolWait.Observe(duration)
+}
+
+// Return the latency histogram of an operation
+func (e *ExpvarInstrumentation) histogram(operation string) *Histogram {
+	if h, ok := e.latency.Get(operation).(*Histogram); ok {
+		return h
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if h, ok := e.latency.Get(operation).(*Histogram); ok {
+		return h
+	}
+	h := NewHistogram(DefaultBuckets)
+	e.latency.Set(operation, h)
+	return h
+}
+
+// The default upper bounds of the histogram buckets
+var DefaultBuckets = []time.Duration{
+	time.Millisecond, 2 * time.Millisecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 20 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 200 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2 * time.Second, 5 * time.Second,
+}
+
+// A Histogram counts durations in buckets, it is an expvar.Var
+type Histogram struct {
+	mutex   sync.Mutex
+	bounds  []time.Duration
+	buckets []int64 // The last bucket counts the durations above all bounds
+	count   int64
+	sum     time.Duration
+}
+
+// Create a histogram with buckets up to the given (increasing) bounds
+func NewHistogram(bounds []time.Duration) *Histogram {
+	return &Histogram{bounds: bounds, buckets: make([]int64, len(bounds)+1)}
+}
+
+// Add a duration to the histogram
+func (h *Histogram) Observe(d time.Duration) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	i := 0
+	for i < len(h.bounds) && d > h.bounds[i] {
+		i++
+	}
+	h.buckets[i]++
+	h.count++
+	h.sum += d
+}
+
+// Return the number of durations added to the histogram
+func (h *Histogram) Count() int64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.count
+}
+
+// Return the histogram as JSON
+func (h *Histogram) String() string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	var b strings.Builder
+	fmt.Fprintf(&b, `{"count": %d, "sum": %g, "buckets": {`, h.count, h.sum.Seconds())
+	var cumulative int64
+	for i, bound := range h.bounds {
+		cumulative += h.buckets[i]
+		fmt.Fprintf(&b, `"%g": %d, `, bound.Seconds(), cumulative)
+	}
+	fmt.Fprintf(&b, `"+Inf": %d}}`, h.count)
+	return b.String()
+}
@@ -0,0 +1,172 @@
+package riak
+
+import (
+	"context"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/tpjg/goriakpbc/pb"
+)
+
+/*
+The options of an object fold, the zero value folds over all objects from the
+start key up to (but not including) the end key, like Riak does.
+*/
+type FoldOptions struct {
+	ExcludeStart bool          // Do not return the object with the start key
+	IncludeEnd   bool          // Return the object with the end key as well
+	MaxResults   uint32        // The maximum number of objects, 0 for no limit
+	Continuation string        // Resume an earlier fold, see FoldIterator.Continuation
+	Timeout      time.Duration // The timeout on the Riak side
+}
+
+/*
+Fold over the objects with a key between startKey and endKey, an empty endKey
+folds up to the last key of the bucket. Riak returns the keys and the objects
+with a single streamed request:
+
+	it := bucket.FoldObjects("a", "n", riak.FoldOptions{MaxResults: 1000})
+	for it.Next() {
+		obj := it.Object()
+		...
+	}
+	if err := it.Err(); err != nil {
+		...
+	}
+
+With MaxResults the fold stops after that number of objects and Continuation
+resumes it. Siblings are resolved with the ConflictResolver of the bucket, if
+any, but the resolved values are not stored. Object folds are only supported
+by the leveldb backend.
+*/
+func (b *Bucket) FoldObjects(startKey string, endKey string, opts FoldOptions) *FoldIterator {
+	return b.FoldObjectsContext(context.Background(), startKey, endKey, opts)
+}
+
+// Fold over the objects of a key range, the request is aborted when the context is done
+func (b *Bucket) FoldObjectsContext(ctx context.Context, startKey string, endKey string, opts FoldOptions) *FoldIterator {
+	req := &pb.RpbCSBucketReq{Bucket: []byte(b.name), Type: []byte(b.bucket_type),
+		StartKey: []byte(startKey), StartIncl: proto.Bool(!opts.ExcludeStart), EndIncl: proto.Bool(opts.IncludeEnd)}
+	if endKey != "" {
+		req.EndKey = []byte(endKey)
+	}
+	if opts.MaxResults > 0 {
+		req.MaxResults = proto.Uint32(opts.MaxResults)
+	}
+	if opts.Continuation != "" {
+		req.Continuation = []byte(opts.Continuation)
+	}
+	if opts.Timeout > 0 {
+		req.Timeout = proto.Uint32(uint32(opts.Timeout / time.Millisecond))
+	}
+	return &FoldIterator{bucket: b, ctx: ctx, req: req}
+}
+
+// Iterates over the objects of a fold, see FoldObjects
+type FoldIterator struct {
+	bucket       *Bucket
+	ctx          context.Context
+	req          *pb.RpbCSBucketReq
+	conn         *nodeConn // Set while the response is read
+	objects      []*pb.RpbIndexObject
+	current      *RObject
+	continuation string
+	started      bool
+	done         bool
+	err          error
+}
+
+// Advance to the next object, returns false when there are no more objects
+// or when an error occurred
+func (it *FoldIterator) Next() bool {
+	it.current = nil
+	for !it.done && it.err == nil {
+		if len(it.objects) > 0 {
+			o := it.objects[0]
+			it.objects = it.objects[1:]
+			if it.add(o) {
+				return true
+			}
+			continue
+		}
+		resp := &pb.RpbCSBucketResp{}
+		client := it.bucket.client
+		if !it.started {
+			it.started = true
+			conn, err := client.openStream(it.ctx, it.req, rpbCSBucketReq, resp)
+			if it.err = err; err == nil {
+				it.conn = conn
+			}
+		} else if it.conn != nil {
+			it.err = client.streamed(it.conn, resp)
+			if it.err != nil {
+				it.conn = nil
+			}
+		} else {
+			it.done = true
+		}
+		if it.err == nil && !it.done {
+			it.receive(resp)
+		}
+	}
+	return false
+}
+
+// Handle a response message, the connection is released after the last one
+func (it *FoldIterator) receive(resp *pb.RpbCSBucketResp) {
+	it.objects = resp.Objects
+	if len(resp.Continuation) > 0 {
+		it.continuation = string(resp.Continuation)
+	}
+	if resp.GetDone() {
+		it.bucket.client.finish(it.conn, nil)
+		it.conn = nil
+	}
+}
+
+// Set the current object, returns false for a deleted object
+func (it *FoldIterator) add(o *pb.RpbIndexObject) bool {
+	if o.Object == nil {
+		return false
+	}
+	obj, err := it.bucket.getResult(string(o.Key), nil, o.Object)
+	if err == NotFound {
+		return false
+	}
+	if err == nil {
+		err = obj.resolveSiblings(it.ctx, false)
+	}
+	if err != nil {
+		it.err = err
+		it.Close()
+		return false
+	}
+	it.current = obj
+	return true
+}
+
+// Return the current object
+func (it *FoldIterator) Object() *RObject {
+	return it.current
+}
+
+// Return the error that stopped the iteration, if any
+func (it *FoldIterator) Err() error {
+	return it.err
+}
+
+// Return the continuation to resume the fold with when it was limited by
+// MaxResults, it is empty when all objects of the range were returned
+func (it *FoldIterator) Continuation() string {
+	return it.continuation
+}
+
+// Stop the iteration, the rest of the response is not read. Must be called
+// when the iteration is stopped before Next returned false.
+func (it *FoldIterator) Close() {
+	if it.conn != nil {
+		it.bucket.client.abandon(it.conn, nil)
+		it.conn = nil
+	}
+	it.done = true
+}
@@ -0,0 +1,108 @@
+package riak
+
+import (
+	"fmt"
+	"github.com/bmizerany/assert"
+	"github.com/golang/protobuf/proto"
+	"testing"
+	"time"
+
+	"github.com/tpjg/goriakpbc/pb"
+	"github.com/tpjg/goriakpbc/riaktest"
+)
+
+// Return the keys of the objects of the fold
+func fold(t *testing.T, it *FoldIterator) (keys []string) {
+	for it.Next() {
+		keys = append(keys, it.Object().Key)
+	}
+	assert.T(t, it.Err() == nil)
+	return keys
+}
+
+func TestFoldObjects(t *testing.T) {
+	server, client, done := setupServer(t, 1)
+	defer done()
+	bucket, err := client.NewBucket("fold_test.go")
+	assert.T(t, err == nil)
+	for i := 0; i < 250; i++ {
+		obj := bucket.NewObject(fmt.Sprintf("key%03d", i))
+		obj.ContentType = "text/plain"
+		obj.Data = []byte(fmt.Sprint(i))
+		assert.T(t, obj.Store() == nil)
+	}
+	var requests []*pb.RpbCSBucketReq
+	server.SetFault(func(code byte, req proto.Message) error {
+		if code == riaktest.CSBucketReq {
+			requests = append(requests, req.(*pb.RpbCSBucketReq))
+		}
+		return nil
+	})
+
+	// The start key is included and the end key is not, unless asked for
+	keys := fold(t, bucket.FoldObjects("key010", "key020", FoldOptions{}))
+	assert.T(t, len(keys) == 10 && keys[0] == "key010" && keys[9] == "key019")
+	keys = fold(t, bucket.FoldObjects("key010", "key020", FoldOptions{ExcludeStart: true, IncludeEnd: true, Timeout: time.Second}))
+	assert.T(t, len(keys) == 10 && keys[0] == "key011" && keys[9] == "key020")
+	assert.T(t, requests[1].GetTimeout() == 1000 && requests[1].MaxResults == nil)
+
+	// Without an end key the fold continues to the last key, over several
+	// streamed messages
+	it := bucket.FoldObjects("key100", "", FoldOptions{})
+	keys = fold(t, it)
+	assert.T(t, len(keys) == 150 && keys[149] == "key249")
+	assert.T(t, it.Continuation() == "")
+
+	// The continuation resumes a limited fold
+	it = bucket.FoldObjects("", "", FoldOptions{MaxResults: 120})
+	keys = fold(t, it)
+	assert.T(t, len(keys) == 120 && it.Continuation() != "")
+	it = bucket.FoldObjects("", "", FoldOptions{MaxResults: 120, Continuation: it.Continuation()})
+	keys = fold(t, it)
+	assert.T(t, len(keys) == 120 && keys[0] == "key120")
+	obj := it.Object()
+	assert.T(t, obj == nil)
+	server.SetFault(nil)
+
+	// Closing the fold halfway does not affect the next request
+	it = bucket.FoldObjects("", "", FoldOptions{})
+	assert.T(t, it.Next())
+	obj = it.Object()
+	assert.T(t, obj.Key == "key000" && string(obj.Data) == "0" && obj.ContentType == "text/plain")
+	it.Close()
+	assert.T(t, !it.Next())
+	obj, err = bucket.Get("key001")
+	assert.T(t, err == nil && string(obj.Data) == "1")
+
+	// Siblings are resolved, deleted objects are skipped
+	assert.T(t, bucket.SetAllowMult(true) == nil)
+	for _, data := range []string{"a", "b"} {
+		obj = bucket.NewObject("key300")
+		obj.ContentType = "text/plain"
+		obj.Data = []byte(data)
+		assert.T(t, obj.Store() == nil)
+	}
+	assert.T(t, bucket.Delete("key249") == nil)
+	it = bucket.FoldObjects("key249", "", FoldOptions{})
+	assert.T(t, it.Next())
+	assert.T(t, it.Object().Key == "key300" && it.Object().Conflict())
+	assert.T(t, !it.Next())
+	bucket.SetConflictResolver(ResolverFunc(func(siblings []Sibling) (Sibling, error) {
+		return siblings[len(siblings)-1], nil
+	}))
+	it = bucket.FoldObjects("key300", "key300", FoldOptions{IncludeEnd: true})
+	assert.T(t, it.Next())
+	assert.T(t, !it.Object().Conflict() && string(it.Object().Data) == "b")
+	assert.T(t, !it.Next() && it.Err() == nil)
+
+	// The request is retried until the first message arrived
+	client.SetRetryPolicy(&RetryPolicy{MaxAttempts: 3})
+	var count int32
+	server.SetFault(failFirst(riaktest.CSBucketReq, 1, riaktest.CloseConnection, &count))
+	keys = fold(t, bucket.FoldObjects("", "key005", FoldOptions{}))
+	assert.T(t, len(keys) == 5 && count == 2)
+	server.SetFault(nil)
+
+	it = bucket.FoldObjects("", "", FoldOptions{Continuation: "%"})
+	assert.T(t, !it.Next() && it.Err() != nil)
+}
@@ -0,0 +1,374 @@
+package riak
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/tpjg/goriakpbc/pb"
+)
+
+/*
+A query on a secondary index, created with Bucket.Index. Set the term or the
+range to query and iterate over the keys:
+
+	it := bucket.Index("email_bin").Range("a", "n").PageSize(1000).Iterate(ctx)
+	for it.Next() {
+		fmt.Println(it.Key())
+	}
+	if err := it.Err(); err != nil {
+		...
+	}
+
+The iterator fetches the keys a page at a time and follows the continuations.
+*/
+type IndexQuery struct {
+	bucket       *Bucket
+	index        string
+	qtype        pb.RpbIndexReq_IndexQueryType
+	intRange     bool
+	key          string
+	min          string
+	max          string
+	set          bool
+	pageSize     uint32
+	maxResults   int
+	stream       bool
+	returnTerms  bool
+	termRegex    string
+	timeout      *uint32
+	continuation string
+}
+
+// A key with the index term it matched
+type IndexTerm struct {
+	Term string
+	Key  string
+}
+
+// Return the term of an "_int" index as an integer
+func (t IndexTerm) Int() (int64, error) {
+	return strconv.ParseInt(t.Term, 10, 64)
+}
+
+// Error definitions
+var (
+	NoIndexTerm = errors.New("Index query without a term or range")
+	NotIntIndex = errors.New("Not an integer index, the index name must end with _int")
+)
+
+// Return a query on a secondary index of the bucket
+func (b *Bucket) Index(index string) *IndexQuery {
+	return &IndexQuery{bucket: b, index: index}
+}
+
+/*
+Return a query on the keys of the bucket between min and max (inclusive),
+using the special $key index. The keys are returned in order and can be paged
+through, unlike with ListKeys.
+*/
+func (b *Bucket) KeyRange(min string, max string) *IndexQuery {
+	return b.Index("$key").Range(min, max)
+}
+
+// Return a query on all keys of the bucket, using the special $bucket index
+func (b *Bucket) AllKeysVia2i() *IndexQuery {
+	return b.Index("$bucket").Eq(b.name)
+}
+
+// Query the keys that have the term in the index
+func (q *IndexQuery) Eq(term string) *IndexQuery {
+	q.qtype, q.key, q.set, q.intRange = pb.RpbIndexReq_eq, term, true, false
+	return q
+}
+
+// Query the keys that have a term between min and max (inclusive) in the index
+func (q *IndexQuery) Range(min string, max string) *IndexQuery {
+	q.qtype, q.min, q.max, q.set, q.intRange = pb.RpbIndexReq_range, min, max, true, false
+	return q
+}
+
+// Query the keys that have a term between min and max (inclusive) in an
+// "_int" index
+func (q *IndexQuery) IntRange(min int64, max int64) *IndexQuery {
+	q.Range(strconv.FormatInt(min, 10), strconv.FormatInt(max, 10))
+	q.intRange = true
+	return q
+}
+
+// Fetch the keys in pages of the given size, 0 (the default) fetches all
+// keys with a single request
+func (q *IndexQuery) PageSize(size uint32) *IndexQuery {
+	q.pageSize = size
+	return q
+}
+
+// Return at most this number of keys in total, 0 (the default) for no limit
+func (q *IndexQuery) MaxResults(max int) *IndexQuery {
+	q.maxResults = max
+	return q
+}
+
+// Let Riak stream the keys of a page, so large pages are not kept in memory
+// by Riak and the keys can be used before the whole page arrived
+func (q *IndexQuery) Stream(stream bool) *IndexQuery {
+	q.stream = stream
+	return q
+}
+
+// Return the matching term with every key of a range query, see
+// IndexIterator.Term. A key is returned for every matching term it has.
+func (q *IndexQuery) ReturnTerms(returnTerms bool) *IndexQuery {
+	q.returnTerms = returnTerms
+	return q
+}
+
+// Only return the keys of the terms of a range query that match the regular
+// expression, it is only supported for "_bin" indexes
+func (q *IndexQuery) TermRegex(regex string) *IndexQuery {
+	q.termRegex = regex
+	return q
+}
+
+// Set the timeout of every request of the query on the Riak side, it is
+// limited the same way as the Timeout option
+func (q *IndexQuery) Timeout(timeout time.Duration) *IndexQuery {
+	q.timeout = proto.Uint32(timeoutMillis(timeout))
+	return q
+}
+
+// Resume the query from a continuation returned by IndexIterator.Continuation
+func (q *IndexQuery) Continuation(continuation string) *IndexQuery {
+	q.continuation = continuation
+	return q
+}
+
+// Return an iterator over the keys, the requests are aborted when the
+// context is done. Changing the query afterwards does not change the iterator.
+func (q *IndexQuery) Iterate(ctx context.Context) *IndexIterator {
+	it := &IndexIterator{query: *q, ctx: ctx, next: q.continuation}
+	if !q.set {
+		it.err = NoIndexTerm
+	} else if q.intRange && !isIntIndex(q.index) {
+		it.err = NotIntIndex
+	}
+	return it
+}
+
+func isIntIndex(index string) bool {
+	return strings.HasSuffix(index, "_int")
+}
+
+// Iterates over the keys of an index query, see IndexQuery
+type IndexIterator struct {
+	query    IndexQuery
+	ctx      context.Context
+	conn     *nodeConn // Set while a streamed page is read
+	keys     [][]byte  // The keys of the current page that were received
+	terms    [][]byte  // The terms of the keys, if the terms are returned
+	pos      int       // The number of keys of the current page that were returned
+	returned int       // The number of keys returned in total
+	current  string    // The continuation the current page was fetched with
+	next     string    // The continuation of the next page
+	fetched  bool      // Set when the first page was requested
+	complete bool      // Set when the whole current page was received
+	done     bool
+	err      error
+}
+
+/*
+Advance to the next key, returns false when there are no more keys or when
+an error occurred. The next page is fetched when all keys of the current page
+were returned.
+*/
+func (it *IndexIterator) Next() bool {
+	for !it.done && it.err == nil {
+		limited := it.query.maxResults > 0 && it.returned >= it.query.maxResults
+		if it.pos < len(it.keys) && !limited {
+			it.pos++
+			it.returned++
+			return true
+		}
+		if it.conn != nil {
+			// Read the rest of the page, the continuation comes last
+			it.receive()
+		} else if limited || it.fetched && it.next == "" {
+			it.done = true
+		} else {
+			it.fetch()
+		}
+	}
+	return false
+}
+
+// Return the current key
+func (it *IndexIterator) Key() string {
+	if it.pos == 0 {
+		return ""
+	}
+	return string(it.keys[it.pos-1])
+}
+
+// Return the index term of the current key, for a range query it is only
+// known when the query returns the terms
+func (it *IndexIterator) Term() string {
+	if it.query.qtype == pb.RpbIndexReq_eq {
+		return it.query.key
+	}
+	if it.pos == 0 || it.terms == nil {
+		return ""
+	}
+	return string(it.terms[it.pos-1])
+}
+
+// Return the term of the current key of an "_int" index as an integer
+func (it *IndexIterator) IntTerm() (int64, error) {
+	return strconv.ParseInt(it.Term(), 10, 64)
+}
+
+// Return the error that stopped the iteration, if any
+func (it *IndexIterator) Err() error {
+	return it.err
+}
+
+/*
+Return a continuation to resume the query with later, it is empty when all
+keys were returned. Riak continues after a whole page, so when the iteration
+stopped halfway a page the keys of that page are returned again; for the
+first page (or a query without pages) the continuation is empty as well and
+the query starts over.
+*/
+func (it *IndexIterator) Continuation() string {
+	if it.complete && it.pos == len(it.keys) {
+		return it.next
+	}
+	return it.current
+}
+
+// Stop the iteration, a streamed page that is being read is aborted. Must be
+// called when the iteration is stopped before Next returned false.
+func (it *IndexIterator) Close() {
+	if it.conn != nil {
+		it.query.bucket.client.abandon(it.conn, nil)
+		it.conn = nil
+	}
+	it.done = true
+}
+
+// Build the request for the next page
+func (it *IndexIterator) request() *pb.RpbIndexReq {
+	q := &it.query
+	req := &pb.RpbIndexReq{Bucket: []byte(q.bucket.name), Type: []byte(q.bucket.bucket_type),
+		Index: []byte(q.index), Qtype: q.qtype.Enum(), Timeout: q.timeout}
+	if q.qtype == pb.RpbIndexReq_eq {
+		req.Key = []byte(q.key)
+	} else {
+		req.RangeMin, req.RangeMax = []byte(q.min), []byte(q.max)
+	}
+	max := q.pageSize
+	if q.maxResults > 0 && (max == 0 || uint32(q.maxResults-it.returned) < max) {
+		max = uint32(q.maxResults - it.returned)
+	}
+	if max > 0 {
+		req.MaxResults = &max
+	}
+	if it.next != "" {
+		req.Continuation = []byte(it.next)
+	}
+	if q.stream {
+		req.Stream = proto.Bool(true)
+	}
+	if q.returnTerms {
+		req.ReturnTerms = proto.Bool(true)
+	}
+	if q.termRegex != "" {
+		req.TermRegex = []byte(q.termRegex)
+	}
+	return req
+}
+
+// Fetch the next page, for a streamed page only the first message is read
+func (it *IndexIterator) fetch() {
+	client := it.query.bucket.client
+	req := it.request()
+	it.current, it.next, it.fetched, it.complete = it.next, "", true, false
+	it.keys, it.terms, it.pos = nil, nil, 0
+	resp := &pb.RpbIndexResp{}
+	if it.query.stream {
+		conn, err := client.openStream(it.ctx, req, rpbIndexReq, resp)
+		if err == nil {
+			it.conn = conn
+		}
+		it.err = err
+	} else {
+		it.err = client.do(it.ctx, true, req, rpbIndexReq, resp)
+	}
+	if it.err == nil {
+		it.add(resp)
+	}
+}
+
+// Read the next message of a streamed page
+func (it *IndexIterator) receive() {
+	// The keys that were returned are no longer needed
+	it.keys, it.terms, it.pos = nil, nil, 0
+	resp := &pb.RpbIndexResp{}
+	if it.err = it.query.bucket.client.streamed(it.conn, resp); it.err != nil {
+		it.conn = nil
+		return
+	}
+	it.add(resp)
+}
+
+// Add the keys of a response, the connection of a streamed page is released
+// when the page is done
+func (it *IndexIterator) add(resp *pb.RpbIndexResp) {
+	it.keys = append(it.keys, resp.Keys...)
+	// With the terms the keys are the values of the results
+	for _, r := range resp.Results {
+		it.terms = append(it.terms, r.Key)
+		it.keys = append(it.keys, r.Value)
+	}
+	if len(resp.Continuation) > 0 {
+		it.next = string(resp.Continuation)
+	}
+	if it.conn != nil && resp.GetDone() {
+		it.query.bucket.client.finish(it.conn, nil)
+		it.conn = nil
+	}
+	it.complete = it.conn == nil
+}
+
+/*
+Run an index query built with Index on this bucket and fetch the objects of
+the matching keys concurrently, like GetMany. The results are in the order of
+the index, with an error per key (e.g. NotFound when an object was deleted
+after the index was read). Siblings are resolved with the ConflictResolver of
+the bucket, if any. All pages of the query are read, PageSize only sets the
+number of keys per request. Use MaxResults on the query to limit the number
+of objects, the continuation resumes the query after the last key.
+*/
+func (b *Bucket) IndexQueryObjects(query *IndexQuery, options ...map[string]uint32) (results []BatchResult, continuation string, err error) {
+	return b.IndexQueryObjectsContext(context.Background(), query, options...)
+}
+
+// Run an index query and fetch the objects of the matching keys, the requests are aborted when the context is done
+func (b *Bucket) IndexQueryObjectsContext(ctx context.Context, query *IndexQuery, options ...map[string]uint32) (results []BatchResult, continuation string, err error) {
+	if _, err = parseOptions("IndexQueryObjects", getOptionKeys, options); err != nil {
+		return nil, "", err
+	}
+	q := *query
+	q.bucket = b
+	it := q.Iterate(ctx)
+	var keys []string
+	for it.Next() {
+		keys = append(keys, it.Key())
+	}
+	if err = it.Err(); err != nil {
+		return nil, "", err
+	}
+	return b.GetManyContext(ctx, keys, options...), it.Continuation(), nil
+}
@@ -0,0 +1,247 @@
+package riak
+
+import (
+	"context"
+	"fmt"
+	"github.com/bmizerany/assert"
+	"github.com/golang/protobuf/proto"
+	"testing"
+	"time"
+
+	"github.com/tpjg/goriakpbc/pb"
+	"github.com/tpjg/goriakpbc/riaktest"
+)
+
+// Return the keys of the iterator
+func iterate(t *testing.T, it *IndexIterator) (keys []string) {
+	for it.Next() {
+		keys = append(keys, it.Key())
+	}
+	assert.T(t, it.Err() == nil)
+	return keys
+}
+
+func TestIndexIterator(t *testing.T) {
+	server, client, done := setupServer(t, 1)
+	defer done()
+	bucket, err := client.NewBucket("index_test.go")
+	assert.T(t, err == nil)
+	for i := 0; i < 250; i++ {
+		obj := bucket.NewObject(fmt.Sprintf("key%03d", i))
+		obj.ContentType = "text/plain"
+		obj.Indexes["num_int"] = []string{fmt.Sprint(i)}
+		obj.Indexes["even_bin"] = []string{fmt.Sprint(i%2 == 0)}
+		assert.T(t, obj.Store() == nil)
+	}
+	var requests []*pb.RpbIndexReq
+	server.SetFault(func(code byte, req proto.Message) error {
+		if code == riaktest.IndexReq {
+			requests = append(requests, req.(*pb.RpbIndexReq))
+		}
+		return nil
+	})
+	ctx := context.Background()
+
+	// Without paging all keys are fetched at once
+	keys := iterate(t, bucket.Index("num_int").Range("10", "34").Iterate(ctx))
+	assert.T(t, len(keys) == 25 && keys[0] == "key010" && keys[24] == "key034")
+	assert.T(t, len(requests) == 1 && requests[0].MaxResults == nil)
+	keys = iterate(t, bucket.Index("even_bin").Eq("true").Timeout(time.Second).Iterate(ctx))
+	assert.T(t, len(keys) == 125)
+	assert.T(t, requests[1].GetTimeout() == 1000)
+
+	// The continuations are followed
+	requests = nil
+	keys = iterate(t, bucket.Index("num_int").Range("10", "34").PageSize(10).Iterate(ctx))
+	assert.T(t, len(keys) == 25 && keys[24] == "key034")
+	assert.T(t, len(requests) == 3)
+	assert.T(t, requests[0].Continuation == nil && requests[1].Continuation != nil)
+
+	// Streamed pages
+	requests = nil
+	keys = iterate(t, bucket.Index("num_int").Range("0", "249").PageSize(200).Stream(true).Iterate(ctx))
+	assert.T(t, len(keys) == 250 && keys[249] == "key249")
+	assert.T(t, len(requests) == 2 && requests[0].GetStream())
+
+	// The total number of keys is limited, the query can be resumed
+	requests = nil
+	query := bucket.Index("num_int").Range("10", "34").PageSize(10).MaxResults(15)
+	it := query.Iterate(ctx)
+	keys = iterate(t, it)
+	assert.T(t, len(keys) == 15 && keys[14] == "key024")
+	assert.T(t, requests[0].GetMaxResults() == 10 && requests[1].GetMaxResults() == 5)
+	keys = iterate(t, query.MaxResults(0).Continuation(it.Continuation()).Iterate(ctx))
+	assert.T(t, len(keys) == 10 && keys[0] == "key025")
+
+	// Stopping halfway a page resumes at the start of the page
+	query = bucket.Index("num_int").Range("10", "34").PageSize(10).Stream(true)
+	it = query.Iterate(ctx)
+	for i := 0; i < 12; i++ {
+		assert.T(t, it.Next())
+	}
+	it.Close()
+	assert.T(t, !it.Next())
+	keys = iterate(t, query.Continuation(it.Continuation()).Iterate(ctx))
+	assert.T(t, len(keys) == 15 && keys[0] == "key020")
+	server.SetFault(nil)
+
+	// A streamed page that is not read to the end does not affect the next
+	// request on the connection
+	it = bucket.Index("num_int").Range("0", "249").Stream(true).Iterate(ctx)
+	assert.T(t, it.Next())
+	it.Close()
+	assert.T(t, it.Continuation() == "")
+	keys = iterate(t, bucket.Index("num_int").Eq("7").Iterate(ctx))
+	assert.Equal(t, keys, []string{"key007"})
+
+	it = bucket.Index("num_int").Iterate(ctx)
+	assert.T(t, !it.Next())
+	assert.T(t, it.Err() == NoIndexTerm)
+}
+
+func TestIndexTerms(t *testing.T) {
+	client := setupConnection(t)
+	defer client.Close()
+	bucket, err := client.NewBucket("index_test.go")
+	assert.T(t, err == nil)
+	for i, email := range []string{"ann@example.com", "bob@example.com", "anna@example.org"} {
+		obj := bucket.NewObject(fmt.Sprintf("user%d", i))
+		obj.ContentType = "text/plain"
+		obj.Indexes["email_bin"] = []string{email}
+		obj.Indexes["age_int"] = []string{fmt.Sprint(-10 + 20*i), fmt.Sprint(1 << uint(40+i))}
+		assert.T(t, obj.Store() == nil)
+	}
+
+	terms, err := bucket.IndexQueryRangeTerms("email_bin", "a", "b", "")
+	assert.T(t, err == nil)
+	assert.Equal(t, terms, []IndexTerm{{"ann@example.com", "user0"}, {"anna@example.org", "user2"}})
+	terms, err = bucket.IndexQueryRangeTerms("email_bin", "a", "z", "example\\.com$")
+	assert.T(t, err == nil)
+	assert.T(t, len(terms) == 2 && terms[1].Key == "user1")
+
+	// Integer terms, a key is returned for every matching term
+	terms, err = bucket.IndexQueryRangeTerms("age_int", "-100", "5000000000000", "")
+	assert.T(t, err == nil)
+	assert.T(t, len(terms) == 6)
+	n, err := terms[0].Int()
+	assert.T(t, err == nil && n == -10)
+	n, err = terms[5].Int()
+	assert.T(t, err == nil && n == 1<<42)
+
+	it := bucket.Index("email_bin").Range("a", "z").TermRegex("^an").ReturnTerms(true).PageSize(1).Iterate(context.Background())
+	var pairs []IndexTerm
+	for it.Next() {
+		pairs = append(pairs, IndexTerm{it.Term(), it.Key()})
+	}
+	assert.T(t, it.Err() == nil)
+	assert.Equal(t, pairs, []IndexTerm{{"ann@example.com", "user0"}, {"anna@example.org", "user2"}})
+	it = bucket.Index("age_int").Range("0", "100").ReturnTerms(true).Stream(true).Iterate(context.Background())
+	assert.T(t, it.Next())
+	n, err = it.IntTerm()
+	assert.T(t, err == nil && n == 10 && it.Key() == "user1")
+	assert.T(t, it.Next())
+	assert.T(t, it.Key() == "user2")
+	assert.T(t, !it.Next())
+
+	// The term of an equality query is the queried term
+	it = bucket.Index("email_bin").Eq("bob@example.com").Iterate(context.Background())
+	assert.T(t, it.Next())
+	assert.T(t, it.Term() == "bob@example.com" && it.Key() == "user1")
+}
+
+func TestSpecialIndexes(t *testing.T) {
+	server, client, done := setupServer(t, 1)
+	defer done()
+	bucket, err := client.NewBucket("index_test.go")
+	assert.T(t, err == nil)
+	for i := 0; i < 30; i++ {
+		obj := bucket.NewObject(fmt.Sprintf("key%02d", i))
+		obj.ContentType = "text/plain"
+		obj.Indexes["num_int"] = []string{fmt.Sprint(i * 5)}
+		obj.Indexes["num_bin"] = []string{fmt.Sprint(i * 5)}
+		assert.T(t, obj.Store() == nil)
+	}
+	var requests []*pb.RpbIndexReq
+	server.SetFault(func(code byte, req proto.Message) error {
+		if code == riaktest.IndexReq {
+			requests = append(requests, req.(*pb.RpbIndexReq))
+		}
+		return nil
+	})
+	ctx := context.Background()
+
+	keys := iterate(t, bucket.KeyRange("key05", "key14").PageSize(4).Iterate(ctx))
+	assert.T(t, len(keys) == 10 && keys[0] == "key05" && keys[9] == "key14")
+	assert.T(t, string(requests[0].Index) == "$key" && requests[0].GetQtype() == pb.RpbIndexReq_range)
+	requests = nil
+	keys = iterate(t, bucket.AllKeysVia2i().PageSize(20).Iterate(ctx))
+	assert.T(t, len(keys) == 30 && keys[29] == "key29")
+	assert.T(t, string(requests[0].Index) == "$bucket" && string(requests[0].Key) == "index_test.go")
+	assert.T(t, len(requests) == 2)
+	server.SetFault(nil)
+
+	// Integer ranges are compared as numbers, not as strings
+	keys, err = bucket.IndexQueryIntRange("num_int", 5, 20)
+	assert.T(t, err == nil)
+	assert.Equal(t, keys, []string{"key01", "key02", "key03", "key04"})
+	keys, err = bucket.IndexQueryRange("num_bin", "5", "20")
+	assert.T(t, err == nil)
+	assert.T(t, len(keys) == 0)
+	_, err = bucket.IndexQueryIntRange("num_bin", 5, 20)
+	assert.T(t, err == NotIntIndex)
+	keys = iterate(t, bucket.Index("num_int").IntRange(-5, 9).Iterate(ctx))
+	assert.Equal(t, keys, []string{"key00", "key01"})
+	it := bucket.Index("num_bin").IntRange(5, 20).Iterate(ctx)
+	assert.T(t, !it.Next())
+	assert.T(t, it.Err() == NotIntIndex)
+}
+
+func TestIndexQueryObjects(t *testing.T) {
+	server, client, done := setupServer(t, 4)
+	defer done()
+	bucket, err := client.NewBucket("index_test.go")
+	assert.T(t, err == nil)
+	for i := 0; i < 20; i++ {
+		obj := bucket.NewObject(fmt.Sprintf("key%02d", i))
+		obj.ContentType = "text/plain"
+		obj.Data = []byte(fmt.Sprint(i))
+		obj.Indexes["num_int"] = []string{fmt.Sprint(i)}
+		assert.T(t, obj.Store() == nil)
+	}
+	server.SetFault(func(code byte, req proto.Message) error {
+		if code == riaktest.GetReq && string(req.(*pb.RpbGetReq).Key) == "key03" {
+			return riaktest.CloseConnection
+		}
+		return nil
+	})
+
+	// The objects are in the order of the index, a failed fetch only fails its key
+	query := bucket.Index("num_int").Range("0", "19").PageSize(5).MaxResults(8)
+	results, continuation, err := bucket.IndexQueryObjects(query)
+	assert.T(t, err == nil)
+	assert.T(t, len(results) == 8 && continuation != "")
+	for i, r := range results {
+		assert.T(t, r.Index == i && r.Key == fmt.Sprintf("key%02d", i))
+		if i == 3 {
+			assert.T(t, r.Err != nil && r.Object == nil)
+		} else {
+			assert.T(t, r.Err == nil && string(r.Object.Data) == fmt.Sprint(i))
+		}
+	}
+	server.SetFault(nil)
+
+	// The continuation resumes after the last key
+	results, continuation, err = bucket.IndexQueryObjects(query.MaxResults(0).Continuation(continuation))
+	assert.T(t, err == nil)
+	assert.T(t, len(results) == 12 && results[0].Key == "key08" && continuation == "")
+
+	// All pages are read, the page size does not limit the results
+	results, continuation, err = bucket.IndexQueryObjects(bucket.Index("num_int").Range("0", "19").PageSize(5))
+	assert.T(t, err == nil)
+	assert.T(t, len(results) == 20 && results[19].Key == "key19" && continuation == "")
+
+	_, _, err = bucket.IndexQueryObjects(bucket.Index("num_int"))
+	assert.T(t, err == NoIndexTerm)
+	_, _, err = bucket.IndexQueryObjects(query, map[string]uint32{"w": 1})
+	assert.T(t, err != nil)
+}
@@ -0,0 +1,121 @@
+package riak
+
+import (
+	"context"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+/*
+Instrumentation receives callbacks for the requests a Client sends and for
+the connections in its pools, to collect metrics or to trace requests. The
+callbacks are called synchronously, so they should return quickly, and they
+may be called concurrently from multiple goroutines.
+
+	client.SetInstrumentation(riak.NewExpvarInstrumentation("riak"))
+
+Every attempt of a request that is retried is reported as a separate request.
+*/
+type Instrumentation interface {
+	// Called before a request is sent
+	RequestStart(req *RequestInfo)
+	// Called when the response was read or the request failed, the same
+	// RequestInfo is passed to RequestStart and RequestEnd
+	RequestEnd(req *RequestInfo)
+	// Called when a connection to a node was dialed, err is set if that failed
+	Dial(node string, duration time.Duration, err error)
+	// Called when a connection to a node is closed
+	Close(node string)
+	// Called when a request got a connection from the pool of a node, or
+	// gave up waiting with err set
+	PoolWait(node string, duration time.Duration, err error)
+}
+
+// Information about a single request
+type RequestInfo struct {
+	Ctx           context.Context // Context of the request
+	Operation     string          // Name of the request, e.g. "Get" or "Put"
+	Code          byte            // Message code of the request
+	Node          string          // Address of the node
+	BucketType    string
+	Bucket        string
+	BytesSent     int
+	BytesReceived int
+	Start         time.Time
+	Duration      time.Duration // Set when the request ended
+	Err           error         // Set when the request ended
+	Data          interface{}   // For use by the Instrumentation, e.g. to store a trace span
+}
+
+// Set the instrumentation of the client, nil (the default) disables it
+func (c *Client) SetInstrumentation(instrumentation Instrumentation) {
+	c.instrumentation = instrumentation
+}
+
+// The messages of requests on a bucket
+type bucketRequest interface {
+	GetBucket() []byte
+	GetType() []byte
+}
+
+// Start a request on the connection, req is the request message if the
+// request is not written as a pre-built frame.
+func (conn *nodeConn) startRequest(code byte, req proto.Message) {
+	if conn.req == nil {
+		conn.req = conn.node.startRequest(conn.ctx, code, req)
+	}
+}
+
+// End the request on the connection, if any
+func (conn *nodeConn) endRequest(err error) {
+	info := conn.req
+	conn.req = nil
+	conn.node.endRequest(info, err)
+}
+
+// Report the start of a request to the node, returns nil if the client is not
+// instrumented.
+func (n *node) startRequest(ctx context.Context, code byte, req proto.Message) *RequestInfo {
+	instrumentation := n.client.instrumentation
+	if instrumentation == nil {
+		return nil
+	}
+	info := &RequestInfo{
+		Ctx:       ctx,
+		Operation: opName(code),
+		Code:      code,
+		Node:      n.addr,
+		Start:     time.Now(),
+	}
+	if info.Ctx == nil {
+		info.Ctx = context.Background()
+	}
+	if breq, ok := req.(bucketRequest); ok {
+		info.Bucket = string(breq.GetBucket())
+		info.BucketType = string(breq.GetType())
+		if info.BucketType == "" && info.Bucket != "" {
+			info.BucketType = "default"
+		}
+	}
+	instrumentation.RequestStart(info)
+	return info
+}
+
+// Report the end of a request started with startRequest, if any
+func (n *node) endRequest(info *RequestInfo, err error) {
+	if info == nil {
+		return
+	}
+	info.Duration = time.Since(info.Start)
+	info.Err = err
+	if instrumentation := n.client.instrumentation; instrumentation != nil {
+		instrumentation.RequestEnd(info)
+	}
+}
+
+// End the request on the connection and release the connection
+func (c *Client) finish(conn *nodeConn, err error) {
+	conn.endRequest(err)
+	c.releaseConn(conn)
+}
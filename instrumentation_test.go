@@ -0,0 +1,176 @@
+package riak
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/bmizerany/assert"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tpjg/goriakpbc/riaktest"
+)
+
+// Records the callbacks of an Instrumentation
+type recorder struct {
+	mutex     sync.Mutex
+	started   int
+	requests  []*RequestInfo
+	dials     int
+	closes    int
+	poolWaits int
+}
+
+func (r *recorder) RequestStart(req *RequestInfo) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.started++
+}
+
+func (r *recorder) RequestEnd(req *RequestInfo) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.requests = append(r.requests, req)
+}
+
+func (r *recorder) Dial(node string, duration time.Duration, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.dials++
+}
+
+func (r *recorder) Close(node string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.closes++
+}
+
+func (r *recorder) PoolWait(node string, duration time.Duration, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.poolWaits++
+}
+
+func (r *recorder) last() *RequestInfo {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.requests[len(r.requests)-1]
+}
+
+func TestInstrumentation(t *testing.T) {
+	server, client, done := setupServer(t, 1)
+	defer done()
+	client.Close()
+	r := &recorder{}
+	client.SetInstrumentation(r)
+	assert.T(t, client.Connect() == nil)
+	assert.T(t, r.dials == 1)
+
+	bucket, err := client.NewBucket("instrumentation_test.go")
+	assert.T(t, err == nil)
+	obj := bucket.NewObject("key")
+	obj.ContentType = "text/plain"
+	obj.Data = []byte("data")
+	assert.T(t, obj.Store() == nil)
+	req := r.last()
+	assert.T(t, req.Operation == "Put")
+	assert.T(t, req.Code == rpbPutReq)
+	assert.T(t, req.Node == server.Addr())
+	assert.T(t, req.BucketType == "default")
+	assert.T(t, req.Bucket == "instrumentation_test.go")
+	assert.T(t, req.BytesSent > 0 && req.BytesReceived > 0)
+	assert.T(t, req.Err == nil)
+
+	_, err = bucket.Get("key")
+	assert.T(t, err == nil)
+	req = r.last()
+	assert.T(t, req.Operation == "Get")
+	assert.T(t, req.BytesReceived > len("data"))
+
+	assert.T(t, client.Ping() == nil)
+	req = r.last()
+	assert.T(t, req.Operation == "Ping")
+	assert.T(t, req.Bucket == "")
+	assert.T(t, req.BytesSent == 5)
+
+	// Errors are reported
+	server.SetFault(failFirst(riaktest.GetReq, 1, errors.New("overload"), new(int32)))
+	_, err = bucket.Get("key")
+	assert.T(t, errors.Is(r.last().Err, Overload))
+	server.SetFault(failFirst(riaktest.GetReq, 1, riaktest.CloseConnection, new(int32)))
+	_, err = bucket.Get("key")
+	assert.T(t, err != nil)
+	var nerr *NetworkError
+	assert.T(t, errors.As(r.last().Err, &nerr))
+	assert.T(t, r.closes == 1)
+	// The broken connection is redialed
+	server.SetFault(nil)
+	assert.T(t, client.Ping() == nil)
+	assert.T(t, r.dials == 2)
+
+	// Every request is started and ended once, and waited for a connection
+	assert.T(t, r.started == len(r.requests))
+	assert.T(t, r.poolWaits == len(r.requests))
+	client.Close()
+	assert.T(t, r.closes == 2)
+}
+
+func TestExpvarInstrumentation(t *testing.T) {
+	server, client, done := setupServer(t, 1)
+	defer done()
+	client.Close()
+	e := NewExpvarInstrumentation("riak_test_expvar")
+	client.SetInstrumentation(e)
+	assert.T(t, client.Connect() == nil)
+
+	bucket, err := client.NewBucket("instrumentation_test.go")
+	assert.T(t, err == nil)
+	for i := 0; i < 3; i++ {
+		_, err = bucket.Get("missing")
+		assert.T(t, err == NotFound)
+	}
+	server.SetFault(failFirst(riaktest.PingReq, 1, errors.New("overload"), new(int32)))
+	assert.T(t, client.Ping() != nil)
+
+	var vars struct {
+		Requests      map[string]int
+		Errors        map[string]int
+		Latency       map[string]json.RawMessage
+		BytesSent     int `json:"bytes_sent"`
+		BytesReceived int `json:"bytes_received"`
+		Dials         int
+		PoolWait      struct {
+			Count   int
+			Buckets map[string]int
+		} `json:"pool_wait"`
+	}
+	assert.T(t, json.Unmarshal([]byte(e.Vars().String()), &vars) == nil)
+	assert.T(t, vars.Requests["Get"] == 3)
+	assert.T(t, vars.Requests["Ping"] == 1)
+	assert.T(t, vars.Errors["Ping"] == 1)
+	assert.T(t, vars.Errors["Get"] == 0)
+	assert.T(t, vars.BytesSent > 0 && vars.BytesReceived > 0)
+	assert.T(t, vars.Dials == 1)
+	assert.T(t, vars.PoolWait.Count >= 5)
+	assert.T(t, vars.PoolWait.Buckets["+Inf"] == vars.PoolWait.Count)
+	_, ok := vars.Latency["Get"]
+	assert.T(t, ok)
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram([]time.Duration{time.Millisecond, time.Second})
+	h.Observe(time.Microsecond)
+	h.Observe(time.Millisecond)
+	h.Observe(10 * time.Millisecond)
+	h.Observe(time.Minute)
+	assert.T(t, h.Count() == 4)
+	var v struct {
+		Count   int
+		Buckets map[string]int
+	}
+	assert.T(t, json.Unmarshal([]byte(h.String()), &v) == nil)
+	assert.T(t, v.Count == 4)
+	assert.T(t, v.Buckets["0.001"] == 2)
+	assert.T(t, v.Buckets["1"] == 3)
+	assert.T(t, v.Buckets["+Inf"] == 4)
+}
@@ -1,6 +1,7 @@
 package riak
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/tpjg/goriakpbc/pb"
@@ -161,6 +162,11 @@ func (mr *MapReduce) Query() (query []byte, err error) {
 }
 
 func (mr *MapReduce) Run() (resp [][]byte, err error) {
+	return mr.RunContext(context.Background())
+}
+
+// RunContext is the same as Run, but the request is aborted when the context is done
+func (mr *MapReduce) RunContext(ctx context.Context) (resp [][]byte, err error) {
 	query, err := mr.Query()
 	if err != nil {
 		return nil, err
@@ -169,7 +175,7 @@ func (mr *MapReduce) Run() (resp [][]byte, err error) {
 		Request:     query,
 		ContentType: []byte("application/json"),
 	}
-	err, conn := mr.client.request(req, rpbMapRedReq)
+	err, conn := mr.client.requestContext(ctx, req, rpbMapRedReq)
 	if err != nil {
 		return nil, err
 	}
@@ -182,11 +188,16 @@ func (mr *MapReduce) Run() (resp [][]byte, err error) {
 
 // Run a MapReduce query
 func (c *Client) RunMapReduce(query string) (resp [][]byte, err error) {
+	return c.RunMapReduceContext(context.Background(), query)
+}
+
+// Run a MapReduce query, the request is aborted when the context is done
+func (c *Client) RunMapReduceContext(ctx context.Context, query string) (resp [][]byte, err error) {
 	req := &pb.RpbMapRedReq{
 		Request:     []byte(query),
 		ContentType: []byte("application/json"),
 	}
-	err, conn := c.request(req, rpbMapRedReq)
+	err, conn := c.requestContext(ctx, req, rpbMapRedReq)
 	if err != nil {
 		return nil, err
 	}
@@ -1,5 +1,7 @@
 package riak
 
+import "fmt"
+
 const (
 	rpbErrorResp              = 0
 	rpbPingReq                = 1
@@ -32,6 +34,8 @@ const (
 	rbpSearchQueryResp        = 28
 	rpbResetBucketReq         = 29
 	rpbResetBucketResp        = 30
+	rpbGetBucketTypeReq       = 31
+	rpbSetBucketTypeReq       = 32
 	rpbCSBucketReq            = 40
 	rpbCSBucketResp           = 41
 	rpbCounterUpdateReq       = 50
@@ -49,4 +53,46 @@ const (
 	dtFetchResp               = 81
 	dtUpdateReq               = 82
 	dtUpdateResp              = 83
+	rpbAuthReq                = 253
+	rpbAuthResp               = 254
+	rpbStartTls               = 255
 )
+
+// Names of the request messages, used in errors
+var opNames = map[byte]string{
+	rpbPingReq:                "Ping",
+	rpbGetClientIdReq:         "GetClientId",
+	rpbSetClientIdReq:         "SetClientId",
+	rpbGetServerInfoReq:       "GetServerInfo",
+	rpbGetReq:                 "Get",
+	rpbPutReq:                 "Put",
+	rpbDelReq:                 "Del",
+	rpbListBucketsReq:         "ListBuckets",
+	rpbListKeysReq:            "ListKeys",
+	rpbGetBucketReq:           "GetBucket",
+	rpbSetBucketReq:           "SetBucket",
+	rpbMapRedReq:              "MapRed",
+	rpbIndexReq:               "Index",
+	rpbSearchQueryReq:         "SearchQuery",
+	rpbResetBucketReq:         "ResetBucket",
+	rpbGetBucketTypeReq:       "GetBucketType",
+	rpbSetBucketTypeReq:       "SetBucketType",
+	rpbCSBucketReq:            "CSBucket",
+	rpbCounterUpdateReq:       "CounterUpdate",
+	rpbCounterGetReq:          "CounterGet",
+	rpbYokozunaIndexGetReq:    "YokozunaIndexGet",
+	rpbYokozunaIndexPutReq:    "YokozunaIndexPut",
+	rpbYokozunaIndexDeleteReq: "YokozunaIndexDelete",
+	rpbYokozunaSchemaGetReq:   "YokozunaSchemaGet",
+	rpbYokozunaSchemaPutReq:   "YokozunaSchemaPut",
+	dtFetchReq:                "DtFetch",
+	dtUpdateReq:               "DtUpdate",
+}
+
+// Return the name of a request message
+func opName(code byte) string {
+	if name, ok := opNames[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("Request %d", code)
+}
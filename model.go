@@ -1,11 +1,13 @@
 package riak
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"hash/crc32"
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/tpjg/goriakpbc/json"
 )
@@ -52,10 +54,11 @@ type Resolver interface {
 
 // Error definitions
 var (
-	ResolveNotImplemented     = errors.New("Resolve not implemented")
+	ResolveNotImplemented     = classified("Resolve not implemented", SiblingConflict)
 	DestinationError          = errors.New("Destination is not a pointer (to a struct)")
 	DestinationIsNotModel     = errors.New("Destination has no riak.Model field")
 	DestinationIsNotSlice     = errors.New("Must supply a slice to GetSiblings")
+	DestinationIsNotSlicePtr  = errors.New("Destination is not a pointer to a slice (of models)")
 	DestinationLengthError    = errors.New("Length of slice does not match number of siblings")
 	DestinationNotInitialized = errors.New("Destination struct is not initialized (correctly) using riak.New or riak.Load")
 	ModelDoesNotMatch         = errors.New("Warning: struct name does not match _type in Riak")
@@ -64,24 +67,47 @@ var (
 )
 
 /*
-Return is an error is really a warning, e.g. a common json error, or
-ModelDoesNotMatch.
+A ModelWarning is returned when the data in Riak could not be mapped completely
+onto a model, e.g. because a field has a different type or the _type does not
+match the struct name. The fields that could be mapped are set, so it is
+usually safe to continue. Use errors.Is(err, ModelDoesNotMatch) to check for a
+mismatching _type, Err holds the decoding error (if any).
+*/
+type ModelWarning struct {
+	TypeMismatch bool
+	Err          error
+}
+
+func (w *ModelWarning) Error() string {
+	if !w.TypeMismatch {
+		return w.Err.Error()
+	}
+	if w.Err == nil {
+		return ModelDoesNotMatch.Error()
+	}
+	return fmt.Sprintf("%v - %v", ModelDoesNotMatch, w.Err)
+}
+
+func (w *ModelWarning) Unwrap() error {
+	return w.Err
+}
+
+func (w *ModelWarning) Is(target error) bool {
+	return target == ModelDoesNotMatch && w.TypeMismatch
+}
+
+/*
+Return is an error is really a warning, e.g. a ModelWarning for a common json
+error, or ModelDoesNotMatch.
 */
 func IsWarning(err error) bool {
-	if err != nil {
-		if strings.HasPrefix(err.Error(), "<nil> - json: cannot unmarshal") && strings.Contains(err.Error(), "into Go value of type") {
-			return true
-		} else if strings.HasPrefix(err.Error(), "<nil> - parsing") && strings.Contains(err.Error(), ": cannot parse") {
-			return true
-		} else if err == ModelDoesNotMatch {
-			return true
-		}
-	} else {
+	if err == nil {
 		// In case there is no error reply true anyway since this is probably
 		// what is expected - a check whether it is safe to continue.
 		return true
 	}
-	return false
+	var warning *ModelWarning
+	return errors.As(err, &warning) || errors.Is(err, ModelDoesNotMatch)
 }
 
 func (*Model) Resolve(count int) (err error) {
@@ -163,14 +189,21 @@ func (c *Client) mapData(dv reflect.Value, dt reflect.Type, data []byte, links [
 	// Double check there is a "_type" field that is the same as the struct
 	// name, this is only a warning though.
 	var mn modelName
-	err = json.Unmarshal(data, &mn)
-	if err != nil || dt.Name() != mn.Type {
-		err = fmt.Errorf("Warning: struct name does not match _type in Riak (%v)", err)
+	warning := &ModelWarning{}
+	if json.Unmarshal(data, &mn) != nil || dt.Name() != mn.Type {
+		warning.TypeMismatch = true
 	}
-	// Unmarshal the destination model
+	// Unmarshal the destination model, only some errors leave the model usable
 	jserr := json.Unmarshal(data, dest)
-	if jserr != nil {
-		err = fmt.Errorf("%v - %v", err, jserr) // Add error
+	switch jserr.(type) {
+	case nil:
+	case *json.UnmarshalTypeError, *time.ParseError:
+		warning.Err = jserr
+	default:
+		err = jserr
+	}
+	if err == nil && (warning.TypeMismatch || warning.Err != nil) {
+		err = warning
 	}
 	// For all the links in the struct, find the correct mapping
 	for i := 0; i < dt.NumField(); i++ {
@@ -277,6 +310,11 @@ dev := &Device{}
 err := client.Load("devices", "12345", dev)
 */
 func (c *Client) LoadModelFrom(bucketname string, key string, dest Resolver, options ...map[string]uint32) (err error) {
+	return c.LoadModelFromContext(context.Background(), bucketname, key, dest, options...)
+}
+
+// LoadModelFromContext is the same as LoadModelFrom, but the request is aborted when the context is done
+func (c *Client) LoadModelFromContext(ctx context.Context, bucketname string, key string, dest Resolver, options ...map[string]uint32) (err error) {
 	// Check destination
 	dv, dt, rm, bn, err := check_dest(dest)
 	if err != nil {
@@ -287,12 +325,17 @@ func (c *Client) LoadModelFrom(bucketname string, key string, dest Resolver, opt
 		bucketname = bn
 	}
 	// Fetch the object from Riak.
-	bucket, err := c.Bucket(bucketname)
+	bucket, err := c.NewBucketContext(ctx, bucketname)
 	if bucket == nil || err != nil {
 		err = fmt.Errorf("Can't get bucket for %v - %v", dt.Name(), err)
 		return
 	}
-	obj, err := bucket.Get(key, options...)
+	obj, err := bucket.GetContext(ctx, key, options...)
+	return c.loadObject(obj, err, dest, dv, dt, rm)
+}
+
+// Load a fetched object (or the error of the fetch) into the model
+func (c *Client) loadObject(obj *RObject, err error, dest Resolver, dv reflect.Value, dt reflect.Type, rm reflect.Value) error {
 	if err != nil {
 		if obj != nil {
 			// Set the values in the riak.Model field
@@ -322,7 +365,62 @@ func (c *Client) LoadModelFrom(bucketname string, key string, dest Resolver, opt
 	// Set the values in the riak.Model field
 	setup_model(obj, dest, rm)
 
-	return
+	return err
+}
+
+/*
+Run an index query and load the models of the matching keys, the objects are
+fetched concurrently like with Bucket.IndexQueryObjects. The destination must
+be a pointer to a slice of structs (or of pointers to structs) that have the
+riak.Model field, it is replaced by a slice with a model for every key in the
+order of the index. errs has the error of every model, e.g. NotFound, the
+model is left empty then. The continuation resumes the query after the last
+key, see IndexQuery.
+
+	var devices []Device
+	errs, continuation, err := client.IndexQueryModels(bucket.Index("owner_bin").Eq("ann"), &devices)
+*/
+func (c *Client) IndexQueryModels(query *IndexQuery, dest interface{}, options ...map[string]uint32) (errs []error, continuation string, err error) {
+	return c.IndexQueryModelsContext(context.Background(), query, dest, options...)
+}
+
+// IndexQueryModelsContext is the same as IndexQueryModels, but the requests are aborted when the context is done
+func (c *Client) IndexQueryModelsContext(ctx context.Context, query *IndexQuery, dest interface{}, options ...map[string]uint32) (errs []error, continuation string, err error) {
+	sv := reflect.ValueOf(dest)
+	if sv.Kind() != reflect.Ptr || sv.IsNil() || sv.Elem().Kind() != reflect.Slice {
+		return nil, "", DestinationIsNotSlicePtr
+	}
+	st := sv.Elem().Type()
+	et := st.Elem()
+	if et.Kind() == reflect.Ptr {
+		et = et.Elem()
+	}
+	// Check the model before running the query
+	if _, _, _, _, err = check_dest(reflect.New(et).Interface()); err != nil {
+		return nil, "", err
+	}
+	if _, ok := reflect.New(et).Interface().(Resolver); !ok {
+		return nil, "", DestinationIsNotModel
+	}
+	results, continuation, err := query.bucket.IndexQueryObjectsContext(ctx, query, options...)
+	if err != nil {
+		return nil, "", err
+	}
+	models := reflect.MakeSlice(st, len(results), len(results))
+	errs = make([]error, len(results))
+	for i, r := range results {
+		model := models.Index(i)
+		if model.Kind() == reflect.Ptr {
+			model.Set(reflect.New(et))
+		} else {
+			model = model.Addr()
+		}
+		m := model.Interface().(Resolver)
+		dv, dt, rm, _, _ := check_dest(m)
+		errs[i] = c.loadObject(r.Object, r.Err, m, dv, dt, rm)
+	}
+	sv.Elem().Set(models)
+	return errs, continuation, nil
 }
 
 // Load data into model. DEPRECATED, use LoadModelFrom instead.
@@ -332,7 +430,12 @@ func (c *Client) Load(bucketname string, key string, dest Resolver, options ...m
 
 // Load data into the model using the default bucket (from the Model's struct definition)
 func (c *Client) LoadModel(key string, dest Resolver, options ...map[string]uint32) (err error) {
-	return c.LoadModelFrom("", key, dest, options...)
+	return c.LoadModelContext(context.Background(), key, dest, options...)
+}
+
+// Load data into the model using the default bucket (from the Model's struct definition), the request is aborted when the context is done
+func (c *Client) LoadModelContext(ctx context.Context, key string, dest Resolver, options ...map[string]uint32) (err error) {
+	return c.LoadModelFromContext(ctx, "", key, dest, options...)
 }
 
 /*
@@ -411,6 +514,16 @@ func (c *Client) linkToModel(dest interface{}) (link Link, err error) {
 
 // Save a Document Model to Riak under a new key, if empty a Key will be choosen by Riak
 func (c *Client) SaveAs(newKey string, dest Resolver) (err error) {
+	return c.SaveAsContext(context.Background(), newKey, dest)
+}
+
+// Save a Document Model to Riak under a new key, if empty a Key will be choosen by Riak, the request is aborted when the context is done
+func (c *Client) SaveAsContext(ctx context.Context, newKey string, dest Resolver) (err error) {
+	return c.saveAs(ctx, newKey, dest, nil)
+}
+
+// Save a Document Model to Riak, the condition (if any) is used for the Store
+func (c *Client) saveAs(ctx context.Context, newKey string, dest Resolver, condition Option) (err error) {
 	// Check destination
 	dv, dt, rm, _, err := check_dest(dest)
 	if err != nil {
@@ -482,14 +595,43 @@ func (c *Client) SaveAs(newKey string, dest Resolver) (err error) {
 		model.robject.Key = newKey
 	}
 	// Store the RObject in Riak
-	err = model.robject.Store()
+	err = model.robject.store(ctx, condition)
 
 	return
 }
 
 // Save a Document Model to Riak
 func (c *Client) Save(dest Resolver) (err error) {
-	return c.SaveAs("", dest)
+	return c.SaveContext(context.Background(), dest)
+}
+
+// Save a Document Model to Riak, the request is aborted when the context is done
+func (c *Client) SaveContext(ctx context.Context, dest Resolver) (err error) {
+	return c.SaveAsContext(ctx, "", dest)
+}
+
+// Save a Document Model to Riak only if there is no object with the same key
+// yet, returns ObjectExists otherwise
+func (c *Client) SaveIfNotExists(dest Resolver) (err error) {
+	return c.SaveIfNotExistsContext(context.Background(), dest)
+}
+
+// Save a Document Model to Riak only if there is no object with the same key
+// yet, the request is aborted when the context is done
+func (c *Client) SaveIfNotExistsContext(ctx context.Context, dest Resolver) (err error) {
+	return c.saveAs(ctx, "", dest, IfNoneMatch(true))
+}
+
+// Save a Document Model to Riak only if it was not changed in Riak since it was
+// loaded, returns ObjectModified otherwise
+func (c *Client) SaveIfUnmodified(dest Resolver) (err error) {
+	return c.SaveIfUnmodifiedContext(context.Background(), dest)
+}
+
+// Save a Document Model to Riak only if it was not changed in Riak since it was
+// loaded, the request is aborted when the context is done
+func (c *Client) SaveIfUnmodifiedContext(ctx context.Context, dest Resolver) (err error) {
+	return c.saveAs(ctx, "", dest, IfNotModified(true))
 }
 
 // Get the client from a given model
@@ -508,27 +650,79 @@ func (m *Model) getClient() (c *Client, err error) {
 
 // Save a Document Model to Riak under a new key, if empty a Key will be choosen by Riak
 func (m *Model) SaveAs(newKey string) (err error) {
+	return m.SaveAsContext(context.Background(), newKey)
+}
+
+// Save a Document Model to Riak under a new key, if empty a Key will be choosen by Riak, the request is aborted when the context is done
+func (m *Model) SaveAsContext(ctx context.Context, newKey string) (err error) {
 	client, err := m.getClient()
 	if err != nil {
 		return err
 	}
-	return client.SaveAs(newKey, m.parent)
+	return client.SaveAsContext(ctx, newKey, m.parent)
 }
 
 // Save a Document Model to Riak
 func (m *Model) Save() (err error) {
-	return m.SaveAs("")
+	return m.SaveContext(context.Background())
+}
+
+// Save a Document Model to Riak, the request is aborted when the context is done
+func (m *Model) SaveContext(ctx context.Context) (err error) {
+	return m.SaveAsContext(ctx, "")
+}
+
+// Save a Document Model to Riak only if there is no object with the same key
+// yet, returns ObjectExists otherwise
+func (m *Model) SaveIfNotExists() (err error) {
+	return m.SaveIfNotExistsContext(context.Background())
+}
+
+// Save a Document Model to Riak only if there is no object with the same key
+// yet, the request is aborted when the context is done
+func (m *Model) SaveIfNotExistsContext(ctx context.Context) (err error) {
+	client, err := m.getClient()
+	if err != nil {
+		return err
+	}
+	return client.SaveIfNotExistsContext(ctx, m.parent)
+}
+
+// Save a Document Model to Riak only if it was not changed in Riak since it was
+// loaded, returns ObjectModified otherwise
+func (m *Model) SaveIfUnmodified() (err error) {
+	return m.SaveIfUnmodifiedContext(context.Background())
+}
+
+// Save a Document Model to Riak only if it was not changed in Riak since it was
+// loaded, the request is aborted when the context is done
+func (m *Model) SaveIfUnmodifiedContext(ctx context.Context) (err error) {
+	client, err := m.getClient()
+	if err != nil {
+		return err
+	}
+	return client.SaveIfUnmodifiedContext(ctx, m.parent)
 }
 
 // Delete a Document Model
 func (m *Model) Delete() (err error) {
-	return m.robject.Destroy()
+	return m.DeleteContext(context.Background())
+}
+
+// Delete a Document Model, the request is aborted when the context is done
+func (m *Model) DeleteContext(ctx context.Context) (err error) {
+	return m.robject.DestroyContext(ctx)
 }
 
 // Reload a Document Model
 func (m *Model) Reload() (err error) {
+	return m.ReloadContext(context.Background())
+}
+
+// Reload a Document Model, the request is aborted when the context is done
+func (m *Model) ReloadContext(ctx context.Context) (err error) {
 	vclock := string(m.robject.Vclock)
-	err = m.robject.Reload()
+	err = m.robject.ReloadContext(ctx)
 	if err != nil {
 		return err
 	}
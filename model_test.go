@@ -3,6 +3,7 @@ package riak
 import (
 	"errors"
 	"github.com/bmizerany/assert"
+	"io"
 	"strconv"
 	"strings"
 	"testing"
@@ -470,8 +471,36 @@ func TestModelReload(t *testing.T) {
 	assert.T(t, doc.FieldB == doc2.FieldB)
 }
 
+func TestModelConditionalSave(t *testing.T) {
+	client := setupConnection(t)
+	assert.T(t, client != nil)
+
+	bucket, _ := client.Bucket("testmodel.go")
+	bucket.Delete("TestModelConditional")
+	doc := DocumentModel{FieldS: "text", FieldF: 1.2, FieldB: true}
+	err := client.New("testmodel.go", "TestModelConditional", &doc)
+	assert.T(t, err == nil)
+	assert.T(t, doc.SaveIfNotExists() == nil)
+
+	doc2 := DocumentModel{FieldS: "other"}
+	err = client.New("testmodel.go", "TestModelConditional", &doc2)
+	assert.T(t, err == nil)
+	assert.T(t, client.SaveIfNotExists(&doc2) == ObjectExists)
+
+	// Load a copy, save the original and the copy is stale
+	doc3 := DocumentModel{}
+	err = client.Load("testmodel.go", "TestModelConditional", &doc3)
+	assert.T(t, err == nil)
+	doc.FieldS = "changed"
+	assert.T(t, doc.SaveIfUnmodified() == nil)
+	doc3.FieldS = "stale"
+	assert.T(t, doc3.SaveIfUnmodified() == ObjectModified)
+
+	assert.T(t, doc.Delete() == nil)
+}
+
 func TestModelNew(t *testing.T) {
-	err := ConnectClientPool("127.0.0.1:8087", 5)
+	err := ConnectClientPool(riakhost, 5)
 	assert.T(t, err == nil)
 
 	doc := DocumentModel{FieldS: "text", FieldF: 1.2, FieldB: true}
@@ -552,7 +581,7 @@ func TestErrorCatching(t *testing.T) {
 }
 
 func TestBrokenModels(t *testing.T) {
-	err := ConnectClient("127.0.0.1:8087")
+	err := ConnectClient(riakhost)
 	assert.T(t, err == nil)
 
 	// Create some JSON with a _type field that does not match the class name
@@ -595,7 +624,7 @@ func TestBrokenModels(t *testing.T) {
 }
 
 func TestNewModelInErrors(t *testing.T) {
-	err := ConnectClient("127.0.0.1:8087")
+	err := ConnectClient(riakhost)
 	assert.T(t, err == nil)
 
 	// Try NewModal with something that is not a Model
@@ -663,7 +692,7 @@ func TestMultipleIndexesInModel(t *testing.T) {
 	if err == nil {
 		t.Logf("2i query returned : %v\n", keys)
 	} else {
-		if err.Error() == "EOF" {
+		if errors.Is(err, io.EOF) {
 			t.Log("2i queries over protobuf is not supported, maybe running a pre 1.2 version of Riak - skipping 2i tests.")
 			return
 		} else if err.Error() == "{error,{indexes_not_supported,riak_kv_bitcask_backend}}" {
@@ -738,7 +767,7 @@ func TestIndexesInModel(t *testing.T) {
 	if err == nil {
 		t.Logf("2i query returned : %v\n", keys)
 	} else {
-		if err.Error() == "EOF" {
+		if errors.Is(err, io.EOF) {
 			t.Log("2i queries over protobuf is not supported, maybe running a pre 1.2 version of Riak - skipping 2i tests.")
 			return
 		} else if err.Error() == "{error,{indexes_not_supported,riak_kv_bitcask_backend}}" {
@@ -808,7 +837,7 @@ func TestMultiIndexesInModel(t *testing.T) {
 	if err == nil {
 		t.Logf("2i query returned : %v\n", keys)
 	} else {
-		if err.Error() == "EOF" {
+		if errors.Is(err, io.EOF) {
 			t.Log("2i queries over protobuf is not supported, maybe running a pre 1.2 version of Riak - skipping 2i tests.")
 			return
 		} else if err.Error() == "{error,{indexes_not_supported,riak_kv_bitcask_backend}}" {
@@ -839,3 +868,49 @@ func TestMultiIndexesInModel(t *testing.T) {
 	err = doc2.Delete()
 	assert.T(t, err == nil)
 }
+
+func TestIndexQueryModels(t *testing.T) {
+	client := setupConnection(t)
+	assert.T(t, client != nil)
+
+	bucket, _ := client.Bucket("indexquerymodels.go")
+	assert.T(t, bucket != nil)
+	for i := 0; i < 5; i++ {
+		doc := DocumentModel{FieldS: "doc" + strconv.Itoa(i), FieldF: float64(i)}
+		err := client.NewModelIn("indexquerymodels.go", "doc"+strconv.Itoa(i), &doc)
+		assert.T(t, err == nil)
+		doc.Indexes()["num_int"] = []string{strconv.Itoa(i)}
+		assert.T(t, doc.Save() == nil)
+	}
+	// The index entry of a deleted object is gone as well, use an extra
+	// object without data to get an error for one of the keys
+	obj := bucket.NewObject("doc5")
+	obj.ContentType = "application/json"
+	obj.Data = []byte("{")
+	obj.Indexes["num_int"] = []string{"5"}
+	assert.T(t, obj.Store() == nil)
+
+	var docs []DocumentModel
+	errs, continuation, err := client.IndexQueryModels(bucket.Index("num_int").IntRange(1, 5), &docs)
+	assert.T(t, err == nil && continuation == "")
+	assert.T(t, len(docs) == 5 && len(errs) == 5)
+	for i, doc := range docs[:4] {
+		assert.T(t, errs[i] == nil)
+		assert.T(t, doc.FieldS == "doc"+strconv.Itoa(i+1) && doc.Key() == "doc"+strconv.Itoa(i+1))
+	}
+	assert.T(t, errs[4] != nil && !IsWarning(errs[4]))
+
+	// The models can be changed and saved
+	docs[0].FieldB = true
+	assert.T(t, docs[0].Save() == nil)
+	var ptrs []*DocumentModel
+	errs, _, err = client.IndexQueryModels(bucket.Index("num_int").Eq("1"), &ptrs)
+	assert.T(t, err == nil && len(ptrs) == 1 && errs[0] == nil)
+	assert.T(t, ptrs[0].FieldB)
+
+	_, _, err = client.IndexQueryModels(bucket.Index("num_int").Eq("1"), docs)
+	assert.T(t, err == DestinationIsNotSlicePtr)
+	var wrong []SubStruct
+	_, _, err = client.IndexQueryModels(bucket.Index("num_int").Eq("1"), &wrong)
+	assert.T(t, err == DestinationIsNotModel)
+}
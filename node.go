@@ -0,0 +1,377 @@
+package riak
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A node is a single Riak server that is part of the cluster a Client talks
+// to. Each node has its own pool of connections and keeps track of its health
+// so the Client can route requests around nodes that are down.
+type node struct {
+	addr        string
+	tcpaddr     *net.TCPAddr
+	client      *Client
+	conns       chan *nodeConn
+	connMutex   sync.RWMutex
+	outstanding int32 // Number of connections currently taken from the pool
+	open        int32 // Number of open connections
+	down        int32 // Set to 1 when the node is marked as down
+	probing     int32 // Set to 1 while a probe is running
+	healthMutex sync.Mutex
+	downSince   time.Time
+	nextProbe   time.Time
+	stop        chan struct{} // Stops the pool maintenance
+	pipe        *pipeline     // Pipeline for asynchronous requests, if any
+	pipeMutex   sync.Mutex
+}
+
+// A connection to a node, it remembers the node it belongs to so it can be
+// released into the right pool. A connection that was left in an unknown state
+// (e.g. an I/O error or cancellation in the middle of a frame) is marked as
+// broken and is discarded instead of being re-used. The pool has a slot for
+// every connection up to the maximum pool size, a slot without an open
+// connection (Conn is nil) is dialed when it is taken from the pool.
+type nodeConn struct {
+	net.Conn
+	node     *node
+	broken   bool
+	created  time.Time       // When the connection was dialed
+	lastUsed time.Time       // When the connection was last released
+	op       byte            // Message code of the current request
+	req      *RequestInfo    // The current request, if it is instrumented
+	ctx      context.Context // Context of the current request
+	stop     chan struct{}   // Stops watching the context of the current request
+	done     chan struct{}   // Closed when the watcher has stopped
+}
+
+// Status of a single node in the cluster as seen by the Client
+type NodeStatus struct {
+	Addr        string
+	Up          bool
+	DownSince   time.Time
+	Outstanding int
+	Open        int
+}
+
+func newNode(c *Client, addr string, count int) *node {
+	if count < 1 {
+		count = 1
+	}
+	n := &node{addr: addr, client: c, conns: make(chan *nodeConn, count)}
+	n.conns <- nil
+	return n
+}
+
+// Connect to the node, filling the pool with the minimum number of
+// connections (at least one, to check the node can be reached). The other
+// slots of the pool are dialed when they are needed.
+func (n *node) connect(dialer *net.Dialer) (err error) {
+	n.connMutex.RLock()
+	defer n.connMutex.RUnlock()
+	tcpaddr, err := net.ResolveTCPAddr("tcp", n.addr)
+	if err != nil {
+		return err
+	}
+	n.tcpaddr = tcpaddr
+
+	if n.client.conn_count <= 0 || n.client.minConns < 0 || n.client.minConns > n.client.conn_count {
+		return BadNumberOfConnections
+	} else if conn := <-n.conns; conn == nil {
+		// Create multiple connections to Riak and send these to the conns channel for later use
+		count := n.client.minConns
+		if count < 1 {
+			count = 1
+		}
+		for i := 0; i < count; i++ {
+			conn, err := n.dial(dialer, tcpaddr.String())
+			if err != nil {
+				// Empty the conns channel before returning, in case an error appeared after a few
+				// successful connections.
+				for j := 0; j < i; j++ {
+					(<-n.conns).close()
+				}
+				n.conns <- nil
+				return err
+			}
+			n.conns <- n.newConn(conn)
+		}
+		for i := count; i < n.client.conn_count; i++ {
+			n.conns <- &nodeConn{node: n}
+		}
+		n.startMaintenance()
+	} else {
+		n.conns <- conn
+	}
+	return nil
+}
+
+// Close all the connections to the node
+func (n *node) close() {
+	n.connMutex.Lock()
+	defer n.connMutex.Unlock()
+	if conn := <-n.conns; conn == nil {
+		n.conns <- nil
+		return
+	} else {
+		conn.close()
+	}
+
+	// Close all the other connections
+	for i := 0; i < cap(n.conns)-1; i++ {
+		conn := <-n.conns
+		conn.close()
+	}
+	n.conns <- nil
+	if n.stop != nil {
+		close(n.stop)
+		n.stop = nil
+	}
+}
+
+// Gets a connection from the pool of this node, connecting if necessary. The
+// wait for a connection is aborted when the context is done.
+func (n *node) getConn(ctx context.Context) (err error, conn *nodeConn) {
+	var timeout <-chan time.Time
+	if n.client.chanWait > 0 {
+		timeout = time.After(n.client.chanWait)
+	}
+	start := time.Now()
+retry:
+	select {
+	case conn = <-n.conns:
+		break
+	case <-timeout:
+		n.poolWait(start, ChanWaitTimeout)
+		return ChanWaitTimeout, nil
+	case <-ctx.Done():
+		n.poolWait(start, ctx.Err())
+		return ctx.Err(), nil
+	}
+	// Connect if necessary
+	if conn == nil {
+		n.conns <- nil
+		err = n.client.connectNode(n)
+		if err != nil {
+			n.markDown()
+			return err, nil
+		}
+		n.markUp()
+		goto retry
+	}
+	// Replace a connection that was idle or open for too long, or discarded
+	if conn.Conn != nil && n.expired(conn, time.Now()) {
+		conn.close()
+	}
+	if conn.Conn == nil {
+		err = n.redial(conn)
+		if err != nil {
+			n.conns <- conn
+			n.markDown()
+			return err, nil
+		}
+		// The node can be reached again, without waiting for a probe (a
+		// single node is never probed)
+		n.markUp()
+	}
+	n.poolWait(start, nil)
+	atomic.AddInt32(&n.outstanding, 1)
+	conn.watch(ctx)
+	return nil, conn
+}
+
+// Dial a connection to the node, when security is enabled on the Client the
+// connection is upgraded to TLS and authenticated before it is returned.
+func (n *node) dial(dialer *net.Dialer, addr string) (conn net.Conn, err error) {
+	if instrumentation := n.client.instrumentation; instrumentation != nil {
+		start := time.Now()
+		defer func() { instrumentation.Dial(n.addr, time.Since(start), err) }()
+	}
+	conn, err = dialer.Dial("tcp", addr)
+	if err != nil {
+		return nil, &NetworkError{Node: n.addr, Op: "Connect", Err: err}
+	}
+	if n.client.security == nil {
+		return conn, nil
+	}
+	return n.client.security.handshake(n, conn, dialer.Timeout)
+}
+
+// Report the time a request waited for a connection
+func (n *node) poolWait(start time.Time, err error) {
+	if instrumentation := n.client.instrumentation; instrumentation != nil {
+		instrumentation.PoolWait(n.addr, time.Since(start), err)
+	}
+}
+
+// Create a pooled connection for a new network connection
+func (n *node) newConn(c net.Conn) *nodeConn {
+	atomic.AddInt32(&n.open, 1)
+	now := time.Now()
+	return &nodeConn{Conn: c, node: n, created: now, lastUsed: now}
+}
+
+// Dial a new connection for a connection that was discarded
+func (n *node) redial(conn *nodeConn) error {
+	c, err := n.dial(n.client.dialer(), n.addr)
+	if err != nil {
+		return err
+	}
+	*conn = *n.newConn(c)
+	return nil
+}
+
+// Releases the connection for use by subsequent requests, broken connections
+// are closed and will be replaced by a new connection when needed.
+func (n *node) releaseConn(conn *nodeConn) {
+	conn.endRequest(nil)
+	conn.unwatch()
+	conn.lastUsed = time.Now()
+	if conn.broken || n.expired(conn, conn.lastUsed) {
+		conn.close()
+	}
+	atomic.AddInt32(&n.outstanding, -1)
+	n.conns <- conn
+}
+
+// Apply the deadline of the context to the connection and abort any blocking
+// I/O when the context is cancelled.
+func (conn *nodeConn) watch(ctx context.Context) {
+	conn.ctx = ctx
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if ctx.Done() == nil {
+		return
+	}
+	conn.stop = make(chan struct{})
+	conn.done = make(chan struct{})
+	go func(stop, done chan struct{}) {
+		defer close(done)
+		select {
+		case <-ctx.Done():
+			// Unblock reads and writes in progress
+			conn.SetDeadline(time.Unix(1, 0))
+		case <-stop:
+		}
+	}(conn.stop, conn.done)
+}
+
+// Stop watching the context and clear the deadline
+func (conn *nodeConn) unwatch() {
+	if conn.stop != nil {
+		close(conn.stop)
+		<-conn.done
+		conn.stop = nil
+		conn.done = nil
+	}
+	if conn.Conn != nil && !conn.broken {
+		conn.SetDeadline(time.Time{})
+	}
+	conn.ctx = nil
+}
+
+// Returns the error of the request context if an I/O error was caused by the
+// context being cancelled or its deadline being exceeded, otherwise nil.
+func (conn *nodeConn) ctxErr(err error) error {
+	if conn.ctx == nil {
+		return nil
+	}
+	if cerr := conn.ctx.Err(); cerr != nil {
+		return cerr
+	}
+	if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		if deadline, ok := conn.ctx.Deadline(); ok && !time.Now().Before(deadline) {
+			return context.DeadlineExceeded
+		}
+	}
+	return nil
+}
+
+// Wrap an I/O error with the node and the operation that failed
+func (conn *nodeConn) netError(err error) error {
+	return &NetworkError{Node: conn.node.addr, Op: opName(conn.op), Err: err}
+}
+
+// Close the underlying network connection, if any
+func (conn *nodeConn) close() {
+	if conn.Conn != nil {
+		conn.Conn.Close()
+		conn.Conn = nil
+		atomic.AddInt32(&conn.node.open, -1)
+		if instrumentation := conn.node.client.instrumentation; instrumentation != nil {
+			instrumentation.Close(conn.node.addr)
+		}
+	}
+}
+
+func (n *node) isUp() bool {
+	return atomic.LoadInt32(&n.down) == 0
+}
+
+// Mark the node as down, it will not be used until a probe succeeds
+func (n *node) markDown() {
+	n.healthMutex.Lock()
+	defer n.healthMutex.Unlock()
+	if atomic.CompareAndSwapInt32(&n.down, 0, 1) {
+		n.downSince = time.Now()
+		n.nextProbe = n.downSince.Add(n.client.probeInterval)
+	}
+}
+
+func (n *node) markUp() {
+	n.healthMutex.Lock()
+	defer n.healthMutex.Unlock()
+	atomic.StoreInt32(&n.down, 0)
+	n.downSince = time.Time{}
+}
+
+// Start a probe of a down node in the background if it is due. The node is
+// reconnected and pinged, and marked as up again when that succeeds.
+func (n *node) maybeProbe() {
+	n.healthMutex.Lock()
+	due := !n.isUp() && !time.Now().Before(n.nextProbe)
+	n.healthMutex.Unlock()
+	if !due || !atomic.CompareAndSwapInt32(&n.probing, 0, 1) {
+		return
+	}
+	go func() {
+		defer atomic.StoreInt32(&n.probing, 0)
+		if n.ping() == nil {
+			n.markUp()
+			return
+		}
+		n.healthMutex.Lock()
+		n.nextProbe = time.Now().Add(n.client.probeInterval)
+		n.healthMutex.Unlock()
+	}()
+}
+
+// Ping this specific node
+func (n *node) ping() (err error) {
+	err, conn := n.getConn(context.Background())
+	if err != nil {
+		return err
+	}
+	err = n.client.write(conn, []byte{0, 0, 0, 1, rpbPingReq})
+	if err != nil {
+		n.client.ioError(conn, err)
+		return err
+	}
+	return n.client.response(conn, nil)
+}
+
+func (n *node) status() NodeStatus {
+	n.healthMutex.Lock()
+	defer n.healthMutex.Unlock()
+	return NodeStatus{
+		Addr:        n.addr,
+		Up:          n.isUp(),
+		DownSince:   n.downSince,
+		Outstanding: int(atomic.LoadInt32(&n.outstanding)),
+		Open:        int(atomic.LoadInt32(&n.open)),
+	}
+}
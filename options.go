@@ -0,0 +1,301 @@
+package riak
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/tpjg/goriakpbc/pb"
+)
+
+/*
+Option is a typed request option. All functions that take options accept
+these as well as plain maps (e.g. R1 or map[string]uint32{"r": 2}), so they can
+be mixed:
+
+	obj, err := bucket.Get("key", riak.R(2), riak.Timeout(500*time.Millisecond), riak.BasicQuorum(true))
+
+Options a call cannot honour are rejected with an *OptionError instead of being
+ignored, e.g. W for Get. The options given to Get, NewObject, FetchCounter/Set/Map
+and GetCounter are kept by the returned object, its later Store, Reload and
+Destroy calls each use the kept options they support. Update accepts the
+options of both Get and Store.
+*/
+type Option map[string]uint32
+
+// Returned when an option is given to a call that does not support it
+type OptionError struct {
+	Option    string
+	Operation string
+}
+
+func (e *OptionError) Error() string {
+	return fmt.Sprintf("Option %q is not supported by %v", e.Option, e.Operation)
+}
+
+// Read quorum
+func R(r uint32) Option { return Option{"r": r} }
+
+// Primary read quorum
+func PR(pr uint32) Option { return Option{"pr": pr} }
+
+// Write quorum
+func W(w uint32) Option { return Option{"w": w} }
+
+// Durable write quorum
+func DW(dw uint32) Option { return Option{"dw": dw} }
+
+// Primary write quorum
+func PW(pw uint32) Option { return Option{"pw": pw} }
+
+// Quorum for deletes
+func RW(rw uint32) Option { return Option{"rw": rw} }
+
+// Number of replicas to use for this request
+func NVal(nval uint32) Option { return Option{"n_val": nval} }
+
+// Server side timeout of the request, in milliseconds on the wire. It is
+// rounded up to a millisecond and limited to what fits in 32 bits.
+func Timeout(timeout time.Duration) Option {
+	return Option{"timeout": timeoutMillis(timeout)}
+}
+
+// Convert a timeout to the milliseconds Riak expects, a timeout of less than
+// a millisecond would be 0 and a very long one would wrap around
+func timeoutMillis(timeout time.Duration) uint32 {
+	ms := (timeout + time.Millisecond - 1) / time.Millisecond
+	if ms < 1 {
+		return 1
+	}
+	if ms > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(ms)
+}
+
+// Return as soon as a quorum of "not found" responses is reached
+func BasicQuorum(b bool) Option { return boolOption("basic_quorum", b) }
+
+// Treat "not found" responses as successful reads
+func NotfoundOk(b bool) Option { return boolOption("notfound_ok", b) }
+
+// Allow fallback vnodes to be used for the quorum
+func SloppyQuorum(b bool) Option { return boolOption("sloppy_quorum", b) }
+
+// Only return the metadata of an object, not the value
+func Head(b bool) Option { return boolOption("head", b) }
+
+// Return the stored value after a write
+func ReturnBody(b bool) Option { return boolOption("return_body", b) }
+
+// Only store the object if the key does not exist yet
+func IfNoneMatch(b bool) Option { return boolOption("if_none_match", b) }
+
+// Only store the object if the vclock matches the stored vclock
+func IfNotModified(b bool) Option { return boolOption("if_not_modified", b) }
+
+// Store the object as is, without updating the vclock
+func Asis(b bool) Option { return boolOption("asis", b) }
+
+// Include the opaque context in data type responses
+func IncludeContext(b bool) Option { return boolOption("include_context", b) }
+
+func boolOption(key string, b bool) Option {
+	if b {
+		return Option{key: 1}
+	}
+	return Option{key: 0}
+}
+
+// The options supported by each type of request
+var (
+	getOptionKeys           = []string{"r", "pr", "basic_quorum", "notfound_ok", "head", "timeout", "sloppy_quorum", "n_val"}
+	putOptionKeys           = []string{"w", "dw", "pw", "return_body", "if_not_modified", "if_none_match", "timeout", "asis", "sloppy_quorum", "n_val"}
+	delOptionKeys           = []string{"rw", "r", "w", "pr", "pw", "dw", "timeout", "sloppy_quorum", "n_val"}
+	fetchOptionKeys         = []string{"r", "pr", "basic_quorum", "notfound_ok", "timeout", "sloppy_quorum", "n_val", "include_context"}
+	updateOptionKeys        = []string{"w", "dw", "pw", "return_body", "timeout", "sloppy_quorum", "n_val", "include_context"}
+	indexOptionKeys         = []string{"timeout"}
+	listKeysOptionKeys      = []string{"timeout"}
+	listBucketsOptionKeys   = []string{"timeout"}
+	counterGetOptionKeys    = []string{"r", "pr", "basic_quorum", "notfound_ok"}
+	counterUpdateOptionKeys = []string{"w", "dw", "pw"}
+)
+
+// The operations that share the options kept by an object, see keptOptions
+var (
+	objectOperations   = [][]string{getOptionKeys, putOptionKeys, delOptionKeys}
+	dataTypeOperations = [][]string{fetchOptionKeys, updateOptionKeys, delOptionKeys}
+	counterOperations  = [][]string{counterGetOptionKeys, counterUpdateOptionKeys, delOptionKeys}
+)
+
+func contains(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// The parsed options of a request
+type reqOptions struct {
+	values map[string]uint32
+}
+
+// Parse and validate the options for an operation, keys that are not in
+// allowed are rejected.
+func parseOptions(operation string, allowed []string, options []map[string]uint32) (o reqOptions, err error) {
+	return keptOptions(operation, allowed, options)
+}
+
+/*
+Parse the options kept by an object for one of its operations. The kept
+options are shared by the operations of the object (e.g. the options given to
+Get are kept for Reload and Store), so the keys of the other operations are
+skipped; keys that none of them supports are rejected.
+*/
+func keptOptions(operation string, allowed []string, options []map[string]uint32, operations ...[]string) (o reqOptions, err error) {
+	o.values = make(map[string]uint32)
+	for _, omap := range options {
+	next:
+		for k, v := range omap {
+			if contains(allowed, k) {
+				o.values[k] = v
+				continue
+			}
+			for _, keys := range operations {
+				if contains(keys, k) {
+					continue next
+				}
+			}
+			return o, &OptionError{Option: k, Operation: operation}
+		}
+	}
+	return o, nil
+}
+
+// Return the options with only the allowed keys
+func selectOptions(allowed []string, options []map[string]uint32) []map[string]uint32 {
+	selected := Option{}
+	for _, omap := range options {
+		for k, v := range omap {
+			if contains(allowed, k) {
+				selected[k] = v
+			}
+		}
+	}
+	return []map[string]uint32{selected}
+}
+
+// Return the value of a numeric option, nil if it is not set
+func (o reqOptions) num(key string) *uint32 {
+	if v, ok := o.values[key]; ok {
+		return &v
+	}
+	return nil
+}
+
+// Return the value of a boolean option, nil if it is not set
+func (o reqOptions) flag(key string) *bool {
+	if v, ok := o.values[key]; ok {
+		b := v != 0
+		return &b
+	}
+	return nil
+}
+
+// Set a field only if the option was given, so defaults set by the caller stay
+func setUint32(field **uint32, v *uint32) {
+	if v != nil {
+		*field = v
+	}
+}
+
+func setBool(field **bool, v *bool) {
+	if v != nil {
+		*field = v
+	}
+}
+
+func (o reqOptions) applyGet(req *pb.RpbGetReq) {
+	setUint32(&req.R, o.num("r"))
+	setUint32(&req.Pr, o.num("pr"))
+	setBool(&req.BasicQuorum, o.flag("basic_quorum"))
+	setBool(&req.NotfoundOk, o.flag("notfound_ok"))
+	setBool(&req.Head, o.flag("head"))
+	setUint32(&req.Timeout, o.num("timeout"))
+	setBool(&req.SloppyQuorum, o.flag("sloppy_quorum"))
+	setUint32(&req.NVal, o.num("n_val"))
+}
+
+func (o reqOptions) applyPut(req *pb.RpbPutReq) {
+	setUint32(&req.W, o.num("w"))
+	setUint32(&req.Dw, o.num("dw"))
+	setUint32(&req.Pw, o.num("pw"))
+	setBool(&req.ReturnBody, o.flag("return_body"))
+	setBool(&req.IfNotModified, o.flag("if_not_modified"))
+	setBool(&req.IfNoneMatch, o.flag("if_none_match"))
+	setUint32(&req.Timeout, o.num("timeout"))
+	setBool(&req.Asis, o.flag("asis"))
+	setBool(&req.SloppyQuorum, o.flag("sloppy_quorum"))
+	setUint32(&req.NVal, o.num("n_val"))
+}
+
+func (o reqOptions) applyDel(req *pb.RpbDelReq) {
+	setUint32(&req.Rw, o.num("rw"))
+	setUint32(&req.R, o.num("r"))
+	setUint32(&req.W, o.num("w"))
+	setUint32(&req.Pr, o.num("pr"))
+	setUint32(&req.Pw, o.num("pw"))
+	setUint32(&req.Dw, o.num("dw"))
+	setUint32(&req.Timeout, o.num("timeout"))
+	setBool(&req.SloppyQuorum, o.flag("sloppy_quorum"))
+	setUint32(&req.NVal, o.num("n_val"))
+}
+
+func (o reqOptions) applyFetch(req *pb.DtFetchReq) {
+	setUint32(&req.R, o.num("r"))
+	setUint32(&req.Pr, o.num("pr"))
+	setBool(&req.BasicQuorum, o.flag("basic_quorum"))
+	setBool(&req.NotfoundOk, o.flag("notfound_ok"))
+	setUint32(&req.Timeout, o.num("timeout"))
+	setBool(&req.SloppyQuorum, o.flag("sloppy_quorum"))
+	setUint32(&req.NVal, o.num("n_val"))
+	setBool(&req.IncludeContext, o.flag("include_context"))
+}
+
+func (o reqOptions) applyUpdate(req *pb.DtUpdateReq) {
+	setUint32(&req.W, o.num("w"))
+	setUint32(&req.Dw, o.num("dw"))
+	setUint32(&req.Pw, o.num("pw"))
+	setBool(&req.ReturnBody, o.flag("return_body"))
+	setUint32(&req.Timeout, o.num("timeout"))
+	setBool(&req.SloppyQuorum, o.flag("sloppy_quorum"))
+	setUint32(&req.NVal, o.num("n_val"))
+	setBool(&req.IncludeContext, o.flag("include_context"))
+}
+
+func (o reqOptions) applyIndex(req *pb.RpbIndexReq) {
+	setUint32(&req.Timeout, o.num("timeout"))
+}
+
+func (o reqOptions) applyListKeys(req *pb.RpbListKeysReq) {
+	setUint32(&req.Timeout, o.num("timeout"))
+}
+
+func (o reqOptions) applyListBuckets(req *pb.RpbListBucketsReq) {
+	setUint32(&req.Timeout, o.num("timeout"))
+}
+
+func (o reqOptions) applyCounterGet(req *pb.RpbCounterGetReq) {
+	setUint32(&req.R, o.num("r"))
+	setUint32(&req.Pr, o.num("pr"))
+	setBool(&req.BasicQuorum, o.flag("basic_quorum"))
+	setBool(&req.NotfoundOk, o.flag("notfound_ok"))
+}
+
+func (o reqOptions) applyCounterUpdate(req *pb.RpbCounterUpdateReq) {
+	setUint32(&req.W, o.num("w"))
+	setUint32(&req.Dw, o.num("dw"))
+	setUint32(&req.Pw, o.num("pw"))
+}
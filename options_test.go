@@ -0,0 +1,104 @@
+package riak
+
+import (
+	"github.com/bmizerany/assert"
+	"github.com/tpjg/goriakpbc/pb"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParseOptions(t *testing.T) {
+	// Typed options and maps can be mixed
+	opts, err := parseOptions("Get", getOptionKeys, []map[string]uint32{R1, PR(2), Timeout(1500 * time.Millisecond), BasicQuorum(true), Head(false)})
+	assert.T(t, err == nil)
+	req := &pb.RpbGetReq{}
+	opts.applyGet(req)
+	assert.T(t, req.GetR() == 1)
+	assert.T(t, req.GetPr() == 2)
+	assert.T(t, req.GetTimeout() == 1500)
+	assert.T(t, req.BasicQuorum != nil && req.GetBasicQuorum() == true)
+	assert.T(t, req.Head != nil && req.GetHead() == false)
+	assert.T(t, req.NotfoundOk == nil)
+
+	// Timeouts are rounded up to a millisecond and do not wrap around
+	assert.T(t, Timeout(time.Microsecond)["timeout"] == 1)
+	assert.T(t, Timeout(1500 * time.Microsecond)["timeout"] == 2)
+	assert.T(t, Timeout(-time.Second)["timeout"] == 1)
+	assert.T(t, Timeout(1 << 32 * time.Millisecond)["timeout"] == math.MaxUint32)
+
+	// Options that are not supported are rejected
+	_, err = parseOptions("Get", getOptionKeys, []map[string]uint32{W1})
+	assert.T(t, err != nil)
+	oerr, ok := err.(*OptionError)
+	assert.T(t, ok)
+	assert.T(t, oerr.Option == "w")
+	assert.T(t, oerr.Operation == "Get")
+
+	// Objects keep their options, each operation uses the ones it supports
+	kept := []map[string]uint32{R1, PR1, W1, IfNoneMatch(true), NVal(2), RW(2)}
+	opts, err = keptOptions("Store", putOptionKeys, kept, objectOperations...)
+	assert.T(t, err == nil)
+	put := &pb.RpbPutReq{}
+	opts.applyPut(put)
+	assert.T(t, put.GetW() == 1)
+	assert.T(t, put.GetIfNoneMatch() == true)
+	assert.T(t, put.GetNVal() == 2)
+	assert.T(t, opts.num("r") == nil && opts.num("rw") == nil)
+	opts, err = keptOptions("Reload", getOptionKeys, kept, objectOperations...)
+	assert.T(t, err == nil)
+	assert.T(t, *opts.num("r") == 1 && opts.num("w") == nil && opts.flag("if_none_match") == nil)
+	_, err = keptOptions("Store", putOptionKeys, []map[string]uint32{IncludeContext(true)}, objectOperations...)
+	assert.T(t, err != nil)
+
+	// Data type options
+	opts, err = parseOptions("Fetch", fetchOptionKeys, []map[string]uint32{{"include_context": 0}, SloppyQuorum(false)})
+	assert.T(t, err == nil)
+	fetch := &pb.DtFetchReq{}
+	opts.applyFetch(fetch)
+	assert.T(t, fetch.IncludeContext != nil && *fetch.IncludeContext == false)
+	assert.T(t, fetch.SloppyQuorum != nil && *fetch.SloppyQuorum == false)
+}
+
+func TestRejectedOptions(t *testing.T) {
+	// Options are validated before anything is sent, so no connection is needed
+	client := NewClient(riakhost)
+	bucket := &Bucket{name: "options_test.go", bucket_type: "default", client: client}
+
+	err := bucket.Delete("key", IncludeContext(true))
+	_, ok := err.(*OptionError)
+	assert.T(t, ok)
+
+	_, err = bucket.IndexQuery("test_int", "1", R1)
+	_, ok = err.(*OptionError)
+	assert.T(t, ok)
+
+	_, err = bucket.ListKeys(map[string]uint32{"rr": 1})
+	_, ok = err.(*OptionError)
+	assert.T(t, ok)
+
+	obj := bucket.NewObject("key", Asis(true), map[string]uint32{"include_context": 1})
+	err = obj.Store()
+	_, ok = err.(*OptionError)
+	assert.T(t, ok)
+
+	// Every call only accepts the options it honours itself
+	for _, option := range []Option{W1, IfNoneMatch(true), Asis(true), RW(1)} {
+		_, err = bucket.Get("key", option)
+		_, ok = err.(*OptionError)
+		assert.T(t, ok)
+	}
+	_, err = bucket.Exists("key", W1)
+	_, ok = err.(*OptionError)
+	assert.T(t, ok)
+	_, err = bucket.GetCounter("key", Timeout(time.Second))
+	_, ok = err.(*OptionError)
+	assert.T(t, ok)
+	_, err = bucket.FetchSet("key", ReturnBody(true))
+	_, ok = err.(*OptionError)
+	assert.T(t, ok)
+	counter, _ := bucket.GetCounterWithoutLoad("key", Head(true))
+	err = counter.Reload()
+	_, ok = err.(*OptionError)
+	assert.T(t, ok)
+}
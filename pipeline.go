@@ -0,0 +1,321 @@
+package riak
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// The default maximum number of requests in flight on a pipelined connection
+const DefaultPipelineDepth = 32
+
+/*
+A pipeline sends asynchronous requests on a single connection without waiting
+for the responses, Riak answers the requests on a connection in order so the
+responses are matched to the requests in the order they were sent. The
+connection is taken from the pool of a node when the first request is sent
+and released when all responses were read, so it can be used by synchronous
+requests in the meantime.
+*/
+type pipeline struct {
+	client  *Client
+	conn    *nodeConn
+	pending chan *call    // Requests that were sent and wait for their response, in order
+	slots   chan struct{} // Limits the number of requests in flight
+	mutex   sync.Mutex    // Held while sending a request and while closing
+	closed  int32         // Set to 1 when no more requests can be sent
+	err     error         // The I/O error that broke the connection
+	stop    chan struct{} // Closed when the pipeline is closed
+}
+
+// A request sent on a pipeline
+type call struct {
+	code   byte
+	req    proto.Message
+	resp   proto.Message
+	info   *RequestInfo
+	future *Future
+	result func(err error) error
+}
+
+// Set the maximum number of asynchronous requests in flight on a single
+// connection, 0 uses DefaultPipelineDepth. It applies to connections that
+// are pipelined after the call.
+func (c *Client) SetPipelineDepth(depth int) {
+	c.pipelineDepth = depth
+}
+
+// Set the maximum time to write a request to a pipelined connection, a write
+// that takes longer breaks the connection and fails all asynchronous requests
+// in flight on it. Zero (the default) does not limit writes, the context of a
+// request does not abort its write either.
+func (c *Client) SetPipelineWriteTimeout(timeout time.Duration) {
+	c.pipeWriteTimeout = timeout
+}
+
+/*
+Send a request on a pipeline and complete the future when the response
+arrives. The result function is called with the error of the request (nil if
+it succeeded) and returns the error of the future, it is not called if the
+future was already completed because the context is done.
+*/
+func (c *Client) async(ctx context.Context, f *Future, req proto.Message, code byte, resp proto.Message, result func(err error) error) {
+	call := &call{code: code, req: req, resp: resp, future: f, result: result}
+	msgbuf, err := frame(req, code)
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err == nil {
+		err = c.send(ctx, call, msgbuf)
+	}
+	if err != nil {
+		f.complete(func() error { return result(err) })
+		return
+	}
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				f.complete(ctx.Err)
+			case <-f.done:
+			}
+		}()
+	}
+}
+
+// Send a request on the pipeline of one of the nodes, waiting while the
+// pipeline is full.
+func (c *Client) send(ctx context.Context, call *call, msgbuf []byte) error {
+	for {
+		n, err := c.pickNode()
+		if err != nil {
+			return err
+		}
+		p, err := n.pipeline(ctx)
+		if err != nil {
+			return err
+		}
+		select {
+		case p.slots <- struct{}{}:
+		case <-ctx.Done():
+			p.closeIfIdle()
+			return ctx.Err()
+		}
+		if sent, err := p.send(ctx, call, msgbuf); sent {
+			return err
+		}
+		// The pipeline was closed in the meantime, use a new one
+	}
+}
+
+// Return the pipeline of the node, a connection is taken from the pool if
+// there is none. The connection is dialed without holding the lock, so other
+// requests do not wait for it.
+func (n *node) pipeline(ctx context.Context) (*pipeline, error) {
+	if p := n.openPipeline(); p != nil {
+		return p, nil
+	}
+	err, conn := n.getConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// The connection is shared by the requests on the pipeline, so it is not
+	// bound to the context of the first one
+	conn.unwatch()
+	n.pipeMutex.Lock()
+	defer n.pipeMutex.Unlock()
+	if p := n.pipe; p != nil && atomic.LoadInt32(&p.closed) == 0 {
+		// Another request created a pipeline in the meantime
+		n.releaseConn(conn)
+		return p, nil
+	}
+	depth := n.client.pipelineDepth
+	if depth <= 0 {
+		depth = DefaultPipelineDepth
+	}
+	p := &pipeline{
+		client:  n.client,
+		conn:    conn,
+		pending: make(chan *call, depth),
+		slots:   make(chan struct{}, depth),
+		stop:    make(chan struct{}),
+	}
+	n.pipe = p
+	go p.run()
+	return p, nil
+}
+
+// Return the pipeline of the node if it can still be used, otherwise nil
+func (n *node) openPipeline() *pipeline {
+	n.pipeMutex.Lock()
+	defer n.pipeMutex.Unlock()
+	if p := n.pipe; p != nil && atomic.LoadInt32(&p.closed) == 0 {
+		return p
+	}
+	return nil
+}
+
+/*
+Write a request to the connection, the caller must hold a slot. Returns false
+if the pipeline was closed, the slot is released then. When the context is
+done before the request was written the context error is returned, but the
+write is not aborted: a partly written request would break the connection for
+the other requests on the pipeline.
+*/
+func (p *pipeline) send(ctx context.Context, call *call, msgbuf []byte) (sent bool, err error) {
+	if ctx.Done() == nil {
+		return p.write(ctx, call, msgbuf)
+	}
+	type result struct {
+		sent bool
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		sent, err := p.write(ctx, call, msgbuf)
+		done <- result{sent, err}
+	}()
+	select {
+	case r := <-done:
+		return r.sent, r.err
+	case <-ctx.Done():
+		return true, ctx.Err()
+	}
+}
+
+// Write a request with the pipeline write timeout of the client and add it to
+// the requests in flight, see send.
+func (p *pipeline) write(ctx context.Context, call *call, msgbuf []byte) (sent bool, err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if atomic.LoadInt32(&p.closed) == 1 {
+		<-p.slots
+		return false, nil
+	}
+	n := p.conn.node
+	call.info = n.startRequest(ctx, call.code, call.req)
+	var deadline time.Time
+	if timeout := p.client.pipeWriteTimeout; timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	p.conn.SetWriteDeadline(deadline)
+	written, err := p.conn.Write(msgbuf)
+	if call.info != nil {
+		call.info.BytesSent += written
+	}
+	if err != nil {
+		// A partly written request breaks the connection for all requests
+		err = &NetworkError{Node: n.addr, Op: opName(call.code), Err: err}
+		n.endRequest(call.info, err)
+		<-p.slots
+		p.fail(err)
+		return true, err
+	}
+	// The reader only reads a response after it took the request from
+	// pending, so it does not matter that the response may already be there.
+	p.pending <- call
+	return true, nil
+}
+
+// Read the responses and complete the requests in order, until the pipeline
+// is closed.
+func (p *pipeline) run() {
+	for {
+		select {
+		case call := <-p.pending:
+			if err := p.receive(call); err != nil {
+				p.mutex.Lock()
+				p.fail(err)
+				p.mutex.Unlock()
+			}
+			<-p.slots
+			p.closeIfIdle()
+		case <-p.stop:
+			p.release()
+			return
+		}
+	}
+}
+
+// Read the response to a request and complete it, returns the error if the
+// connection broke.
+func (p *pipeline) receive(call *call) error {
+	conn := p.conn
+	p.mutex.Lock()
+	err := p.err
+	p.mutex.Unlock()
+	if err != nil {
+		call.finish(conn.node, err)
+		return nil
+	}
+	conn.op = call.code
+	conn.req = call.info
+	msgcode, size, err := p.client.readHeader(conn)
+	var pbmsg []byte
+	if err == nil {
+		pbmsg, err = p.client.read(conn, size)
+	}
+	conn.req = nil
+	if err != nil {
+		call.finish(conn.node, err)
+		return err
+	}
+	// An error response only fails this request
+	call.finish(conn.node, decode(msgcode, pbmsg, call.resp))
+	return nil
+}
+
+// Break the pipeline after an I/O error, the requests in flight fail with
+// the same error. The caller must hold the mutex.
+func (p *pipeline) fail(err error) {
+	if p.err == nil {
+		p.err = err
+		// Abort a read that is in progress
+		p.conn.SetDeadline(time.Unix(1, 0))
+	}
+	p.close()
+}
+
+// Close the pipeline if there are no requests in flight
+func (p *pipeline) closeIfIdle() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if len(p.pending) == 0 && len(p.slots) == 0 {
+		p.close()
+	}
+}
+
+// Close the pipeline, the caller must hold the mutex
+func (p *pipeline) close() {
+	if atomic.CompareAndSwapInt32(&p.closed, 0, 1) {
+		close(p.stop)
+	}
+}
+
+// Fail the remaining requests (if the connection broke) and release the
+// connection into the pool.
+func (p *pipeline) release() {
+	// No requests are added after the pipeline was closed
+	for len(p.pending) > 0 {
+		(<-p.pending).finish(p.conn.node, p.err)
+	}
+	if p.err != nil {
+		p.conn.broken = true
+		p.client.ioError(p.conn, p.err)
+	} else {
+		p.client.releaseConn(p.conn)
+	}
+}
+
+// Report the end of the request and complete its future, only the first call
+// has an effect.
+func (call *call) finish(n *node, err error) {
+	if call.info != nil {
+		n.endRequest(call.info, err)
+		call.info = nil
+	}
+	call.future.complete(func() error { return call.result(err) })
+}
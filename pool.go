@@ -0,0 +1,147 @@
+package riak
+
+import (
+	"bytes"
+	"sync/atomic"
+	"time"
+)
+
+/*
+Set the number of connections in the pool of each node. Connect opens min
+connections (at least one), more are opened when needed up to max. Connections
+above min are closed again when they are idle for longer than the idle timeout.
+The pool is closed and re-created, so this should be called before Connect:
+
+	client := riak.NewClient("127.0.0.1:8087")
+	client.SetPoolSize(2, 16)
+	client.SetIdleTimeout(time.Minute)
+	err := client.Connect()
+
+By default min and max are the count given to NewClientPool.
+*/
+func (c *Client) SetPoolSize(min, max int) {
+	c.Close()
+	c.minConns = min
+	c.conn_count = max
+	for i, n := range c.nodes {
+		c.nodes[i] = newNode(c, n.addr, max)
+	}
+}
+
+// Set the time after which an idle connection is closed, connections are only
+// closed while more than the minimum number of connections is open. Zero (the
+// default) keeps idle connections open.
+func (c *Client) SetIdleTimeout(timeout time.Duration) {
+	c.idleTimeout = timeout
+}
+
+// Set the maximum time a connection is used, after that it is closed and a new
+// connection is dialed. Zero (the default) re-uses connections forever.
+func (c *Client) SetMaxConnLifetime(lifetime time.Duration) {
+	c.maxLifetime = lifetime
+}
+
+// Ping connections that have been idle for the interval in the background, so
+// broken connections are replaced before a request uses them. Zero (the
+// default) disables the keepalive.
+func (c *Client) SetKeepalive(interval time.Duration) {
+	c.keepalive = interval
+}
+
+// Returns the interval at which the pools are checked for idle, old and
+// broken connections, zero if nothing needs to be checked.
+func (c *Client) maintenanceInterval() (interval time.Duration) {
+	for _, d := range []time.Duration{c.keepalive, c.idleTimeout / 2, c.maxLifetime / 2} {
+		if d > 0 && (interval == 0 || d < interval) {
+			interval = d
+		}
+	}
+	return interval
+}
+
+// Returns true if a connection was open for too long, or idle for too long
+// while more than the minimum number of connections is open
+func (n *node) expired(conn *nodeConn, now time.Time) bool {
+	if n.client.maxLifetime > 0 && now.Sub(conn.created) >= n.client.maxLifetime {
+		return true
+	}
+	return n.client.idleTimeout > 0 && now.Sub(conn.lastUsed) >= n.client.idleTimeout &&
+		int(atomic.LoadInt32(&n.open)) > n.client.minConns
+}
+
+// Start maintaining the pool in the background, until the pool is closed
+func (n *node) startMaintenance() {
+	interval := n.client.maintenanceInterval()
+	if interval <= 0 {
+		return
+	}
+	n.stop = make(chan struct{})
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				n.maintain()
+			case <-stop:
+				return
+			}
+		}
+	}(n.stop)
+}
+
+// Check the connections that are idle in the pool. Connections that are too
+// old are replaced, idle connections above the minimum are closed and the
+// others are pinged if the keepalive is enabled.
+func (n *node) maintain() {
+	n.connMutex.RLock()
+	defer n.connMutex.RUnlock()
+	for i := len(n.conns); i > 0; i-- {
+		var conn *nodeConn
+		select {
+		case conn = <-n.conns:
+		default:
+			return
+		}
+		if conn == nil {
+			// The pool was closed
+			n.conns <- conn
+			return
+		}
+		now := time.Now()
+		switch {
+		case conn.Conn == nil:
+		case n.client.maxLifetime > 0 && now.Sub(conn.created) >= n.client.maxLifetime:
+			conn.close()
+		case n.client.idleTimeout > 0 && now.Sub(conn.lastUsed) >= n.client.idleTimeout && int(atomic.LoadInt32(&n.open)) > n.client.minConns:
+			conn.close()
+		case n.client.keepalive > 0 && now.Sub(conn.lastUsed) >= n.client.keepalive:
+			n.keepalive(conn)
+		}
+		// Keep the minimum number of connections open
+		if conn.Conn == nil && int(atomic.LoadInt32(&n.open)) < n.client.minConns {
+			n.redial(conn)
+		}
+		n.conns <- conn
+	}
+}
+
+// Ping an idle connection, it is closed if the ping fails
+func (n *node) keepalive(conn *nodeConn) {
+	conn.SetDeadline(time.Now().Add(n.client.keepalive))
+	err := n.client.write(conn, []byte{0, 0, 0, 1, rpbPingReq})
+	if err == nil {
+		var msgbuf []byte
+		msgbuf, err = n.client.read(conn, 5)
+		if err == nil && !bytes.Equal(msgbuf, []byte{0, 0, 0, 1, rpbPingResp}) {
+			conn.broken = true
+		}
+	}
+	conn.endRequest(err)
+	if err != nil || conn.broken {
+		conn.close()
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	conn.lastUsed = time.Now()
+}
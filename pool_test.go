@@ -0,0 +1,148 @@
+package riak
+
+import (
+	"context"
+	"errors"
+	"github.com/bmizerany/assert"
+	"github.com/golang/protobuf/proto"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tpjg/goriakpbc/riaktest"
+)
+
+func TestDiscardBrokenConnection(t *testing.T) {
+	server, client, done := setupServer(t, 3)
+	defer done()
+	assert.T(t, client.Nodes()[0].Open == 3)
+
+	// Close the connection of a single request
+	var closed int32
+	server.SetFault(func(code byte, req proto.Message) error {
+		if atomic.CompareAndSwapInt32(&closed, 0, 1) {
+			return riaktest.CloseConnection
+		}
+		return nil
+	})
+	var nerr *NetworkError
+	assert.T(t, errors.As(client.Ping(), &nerr))
+
+	// Only that connection is discarded, the node is still up
+	status := client.Nodes()[0]
+	assert.T(t, status.Open == 2)
+	assert.T(t, status.Up)
+	for i := 0; i < 3; i++ {
+		assert.T(t, client.Ping() == nil)
+	}
+	assert.T(t, client.Nodes()[0].Open == 3)
+}
+
+func TestPoolSize(t *testing.T) {
+	server, client, done := setupServer(t, 1)
+	defer done()
+	client.SetPoolSize(1, 4)
+	client.SetIdleTimeout(50 * time.Millisecond)
+	assert.T(t, client.Connect() == nil)
+	assert.T(t, client.Nodes()[0].Open == 1)
+
+	// Concurrent requests open more connections, up to the maximum
+	block := make(chan struct{})
+	server.SetFault(func(code byte, req proto.Message) error {
+		<-block
+		return nil
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.T(t, client.Ping() == nil)
+		}()
+	}
+	for client.Nodes()[0].Outstanding < 4 {
+		time.Sleep(time.Millisecond)
+	}
+	assert.T(t, client.Nodes()[0].Open == 4)
+	close(block)
+	wg.Wait()
+
+	// Idle connections are closed down to the minimum
+	time.Sleep(200 * time.Millisecond)
+	assert.T(t, client.Nodes()[0].Open == 1)
+	assert.T(t, client.Ping() == nil)
+
+	client.SetPoolSize(3, 2)
+	assert.T(t, client.Connect() == BadNumberOfConnections)
+}
+
+func TestKeepaliveAndLifetime(t *testing.T) {
+	server, client, done := setupServer(t, 2)
+	defer done()
+
+	var pings int32
+	server.SetFault(func(code byte, req proto.Message) error {
+		if code == riaktest.PingReq {
+			atomic.AddInt32(&pings, 1)
+		}
+		return nil
+	})
+	client.Close()
+	client.SetKeepalive(20 * time.Millisecond)
+	assert.T(t, client.Connect() == nil)
+	time.Sleep(100 * time.Millisecond)
+	assert.T(t, atomic.LoadInt32(&pings) > 0)
+	assert.T(t, client.Nodes()[0].Open == 2)
+	client.Close()
+	assert.T(t, client.Nodes()[0].Open == 0)
+
+	// Connections that are too old are replaced
+	client.SetPoolSize(1, 1)
+	client.SetKeepalive(0)
+	client.SetMaxConnLifetime(time.Hour)
+	assert.T(t, client.Connect() == nil)
+	err, conn := client.getConn(context.Background())
+	assert.T(t, err == nil)
+	first := conn.Conn
+	conn.created = time.Now().Add(-2 * time.Hour)
+	client.releaseConn(conn)
+	err, conn = client.getConn(context.Background())
+	assert.T(t, err == nil)
+	assert.T(t, conn.Conn != nil && conn.Conn != first)
+	client.releaseConn(conn)
+	assert.T(t, client.Nodes()[0].Open == 1)
+}
+
+func TestIdleMinConns(t *testing.T) {
+	client := setupConnection(t)
+	defer client.Close()
+
+	// Idle connections are kept open up to the minimum
+	client.SetPoolSize(1, 1)
+	client.SetIdleTimeout(20 * time.Millisecond)
+	assert.T(t, client.Connect() == nil)
+	err, conn := client.getConn(context.Background())
+	assert.T(t, err == nil)
+	first := conn.Conn
+	client.releaseConn(conn)
+	time.Sleep(100 * time.Millisecond)
+	err, conn = client.getConn(context.Background())
+	assert.T(t, err == nil)
+	assert.T(t, conn.Conn == first)
+	client.releaseConn(conn)
+	assert.T(t, client.Nodes()[0].Open == 1)
+
+	// Above the minimum they are replaced
+	client.SetPoolSize(0, 1)
+	assert.T(t, client.Connect() == nil)
+	err, conn = client.getConn(context.Background())
+	assert.T(t, err == nil)
+	first = conn.Conn
+	client.releaseConn(conn)
+	time.Sleep(100 * time.Millisecond)
+	err, conn = client.getConn(context.Background())
+	assert.T(t, err == nil)
+	assert.T(t, conn.Conn != nil && conn.Conn != first)
+	client.releaseConn(conn)
+}
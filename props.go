@@ -0,0 +1,314 @@
+package riak
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/tpjg/goriakpbc/pb"
+)
+
+// An Erlang module and function, used for commit hooks and the chash_keyfun
+// and linkfun properties
+type ModFun struct {
+	Module   string
+	Function string
+}
+
+// A commit hook, either an Erlang module and function or the name of a
+// JavaScript function
+type CommitHook struct {
+	ModFun *ModFun
+	Name   string
+}
+
+// The replication mode of a bucket (Riak Enterprise)
+type ReplMode int32
+
+const (
+	ReplFalse    ReplMode = ReplMode(pb.RpbBucketProps_FALSE)
+	ReplRealtime ReplMode = ReplMode(pb.RpbBucketProps_REALTIME)
+	ReplFullsync ReplMode = ReplMode(pb.RpbBucketProps_FULLSYNC)
+	ReplTrue     ReplMode = ReplMode(pb.RpbBucketProps_TRUE)
+)
+
+/*
+The properties of a bucket. The quorum properties (R, W, PR, PW, DW and RW)
+are a number of replicas or one of the symbolic values QuorumOne,
+QuorumMajority, QuorumAll and QuorumDefault. Datatype and Consistent can only
+be set on the bucket type.
+*/
+type BucketProps struct {
+	NVal          uint32
+	AllowMult     bool
+	LastWriteWins bool
+	Precommit     []CommitHook
+	Postcommit    []CommitHook
+	ChashKeyfun   *ModFun
+	Linkfun       *ModFun
+	OldVclock     uint32
+	YoungVclock   uint32
+	BigVclock     uint32
+	SmallVclock   uint32
+	PR            uint32
+	R             uint32
+	W             uint32
+	PW            uint32
+	DW            uint32
+	RW            uint32
+	BasicQuorum   bool
+	NotfoundOk    bool
+	Backend       string
+	Search        bool
+	Repl          ReplMode
+	SearchIndex   string
+	Datatype      string
+	Consistent    bool
+}
+
+// Return the properties of the bucket, as they were when the bucket was
+// created or its properties were last set
+func (b *Bucket) Props() BucketProps {
+	return b.props
+}
+
+// Set the properties of the bucket, only the properties that are different
+// from Props are sent
+func (b *Bucket) SetProps(props BucketProps) error {
+	return b.SetPropsContext(context.Background(), props)
+}
+
+// Set the properties of the bucket, the request is aborted when the context is done
+func (b *Bucket) SetPropsContext(ctx context.Context, props BucketProps) error {
+	changed := props.changed(&b.props)
+	if reflect.DeepEqual(changed, &pb.RpbBucketProps{}) {
+		return nil
+	}
+	return b.setProps(ctx, props, changed)
+}
+
+// Send the changed properties, and keep the new properties if that succeeded
+func (b *Bucket) setProps(ctx context.Context, props BucketProps, changed *pb.RpbBucketProps) error {
+	req := &pb.RpbSetBucketReq{Bucket: []byte(b.name), Type: []byte(b.bucket_type), Props: changed}
+	err := b.client.do(ctx, false, req, rpbSetBucketReq, req)
+	if err != nil {
+		return err
+	}
+	b.props = props
+	return nil
+}
+
+// Reset the properties of the bucket to the defaults of its bucket type, the
+// properties are fetched again afterwards
+func (b *Bucket) Reset() error {
+	return b.ResetContext(context.Background())
+}
+
+// Reset the properties of the bucket, the request is aborted when the context is done
+func (b *Bucket) ResetContext(ctx context.Context) error {
+	req := &pb.RpbResetBucketReq{Bucket: []byte(b.name), Type: []byte(b.bucket_type)}
+	err := b.client.do(ctx, false, req, rpbResetBucketReq, req)
+	if err != nil {
+		return err
+	}
+	props, err := b.client.bucketProps(ctx, b.bucket_type, b.name)
+	if err != nil {
+		return err
+	}
+	b.props = props
+	return nil
+}
+
+// Fetch the properties of a bucket, an empty type is the default type
+func (c *Client) bucketProps(ctx context.Context, btype string, name string) (BucketProps, error) {
+	req := &pb.RpbGetBucketReq{Bucket: []byte(name)}
+	if btype != "" {
+		req.Type = []byte(btype)
+	}
+	resp := &pb.RpbGetBucketResp{}
+	err := c.do(ctx, true, req, rpbGetBucketReq, resp)
+	if err != nil {
+		return BucketProps{}, err
+	}
+	return bucketPropsFromPb(resp.Props), nil
+}
+
+// Return the properties of a bucket type, they are the defaults for the
+// buckets of the type
+func (c *Client) BucketTypeProps(btype string) (BucketProps, error) {
+	return c.BucketTypePropsContext(context.Background(), btype)
+}
+
+// Return the properties of a bucket type, the request is aborted when the context is done
+func (c *Client) BucketTypePropsContext(ctx context.Context, btype string) (BucketProps, error) {
+	if btype == "" {
+		return BucketProps{}, NoBucketName
+	}
+	req := &pb.RpbGetBucketTypeReq{Type: []byte(btype)}
+	resp := &pb.RpbGetBucketResp{}
+	err := c.do(ctx, true, req, rpbGetBucketTypeReq, resp)
+	if err != nil {
+		return BucketProps{}, err
+	}
+	return bucketPropsFromPb(resp.Props), nil
+}
+
+/*
+Set the properties of an existing bucket type. The current properties of the
+type are fetched first and only the properties that are different are sent,
+so the Datatype and Consistent properties, which cannot be changed once the
+type is active, can be left as they are.
+*/
+func (c *Client) SetBucketTypeProps(btype string, props BucketProps) error {
+	return c.SetBucketTypePropsContext(context.Background(), btype, props)
+}
+
+// Set the properties of a bucket type, the requests are aborted when the context is done
+func (c *Client) SetBucketTypePropsContext(ctx context.Context, btype string, props BucketProps) error {
+	current, err := c.BucketTypePropsContext(ctx, btype)
+	if err != nil {
+		return err
+	}
+	changed := props.changed(&current)
+	if reflect.DeepEqual(changed, &pb.RpbBucketProps{}) {
+		return nil
+	}
+	req := &pb.RpbSetBucketTypeReq{Type: []byte(btype), Props: changed}
+	return c.do(ctx, false, req, rpbSetBucketTypeReq, req)
+}
+
+func bucketPropsFromPb(p *pb.RpbBucketProps) BucketProps {
+	return BucketProps{
+		NVal:          p.GetNVal(),
+		AllowMult:     p.GetAllowMult(),
+		LastWriteWins: p.GetLastWriteWins(),
+		Precommit:     commitHooksFromPb(p.GetPrecommit()),
+		Postcommit:    commitHooksFromPb(p.GetPostcommit()),
+		ChashKeyfun:   modFunFromPb(p.GetChashKeyfun()),
+		Linkfun:       modFunFromPb(p.GetLinkfun()),
+		OldVclock:     p.GetOldVclock(),
+		YoungVclock:   p.GetYoungVclock(),
+		BigVclock:     p.GetBigVclock(),
+		SmallVclock:   p.GetSmallVclock(),
+		PR:            p.GetPr(),
+		R:             p.GetR(),
+		W:             p.GetW(),
+		PW:            p.GetPw(),
+		DW:            p.GetDw(),
+		RW:            p.GetRw(),
+		BasicQuorum:   p.GetBasicQuorum(),
+		NotfoundOk:    p.GetNotfoundOk(),
+		Backend:       string(p.GetBackend()),
+		Search:        p.GetSearch(),
+		Repl:          ReplMode(p.GetRepl()),
+		SearchIndex:   string(p.GetSearchIndex()),
+		Datatype:      string(p.GetDatatype()),
+		Consistent:    p.GetConsistent(),
+	}
+}
+
+// Return the properties that are different from old
+func (p *BucketProps) changed(old *BucketProps) *pb.RpbBucketProps {
+	c := &pb.RpbBucketProps{}
+	if p.NVal != old.NVal {
+		c.NVal = &p.NVal
+	}
+	if p.AllowMult != old.AllowMult {
+		c.AllowMult = &p.AllowMult
+	}
+	if p.LastWriteWins != old.LastWriteWins {
+		c.LastWriteWins = &p.LastWriteWins
+	}
+	// The hooks are replaced, has_precommit makes an empty list remove them
+	if !reflect.DeepEqual(p.Precommit, old.Precommit) {
+		c.Precommit = commitHooksToPb(p.Precommit)
+		c.HasPrecommit = proto.Bool(true)
+	}
+	if !reflect.DeepEqual(p.Postcommit, old.Postcommit) {
+		c.Postcommit = commitHooksToPb(p.Postcommit)
+		c.HasPostcommit = proto.Bool(true)
+	}
+	if !reflect.DeepEqual(p.ChashKeyfun, old.ChashKeyfun) {
+		c.ChashKeyfun = p.ChashKeyfun.toPb()
+	}
+	if !reflect.DeepEqual(p.Linkfun, old.Linkfun) {
+		c.Linkfun = p.Linkfun.toPb()
+	}
+	changedUint32(&c.OldVclock, p.OldVclock, old.OldVclock)
+	changedUint32(&c.YoungVclock, p.YoungVclock, old.YoungVclock)
+	changedUint32(&c.BigVclock, p.BigVclock, old.BigVclock)
+	changedUint32(&c.SmallVclock, p.SmallVclock, old.SmallVclock)
+	changedUint32(&c.Pr, p.PR, old.PR)
+	changedUint32(&c.R, p.R, old.R)
+	changedUint32(&c.W, p.W, old.W)
+	changedUint32(&c.Pw, p.PW, old.PW)
+	changedUint32(&c.Dw, p.DW, old.DW)
+	changedUint32(&c.Rw, p.RW, old.RW)
+	if p.BasicQuorum != old.BasicQuorum {
+		c.BasicQuorum = &p.BasicQuorum
+	}
+	if p.NotfoundOk != old.NotfoundOk {
+		c.NotfoundOk = &p.NotfoundOk
+	}
+	if p.Backend != old.Backend {
+		c.Backend = []byte(p.Backend)
+	}
+	if p.Search != old.Search {
+		c.Search = &p.Search
+	}
+	if p.Repl != old.Repl {
+		c.Repl = pb.RpbBucketProps_RpbReplMode(p.Repl).Enum()
+	}
+	if p.SearchIndex != old.SearchIndex {
+		c.SearchIndex = []byte(p.SearchIndex)
+	}
+	if p.Datatype != old.Datatype {
+		c.Datatype = []byte(p.Datatype)
+	}
+	if p.Consistent != old.Consistent {
+		c.Consistent = &p.Consistent
+	}
+	return c
+}
+
+func changedUint32(field **uint32, value uint32, old uint32) {
+	if value != old {
+		*field = &value
+	}
+}
+
+func modFunFromPb(m *pb.RpbModFun) *ModFun {
+	if m == nil {
+		return nil
+	}
+	return &ModFun{Module: string(m.Module), Function: string(m.Function)}
+}
+
+func (m *ModFun) toPb() *pb.RpbModFun {
+	if m == nil {
+		return nil
+	}
+	return &pb.RpbModFun{Module: []byte(m.Module), Function: []byte(m.Function)}
+}
+
+func commitHooksFromPb(hooks []*pb.RpbCommitHook) []CommitHook {
+	if len(hooks) == 0 {
+		return nil
+	}
+	result := make([]CommitHook, len(hooks))
+	for i, h := range hooks {
+		result[i] = CommitHook{ModFun: modFunFromPb(h.Modfun), Name: string(h.Name)}
+	}
+	return result
+}
+
+func commitHooksToPb(hooks []CommitHook) []*pb.RpbCommitHook {
+	result := make([]*pb.RpbCommitHook, len(hooks))
+	for i, h := range hooks {
+		result[i] = &pb.RpbCommitHook{Modfun: h.ModFun.toPb()}
+		if h.Name != "" {
+			result[i].Name = []byte(h.Name)
+		}
+	}
+	return result
+}
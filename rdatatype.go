@@ -1,6 +1,7 @@
 package riak
 
 import (
+	"context"
 	"errors"
 
 	"github.com/tpjg/goriakpbc/pb"
@@ -25,7 +26,12 @@ type RDataType interface {
 }
 
 func (b *Bucket) FetchCounter(key string, options ...map[string]uint32) (obj *RDtCounter, err error) {
-	o, err := b.fetch(key, options...)
+	return b.FetchCounterContext(context.Background(), key, options...)
+}
+
+// FetchCounterContext is the same as FetchCounter, but the request is aborted when the context is done
+func (b *Bucket) FetchCounterContext(ctx context.Context, key string, options ...map[string]uint32) (obj *RDtCounter, err error) {
+	o, err := b.fetch(ctx, key, options...)
 	if o != nil {
 		obj = o.(*RDtCounter)
 	}
@@ -33,7 +39,12 @@ func (b *Bucket) FetchCounter(key string, options ...map[string]uint32) (obj *RD
 }
 
 func (b *Bucket) FetchSet(key string, options ...map[string]uint32) (obj *RDtSet, err error) {
-	o, err := b.fetch(key, options...)
+	return b.FetchSetContext(context.Background(), key, options...)
+}
+
+// FetchSetContext is the same as FetchSet, but the request is aborted when the context is done
+func (b *Bucket) FetchSetContext(ctx context.Context, key string, options ...map[string]uint32) (obj *RDtSet, err error) {
+	o, err := b.fetch(ctx, key, options...)
 	if o != nil {
 		obj = o.(*RDtSet)
 	}
@@ -41,14 +52,33 @@ func (b *Bucket) FetchSet(key string, options ...map[string]uint32) (obj *RDtSet
 }
 
 func (b *Bucket) FetchMap(key string, options ...map[string]uint32) (obj *RDtMap, err error) {
-	o, err := b.fetch(key, options...)
+	return b.FetchMapContext(context.Background(), key, options...)
+}
+
+// FetchMapContext is the same as FetchMap, but the request is aborted when the context is done
+func (b *Bucket) FetchMapContext(ctx context.Context, key string, options ...map[string]uint32) (obj *RDtMap, err error) {
+	o, err := b.fetch(ctx, key, options...)
 	if o != nil {
 		obj = o.(*RDtMap)
 	}
 	return
 }
 
-func (b *Bucket) fetch(key string, options ...map[string]uint32) (obj RDataType, err error) {
+func (b *Bucket) fetch(ctx context.Context, key string, options ...map[string]uint32) (obj RDataType, err error) {
+	req, err := b.fetchRequest(key, options)
+	if err != nil {
+		return nil, err
+	}
+	resp := &pb.DtFetchResp{}
+	err = b.client.do(ctx, true, req, dtFetchReq, resp)
+	if err != nil {
+		return nil, err
+	}
+	return b.fetchResult(key, options, resp)
+}
+
+// Build the request to fetch a data type
+func (b *Bucket) fetchRequest(key string, options []map[string]uint32) (*pb.DtFetchReq, error) {
 	t := true
 	req := &pb.DtFetchReq{
 		Type:       []byte(b.bucket_type),
@@ -56,28 +86,16 @@ func (b *Bucket) fetch(key string, options ...map[string]uint32) (obj RDataType,
 		Key:        []byte(key),
 		NotfoundOk: &t,
 	}
-	for _, omap := range options {
-		for k, v := range omap {
-			switch k {
-			case "r":
-				req.R = &v
-			case "pr":
-				req.Pr = &v
-			case "include_context":
-				include_context := v == 1
-				req.IncludeContext = &include_context
-			}
-		}
-	}
-	err, conn := b.client.request(req, dtFetchReq)
-	if err != nil {
-		return nil, err
-	}
-	resp := &pb.DtFetchResp{}
-	err = b.client.response(conn, resp)
+	opts, err := parseOptions("Fetch", fetchOptionKeys, options)
 	if err != nil {
 		return nil, err
 	}
+	opts.applyFetch(req)
+	return req, nil
+}
+
+// Create the data type from the response to a fetch
+func (b *Bucket) fetchResult(key string, options []map[string]uint32, resp *pb.DtFetchResp) (obj RDataType, err error) {
 	// Create a new object (even if only for storing the returned Vclock)
 
 	switch *resp.Type {
@@ -109,7 +127,7 @@ func (b *Bucket) fetch(key string, options ...map[string]uint32) (obj RDataType,
 	return obj, nil
 }
 
-func (m RDataTypeObject) store(op *pb.DtOp) (err error) {
+func (m RDataTypeObject) store(ctx context.Context, op *pb.DtOp) (err error) {
 	req := &pb.DtUpdateReq{
 		Type:    []byte(m.Bucket.bucket_type),
 		Bucket:  []byte(m.Bucket.name),
@@ -119,27 +137,15 @@ func (m RDataTypeObject) store(op *pb.DtOp) (err error) {
 	}
 
 	// Add the options
-	for _, omap := range m.Options {
-		for k, v := range omap {
-			switch k {
-			case "w":
-				req.W = &v
-			case "dw":
-				req.Dw = &v
-			case "pw":
-				req.Pw = &v
-			}
-		}
-	}
-
-	// Send the request
-	err, conn := m.Bucket.client.request(req, dtUpdateReq)
+	opts, err := keptOptions("Store", updateOptionKeys, m.Options, dataTypeOperations...)
 	if err != nil {
 		return err
 	}
-	// Get response, ReturnHead is true, so we can store the vclock
+	opts.applyUpdate(req)
+
+	// Send the request and get the response
 	resp := &pb.DtUpdateResp{}
-	err = m.Bucket.client.response(conn, resp)
+	err = m.Bucket.client.do(ctx, idempotentOp(op), req, dtUpdateReq, resp)
 	if err != nil {
 		return err
 	}
@@ -147,35 +153,23 @@ func (m RDataTypeObject) store(op *pb.DtOp) (err error) {
 }
 
 func (obj *RDataTypeObject) Destroy() (err error) {
+	return obj.DestroyContext(context.Background())
+}
+
+// DestroyContext is the same as Destroy, but the request is aborted when the context is done
+func (obj *RDataTypeObject) DestroyContext(ctx context.Context) (err error) {
 	req := &pb.RpbDelReq{
 		Type:   []byte(obj.Bucket.bucket_type),
 		Bucket: []byte(obj.Bucket.name),
 		Key:    []byte(obj.Key),
 	}
-	for _, omap := range obj.Options {
-		for k, v := range omap {
-			switch k {
-			case "r":
-				req.R = &v
-			case "pr":
-				req.Pr = &v
-			case "rw":
-				req.Rw = &v
-			case "w":
-				req.W = &v
-			case "dw":
-				req.Dw = &v
-			case "pw":
-				req.Pw = &v
-			}
-		}
-	}
-
-	err, conn := obj.Bucket.client.request(req, rpbDelReq)
+	opts, err := keptOptions("Destroy", delOptionKeys, obj.Options, dataTypeOperations...)
 	if err != nil {
 		return err
 	}
-	err = obj.Bucket.client.response(conn, req)
+	opts.applyDel(req)
+
+	err = obj.Bucket.client.do(ctx, false, req, rpbDelReq, req)
 	if err != nil {
 		return err
 	}
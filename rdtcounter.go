@@ -1,6 +1,8 @@
 package riak
 
 import (
+	"context"
+
 	"github.com/tpjg/goriakpbc/pb"
 )
 
@@ -33,10 +35,15 @@ func (counter *RDtCounter) ToOp() *pb.DtOp {
 }
 
 func (counter *RDtCounter) Store() (err error) {
+	return counter.StoreContext(context.Background())
+}
+
+// Store the changes, the request is aborted when the context is done
+func (counter *RDtCounter) StoreContext(ctx context.Context) (err error) {
 	op := counter.ToOp()
 	if op == nil {
 		// nothing to do
 		return nil
 	}
-	return counter.RDataTypeObject.store(op)
+	return counter.RDataTypeObject.store(ctx, op)
 }
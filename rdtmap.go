@@ -1,7 +1,9 @@
 package riak
 
 import (
+	"context"
 	"fmt"
+
 	"github.com/tpjg/goriakpbc/pb"
 )
 
@@ -331,10 +333,15 @@ func (m *RDtMap) ToOp() *pb.DtOp {
 }
 
 func (m *RDtMap) Store() (err error) {
+	return m.StoreContext(context.Background())
+}
+
+// Store the changes, the request is aborted when the context is done
+func (m *RDtMap) StoreContext(ctx context.Context) (err error) {
 	op := m.ToOp()
 	if op == nil {
 		// nothing to do
 		return nil
 	}
-	return m.RDataTypeObject.store(op)
+	return m.RDataTypeObject.store(ctx, op)
 }
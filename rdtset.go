@@ -2,6 +2,7 @@ package riak
 
 import (
 	"bytes"
+	"context"
 
 	"github.com/tpjg/goriakpbc/pb"
 )
@@ -51,10 +52,15 @@ func (set *RDtSet) ToOp() *pb.DtOp {
 }
 
 func (set *RDtSet) Store() (err error) {
+	return set.StoreContext(context.Background())
+}
+
+// Store the changes, the request is aborted when the context is done
+func (set *RDtSet) StoreContext(ctx context.Context) (err error) {
 	op := set.ToOp()
 	if op == nil {
 		// nothing to do
 		return nil
 	}
-	return set.RDataTypeObject.store(op)
+	return set.RDataTypeObject.store(ctx, op)
 }
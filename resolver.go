@@ -0,0 +1,278 @@
+package riak
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+)
+
+/*
+A ConflictResolver merges the siblings of an object into a single value. When
+a resolver is set for a bucket (or for the client) Get, Reload, GetAsync and
+Update resolve the siblings automatically, so the object has a single value
+with the vclock of the siblings; storing it replaces the siblings in Riak.
+
+The built-in resolvers are LastModifiedWins, LargestVtag and JSONSetUnion, a
+function can be used with ResolverFunc. Wrap a resolver with WriteBack to
+store the resolved value right away.
+*/
+type ConflictResolver interface {
+	Resolve(siblings []Sibling) (Sibling, error)
+}
+
+// A function that resolves siblings, it implements ConflictResolver
+type ResolverFunc func(siblings []Sibling) (Sibling, error)
+
+func (f ResolverFunc) Resolve(siblings []Sibling) (Sibling, error) {
+	return f(siblings)
+}
+
+// Built-in resolvers
+var (
+	// The sibling that was modified last wins
+	LastModifiedWins ConflictResolver = ResolverFunc(lastModifiedWins)
+	// The sibling with the largest vtag wins, an arbitrary but consistent choice
+	LargestVtag ConflictResolver = ResolverFunc(largestVtag)
+	// The JSON values of the siblings are merged: arrays are merged as sets
+	// and objects key by key, for other values the last modified sibling wins
+	JSONSetUnion ConflictResolver = ResolverFunc(jsonSetUnion)
+)
+
+// Error definitions
+var (
+	Unresolved = classified("Object has siblings and there is no ConflictResolver", SiblingConflict)
+)
+
+// A resolver that stores the resolved value
+type writeBack struct {
+	ConflictResolver
+}
+
+/*
+Return a resolver that resolves the siblings with the given resolver and
+stores the resolved value when an object is fetched with Get or Reload. The
+value is stored only if the object was not modified in the meantime, if it
+was the siblings are resolved again the next time.
+*/
+func WriteBack(resolver ConflictResolver) ConflictResolver {
+	return writeBack{resolver}
+}
+
+type bucketName struct {
+	btype string
+	name  string
+}
+
+// Set the resolver that is used for objects in this bucket with siblings,
+// it takes precedence over the resolvers set on the client
+func (b *Bucket) SetConflictResolver(resolver ConflictResolver) {
+	b.resolver = resolver
+}
+
+// Return the resolver of the bucket, the resolver set for the bucket on the
+// client or the default resolver of the client. Returns nil if there is none.
+func (b *Bucket) ConflictResolver() ConflictResolver {
+	if b.resolver != nil {
+		return b.resolver
+	}
+	if r, ok := b.client.bucketResolvers.Load(bucketName{b.bucket_type, b.name}); ok {
+		return r.(ConflictResolver)
+	}
+	return b.client.resolver
+}
+
+// Set the default resolver for objects with siblings, nil (the default)
+// leaves the siblings to the application
+func (c *Client) SetConflictResolver(resolver ConflictResolver) {
+	c.resolver = resolver
+}
+
+// Set the resolver for the objects in a bucket, for all Bucket values of the
+// bucket, nil removes it. An empty btype is the default type.
+func (c *Client) SetBucketConflictResolver(btype string, bucket string, resolver ConflictResolver) {
+	if btype == "" {
+		btype = "default"
+	}
+	if resolver == nil {
+		c.bucketResolvers.Delete(bucketName{btype, bucket})
+	} else {
+		c.bucketResolvers.Store(bucketName{btype, bucket}, resolver)
+	}
+}
+
+/*
+Resolve the siblings of the object with the resolver of its bucket, the
+resolved value replaces the siblings. The vclock is kept, so storing the
+object replaces the siblings in Riak as well. Returns Unresolved if the
+bucket has no resolver.
+*/
+func (obj *RObject) Resolve() error {
+	if !obj.conflict {
+		return nil
+	}
+	resolver := obj.Bucket.ConflictResolver()
+	if resolver == nil {
+		return Unresolved
+	}
+	resolved, err := resolver.Resolve(obj.Siblings)
+	if err != nil {
+		return err
+	}
+	obj.setSibling(resolved)
+	return nil
+}
+
+// Resolve the siblings of a fetched object if the bucket has a resolver, and
+// store the resolved value if the resolver is wrapped with WriteBack and
+// store is set. A deleted value that wins is not stored, that would make it
+// an empty value.
+func (obj *RObject) resolveSiblings(ctx context.Context, store bool) error {
+	resolver := obj.Bucket.ConflictResolver()
+	if !obj.conflict || resolver == nil {
+		return nil
+	}
+	resolved, err := resolver.Resolve(obj.Siblings)
+	if err != nil {
+		return err
+	}
+	obj.setSibling(resolved)
+	if _, ok := resolver.(writeBack); !ok || !store || resolved.Deleted {
+		return nil
+	}
+	err = obj.store(ctx, IfNotModified(true))
+	if errors.Is(err, PreconditionFailed) || errors.Is(err, NotFound) {
+		return nil
+	}
+	return err
+}
+
+// Replace the value and the siblings of the object with a single sibling
+func (obj *RObject) setSibling(s Sibling) {
+	obj.conflict = false
+	obj.Siblings = nil
+	obj.ContentType = s.ContentType
+	obj.ContentEncoding = s.ContentEncoding
+	obj.Charset = s.Charset
+	obj.Data = s.Data
+	obj.Links = s.Links
+	obj.Meta = s.Meta
+	obj.Indexes = s.Indexes
+	obj.Vtag = s.Vtag
+	obj.LastMod = s.LastMod
+	obj.LastModUsecs = s.LastModUsecs
+	if obj.Links == nil {
+		obj.Links = make([]Link, 0)
+	}
+	if obj.Meta == nil {
+		obj.Meta = make(map[string]string)
+	}
+	if obj.Indexes == nil {
+		obj.Indexes = make(map[string][]string)
+	}
+}
+
+// Returns true if sibling a was modified before b, the vtag breaks ties
+func modifiedBefore(a, b *Sibling) bool {
+	if a.LastMod != b.LastMod {
+		return a.LastMod < b.LastMod
+	}
+	if a.LastModUsecs != b.LastModUsecs {
+		return a.LastModUsecs < b.LastModUsecs
+	}
+	return a.Vtag < b.Vtag
+}
+
+func lastModifiedWins(siblings []Sibling) (Sibling, error) {
+	last := 0
+	for i := range siblings {
+		if modifiedBefore(&siblings[last], &siblings[i]) {
+			last = i
+		}
+	}
+	return siblings[last], nil
+}
+
+func largestVtag(siblings []Sibling) (Sibling, error) {
+	largest := 0
+	for i := range siblings {
+		if siblings[i].Vtag > siblings[largest].Vtag {
+			largest = i
+		}
+	}
+	return siblings[largest], nil
+}
+
+func jsonSetUnion(siblings []Sibling) (Sibling, error) {
+	sorted := make([]Sibling, len(siblings))
+	copy(sorted, siblings)
+	sort.SliceStable(sorted, func(i, j int) bool { return modifiedBefore(&sorted[i], &sorted[j]) })
+	var merged interface{}
+	found := false
+	for _, s := range sorted {
+		// Skip deleted siblings
+		if s.Deleted || len(s.Data) == 0 {
+			continue
+		}
+		decoder := json.NewDecoder(bytes.NewReader(s.Data))
+		decoder.UseNumber()
+		var value interface{}
+		if err := decoder.Decode(&value); err != nil {
+			return Sibling{}, err
+		}
+		if found {
+			merged = jsonUnion(merged, value)
+		} else {
+			merged, found = value, true
+		}
+	}
+	// The metadata of the last modified sibling is kept
+	resolved := sorted[len(sorted)-1]
+	if !found {
+		return resolved, nil
+	}
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return Sibling{}, err
+	}
+	resolved.Data = data
+	return resolved, nil
+}
+
+// Merge two JSON values, b is the newer value
+func jsonUnion(a, b interface{}) interface{} {
+	switch av := a.(type) {
+	case []interface{}:
+		if bv, ok := b.([]interface{}); ok {
+			seen := make(map[string]bool)
+			var merged []interface{}
+			for _, values := range [][]interface{}{av, bv} {
+				for _, v := range values {
+					key, _ := json.Marshal(v)
+					if !seen[string(key)] {
+						seen[string(key)] = true
+						merged = append(merged, v)
+					}
+				}
+			}
+			return merged
+		}
+	case map[string]interface{}:
+		if bv, ok := b.(map[string]interface{}); ok {
+			merged := make(map[string]interface{}, len(av))
+			for k, v := range av {
+				merged[k] = v
+			}
+			for k, v := range bv {
+				if old, ok := merged[k]; ok {
+					merged[k] = jsonUnion(old, v)
+				} else {
+					merged[k] = v
+				}
+			}
+			return merged
+		}
+	}
+	return b
+}
@@ -0,0 +1,171 @@
+package riak
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/bmizerany/assert"
+	"github.com/golang/protobuf/proto"
+	"github.com/tpjg/goriakpbc/riaktest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestBuiltinResolvers(t *testing.T) {
+	siblings := []Sibling{
+		{Data: []byte(`{"tags":["a","b"],"name":"old","n":1}`), Vtag: "c", LastMod: 10, LastModUsecs: 5},
+		{Data: []byte(`{"tags":["b","c"],"name":"new","x":{"y":[1]}}`), Vtag: "a", LastMod: 10, LastModUsecs: 7},
+		{Data: []byte(`{"tags":["d"],"name":"oldest","x":{"y":[2]}}`), Vtag: "b", LastMod: 9, LastModUsecs: 9},
+		{Data: []byte{}, Vtag: "d", LastMod: 8},
+	}
+	s, err := LastModifiedWins.Resolve(siblings)
+	assert.T(t, err == nil)
+	assert.T(t, s.Vtag == "a")
+	s, err = LargestVtag.Resolve(siblings)
+	assert.T(t, err == nil)
+	assert.T(t, s.Vtag == "d")
+
+	s, err = JSONSetUnion.Resolve(siblings)
+	assert.T(t, err == nil)
+	assert.T(t, s.Vtag == "a")
+	var merged struct {
+		Tags []string
+		Name string
+		N    int
+		X    struct{ Y []int }
+	}
+	assert.T(t, json.Unmarshal(s.Data, &merged) == nil)
+	assert.Equal(t, merged.Tags, []string{"d", "a", "b", "c"})
+	assert.T(t, merged.Name == "new")
+	assert.T(t, merged.N == 1)
+	assert.Equal(t, merged.X.Y, []int{2, 1})
+
+	_, err = JSONSetUnion.Resolve([]Sibling{{Data: []byte("not json")}})
+	assert.T(t, err != nil)
+}
+
+func TestResolveOnGet(t *testing.T) {
+	client := setupConnection(t)
+	defer client.Close()
+	bucket, err := client.NewBucket("resolver_test.go")
+	assert.T(t, err == nil)
+	assert.T(t, bucket.SetAllowMult(true) == nil)
+	store := func(data ...string) {
+		for _, d := range data {
+			obj := bucket.NewObject("key")
+			obj.ContentType = "application/json"
+			obj.Data = []byte(d)
+			assert.T(t, obj.Store() == nil)
+		}
+	}
+	store(`["a"]`, `["b"]`)
+
+	// Without a resolver the siblings are returned
+	obj, err := bucket.Get("key")
+	assert.T(t, err == nil)
+	assert.T(t, obj.Conflict())
+	assert.T(t, obj.Resolve() == Unresolved)
+
+	// The resolver set on the client for the bucket is used by all Bucket
+	// values, an empty bucket type is the default type
+	client.SetBucketConflictResolver("", "resolver_test.go", JSONSetUnion)
+	obj, err = bucket.Get("key")
+	assert.T(t, err == nil)
+	assert.T(t, !obj.Conflict())
+	assert.T(t, string(obj.Data) == `["a","b"]`)
+	obj, err = client.GetFrom("resolver_test.go", "key")
+	assert.T(t, err == nil)
+	assert.T(t, !obj.Conflict())
+	obj, err = bucket.GetAsync("key").Object()
+	assert.T(t, err == nil)
+	assert.T(t, !obj.Conflict())
+	// Head does not resolve, there is no data
+	obj, err = bucket.Head("key")
+	assert.T(t, err == nil)
+	assert.T(t, obj.Conflict())
+	obj, err = bucket.Get("key", Head(true))
+	assert.T(t, err == nil)
+	assert.T(t, obj.Conflict())
+
+	// The resolver of the bucket takes precedence, the client default is used
+	// for other buckets
+	client.SetBucketConflictResolver("", "resolver_test.go", nil)
+	client.SetConflictResolver(LastModifiedWins)
+	obj, err = bucket.Get("key")
+	assert.T(t, err == nil)
+	assert.T(t, string(obj.Data) == `["b"]`)
+	fail := errors.New("fail")
+	bucket.SetConflictResolver(ResolverFunc(func(siblings []Sibling) (Sibling, error) {
+		return Sibling{}, fail
+	}))
+	_, err = bucket.Get("key")
+	assert.T(t, err == fail)
+
+	// Reload resolves as well
+	bucket.SetConflictResolver(nil)
+	client.SetConflictResolver(nil)
+	obj, err = bucket.Get("key")
+	assert.T(t, err == nil)
+	store(`["c"]`)
+	bucket.SetConflictResolver(JSONSetUnion)
+	assert.T(t, obj.Reload() == nil)
+	assert.T(t, !obj.Conflict())
+	assert.T(t, string(obj.Data) == `["a","b","c"]`)
+
+	// The siblings are replaced in Riak with WriteBack
+	bucket.SetConflictResolver(WriteBack(JSONSetUnion))
+	// Head does not write back, there is no data to store
+	obj, err = bucket.Get("key", Head(true))
+	assert.T(t, err == nil)
+	assert.T(t, obj.Conflict())
+	bucket.SetConflictResolver(nil)
+	obj, err = bucket.Get("key")
+	assert.T(t, err == nil)
+	assert.T(t, obj.Conflict())
+	bucket.SetConflictResolver(WriteBack(JSONSetUnion))
+	obj, err = bucket.Get("key")
+	assert.T(t, err == nil)
+	assert.T(t, string(obj.Data) == `["a","b","c"]`)
+	bucket.SetConflictResolver(nil)
+	obj, err = bucket.Get("key")
+	assert.T(t, err == nil)
+	assert.T(t, !obj.Conflict())
+	assert.T(t, string(obj.Data) == `["a","b","c"]`)
+}
+
+func TestResolveTombstone(t *testing.T) {
+	server, client, done := setupServer(t, 1)
+	defer done()
+	var puts int32
+	server.SetFault(func(code byte, req proto.Message) error {
+		if code == riaktest.PutReq {
+			atomic.AddInt32(&puts, 1)
+		}
+		return nil
+	})
+
+	// A deleted value that wins is not written back as an empty value
+	bucket, err := client.Bucket("resolver_test.go")
+	assert.T(t, err == nil)
+	bucket.SetConflictResolver(WriteBack(LastModifiedWins))
+	obj := bucket.NewObject("key")
+	obj.conflict = true
+	obj.Siblings = []Sibling{
+		{Data: []byte("live"), LastMod: 1},
+		{LastMod: 2, Deleted: true},
+	}
+	assert.T(t, obj.resolveSiblings(context.Background(), true) == nil)
+	assert.T(t, !obj.Conflict())
+	assert.T(t, len(obj.Data) == 0)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&puts))
+
+	// A live value that wins is
+	obj.conflict = true
+	obj.Siblings = []Sibling{
+		{Data: []byte("live"), LastMod: 3},
+		{LastMod: 2, Deleted: true},
+	}
+	assert.T(t, obj.resolveSiblings(context.Background(), true) == nil)
+	assert.T(t, string(obj.Data) == "live")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&puts))
+}
@@ -0,0 +1,181 @@
+package riak
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/tpjg/goriakpbc/pb"
+)
+
+/*
+A RetryPolicy retries requests that failed with a transient error, like a
+broken connection, a timeout or an overloaded node. Requests that only read
+data (Get, Head, Reload, index queries, listing keys, data type fetches and
+search) are always retried. Writes are only retried when they are idempotent:
+conditional stores (StoreIfNotExists, StoreIfUnmodified and the Model
+equivalents), data type updates that only add set elements or update registers
+and flags, and any request made with a context marked with Idempotent.
+
+	client.SetRetryPolicy(&riak.RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     50 * time.Millisecond,
+		MaxBackoff:  time.Second,
+		Jitter:      0.5,
+	})
+
+When a request was retried and still failed, the error is a *RetryError with
+the number of attempts.
+*/
+type RetryPolicy struct {
+	MaxAttempts int                  // Including the first attempt, 1 or less disables retries
+	Backoff     time.Duration        // Wait before the first retry, doubled for every next retry
+	MaxBackoff  time.Duration        // Maximum wait between retries, zero for a minute
+	Jitter      float64              // Fraction of the wait that is random, between 0 and 1
+	Retryable   func(err error) bool // Errors that are retried, nil uses IsTransient
+}
+
+// A sensible retry policy for most applications
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 3, Backoff: 50 * time.Millisecond, MaxBackoff: time.Second, Jitter: 0.5}
+
+// RetryError is returned when a request still failed after it was retried
+type RetryError struct {
+	Attempts int
+	Err      error
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("%v (after %d attempts)", e.Err, e.Attempts)
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// Set the retry policy of the client, nil (the default) disables retries
+func (c *Client) SetRetryPolicy(policy *RetryPolicy) {
+	c.retryPolicy = policy
+}
+
+type idempotentKey struct{}
+
+// Return a context that marks the requests made with it as idempotent, so
+// writes are retried as well, for example:
+//
+//	err := bucket.DeleteContext(riak.Idempotent(ctx), "key")
+func Idempotent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotentKey{}, true)
+}
+
+func isIdempotent(ctx context.Context) bool {
+	idempotent, _ := ctx.Value(idempotentKey{}).(bool)
+	return idempotent
+}
+
+// Returns true for errors that are likely to go away when the request is
+// retried: network errors, request timeouts and overload errors from Riak.
+// Errors caused by the request context are not transient.
+func IsTransient(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var nerr *NetworkError
+	return errors.As(err, &nerr) || errors.Is(err, Overload) || errors.Is(err, RequestTimeout)
+}
+
+func (p *RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return IsTransient(err)
+}
+
+// The maximum wait between retries of a policy without MaxBackoff, doubling
+// the wait without a maximum would overflow
+const maxRetryBackoff = time.Minute
+
+// Returns the time to wait before the next attempt
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	max := p.MaxBackoff
+	if max <= 0 {
+		max = maxRetryBackoff
+	}
+	d := p.Backoff
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	if p.Jitter > 0 {
+		d -= time.Duration(rand.Float64() * p.Jitter * float64(d))
+	}
+	return d
+}
+
+// Call fn and retry it as allowed by the retry policy, idempotent is set for
+// requests that can always be retried.
+func (c *Client) retry(ctx context.Context, idempotent bool, fn func() error) error {
+	err := fn()
+	policy := c.retryPolicy
+	if err == nil || policy == nil || !(idempotent || isIdempotent(ctx)) {
+		return err
+	}
+	attempts := 1
+	for err != nil && attempts < policy.MaxAttempts && policy.retryable(err) {
+		timer := time.NewTimer(policy.backoff(attempts))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return &RetryError{Attempts: attempts, Err: err}
+		}
+		attempts++
+		err = fn()
+	}
+	if err != nil && attempts > 1 {
+		return &RetryError{Attempts: attempts, Err: err}
+	}
+	return err
+}
+
+// Send a request and read the response, retrying as allowed by the retry policy
+func (c *Client) do(ctx context.Context, idempotent bool, req proto.Message, code byte, resp proto.Message) error {
+	return c.retry(ctx, idempotent, func() error {
+		err, conn := c.requestContext(ctx, req, code)
+		if err != nil {
+			return err
+		}
+		return c.response(conn, resp)
+	})
+}
+
+// Returns true if applying a data type operation twice has the same result
+// as applying it once: counter increments and removals are not idempotent.
+func idempotentOp(op *pb.DtOp) bool {
+	if op == nil {
+		return true
+	}
+	if op.CounterOp != nil || op.SetOp != nil && len(op.SetOp.Removes) > 0 {
+		return false
+	}
+	return op.MapOp == nil || idempotentMapOp(op.MapOp)
+}
+
+func idempotentMapOp(op *pb.MapOp) bool {
+	if len(op.Removes) > 0 {
+		return false
+	}
+	for _, up := range op.Updates {
+		if up.CounterOp != nil || up.SetOp != nil && len(up.SetOp.Removes) > 0 {
+			return false
+		}
+		if up.MapOp != nil && !idempotentMapOp(up.MapOp) {
+			return false
+		}
+	}
+	return true
+}
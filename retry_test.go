@@ -0,0 +1,133 @@
+package riak
+
+import (
+	"context"
+	"errors"
+	"github.com/bmizerany/assert"
+	"github.com/golang/protobuf/proto"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tpjg/goriakpbc/pb"
+	"github.com/tpjg/goriakpbc/riaktest"
+)
+
+// Return a fault that fails the first n requests with the given code
+func failFirst(code byte, n int32, err error, count *int32) riaktest.Fault {
+	return func(c byte, req proto.Message) error {
+		if c == code && atomic.AddInt32(count, 1) <= n {
+			return err
+		}
+		return nil
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	server, client, done := setupServer(t, 1)
+	defer done()
+	client.SetRetryPolicy(&RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond, Jitter: 0.5})
+	bucket, err := client.NewBucket("retry_test.go")
+	assert.T(t, err == nil)
+	obj := bucket.NewObject("key")
+	obj.ContentType = "text/plain"
+	obj.Data = []byte("data")
+	assert.T(t, obj.Store() == nil)
+
+	// Reads are retried, on error responses and on network errors
+	var count int32
+	server.SetFault(failFirst(riaktest.GetReq, 2, errors.New("overload"), &count))
+	_, err = bucket.Get("key")
+	assert.T(t, err == nil)
+	assert.T(t, count == 3)
+	count = 0
+	server.SetFault(failFirst(riaktest.GetReq, 1, riaktest.CloseConnection, &count))
+	_, err = bucket.Head("key")
+	assert.T(t, err == nil)
+	assert.T(t, count == 2)
+
+	// The number of attempts is reported when all attempts fail
+	count = 0
+	server.SetFault(failFirst(riaktest.IndexReq, 5, errors.New("timeout"), &count))
+	_, err = bucket.IndexQuery("test_bin", "a")
+	var rerr *RetryError
+	assert.T(t, errors.As(err, &rerr))
+	assert.T(t, rerr.Attempts == 3)
+	assert.T(t, errors.Is(err, RequestTimeout))
+	assert.T(t, count == 3)
+
+	// Writes are not retried, unless they are idempotent
+	count = 0
+	server.SetFault(failFirst(riaktest.PutReq, 1, errors.New("overload"), &count))
+	err = obj.Store()
+	assert.T(t, errors.Is(err, Overload))
+	assert.T(t, !errors.As(err, &rerr))
+	assert.T(t, count == 1)
+	count = 0
+	err = obj.StoreIfUnmodified()
+	assert.T(t, err == nil)
+	assert.T(t, count == 2)
+	count = 0
+	server.SetFault(failFirst(riaktest.DelReq, 1, errors.New("overload"), &count))
+	err = bucket.DeleteContext(Idempotent(context.Background()), "key")
+	assert.T(t, err == nil)
+	assert.T(t, count == 2)
+
+	// Other errors are not retried
+	count = 0
+	server.SetFault(failFirst(riaktest.GetReq, 1, errors.New("bad request"), &count))
+	_, err = bucket.Get("key")
+	assert.T(t, err != nil && err.Error() == "bad request")
+	assert.T(t, count == 1)
+
+	// Without a policy nothing is retried
+	client.SetRetryPolicy(nil)
+	count = 0
+	server.SetFault(failFirst(riaktest.GetReq, 1, errors.New("overload"), &count))
+	_, err = bucket.Get("key")
+	assert.T(t, errors.Is(err, Overload))
+}
+
+func TestRetryBackoff(t *testing.T) {
+	p := &RetryPolicy{Backoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	assert.T(t, p.backoff(1) == 10*time.Millisecond)
+	assert.T(t, p.backoff(2) == 20*time.Millisecond)
+	assert.T(t, p.backoff(4) == 50*time.Millisecond)
+	assert.T(t, p.backoff(100) == 50*time.Millisecond)
+	// Without a maximum the wait does not overflow
+	p.MaxBackoff = 0
+	assert.T(t, p.backoff(2) == 20*time.Millisecond)
+	assert.T(t, p.backoff(100) == maxRetryBackoff)
+	p.MaxBackoff = 50 * time.Millisecond
+	p.Jitter = 0.5
+	for i := 0; i < 10; i++ {
+		d := p.backoff(2)
+		assert.T(t, d > 10*time.Millisecond && d <= 20*time.Millisecond)
+	}
+
+	assert.T(t, IsTransient(&NetworkError{Op: "Get", Err: errors.New("reset")}))
+	assert.T(t, IsTransient(&RiakError{Message: "overload"}))
+	assert.T(t, !IsTransient(NotFound))
+	assert.T(t, !IsTransient(context.Canceled))
+}
+
+func TestIdempotentOp(t *testing.T) {
+	set := &RDtSet{}
+	set.Add([]byte("a"))
+	assert.T(t, idempotentOp(set.ToOp()))
+	set.Remove([]byte("b"))
+	assert.T(t, !idempotentOp(set.ToOp()))
+
+	counter := &RDtCounter{}
+	counter.Increment(1)
+	assert.T(t, !idempotentOp(counter.ToOp()))
+
+	m := &RDtMap{}
+	m.Init(nil)
+	m.AddRegister("name").Update([]byte("value"))
+	m.AddFlag("flag").Enable()
+	assert.T(t, idempotentOp(m.ToOp()))
+	m.AddMap("nested").AddCounter("count").Increment(1)
+	assert.T(t, !idempotentOp(m.ToOp()))
+	assert.T(t, idempotentOp(&pb.DtOp{}))
+}
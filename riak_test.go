@@ -1,19 +1,48 @@
 package riak
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/bmizerany/assert"
+	"io"
+	"net"
+	"os"
 	"strconv"
 	"strings"
 	"testing"
 	"time"
-)
 
-const (
-	riakhost = "127.0.0.1:8087"
+	"github.com/tpjg/goriakpbc/riaktest"
 )
 
+// The tests run against an in-memory riaktest server, unless RIAK_HOST is set
+// to the address of a real Riak node, e.g. RIAK_HOST=127.0.0.1:8087.
+var riakhost = os.Getenv("RIAK_HOST")
+
+func TestMain(m *testing.M) {
+	if riakhost != "" {
+		os.Exit(m.Run())
+	}
+	server, err := riaktest.NewServer()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	riakhost = server.Addr()
+	code := m.Run()
+	server.Close()
+	os.Exit(code)
+}
+
+// Skip tests that need features riaktest does not have, like MapReduce
+func needsRiak(t *testing.T) {
+	if os.Getenv("RIAK_HOST") == "" {
+		t.Skip("needs a Riak node, set RIAK_HOST to run")
+	}
+}
+
 func setupConnection(t *testing.T) (client *Client) {
 	client = New(riakhost)
 	err := client.Connect()
@@ -32,6 +61,24 @@ func setupConnections(t *testing.T, count int) (client *Client) {
 	return client
 }
 
+// Start a private riaktest server for a test that injects faults with
+// SetFault (or needs an empty server), so other tests are not affected. The
+// client has a pool of count connections, done closes the client and the
+// server.
+func setupServer(t *testing.T, count int) (server *riaktest.Server, client *Client, done func()) {
+	server, err := riaktest.NewServer()
+	assert.T(t, err == nil)
+	client = NewClientPool(server.Addr(), count)
+	if err = client.Connect(); err != nil {
+		server.Close()
+	}
+	assert.T(t, err == nil)
+	return server, client, func() {
+		client.Close()
+		server.Close()
+	}
+}
+
 func TestCanConnect(t *testing.T) {
 	client := setupConnection(t)
 	assert.T(t, client != nil)
@@ -98,6 +145,50 @@ func TestStoreObject(t *testing.T) {
 	*/
 }
 
+func TestConditionalStore(t *testing.T) {
+	client := setupConnection(t)
+	assert.T(t, client != nil)
+
+	bucket, _ := client.Bucket("client_test.go")
+	bucket.Delete("conditional")
+	obj := bucket.New("conditional")
+	obj.ContentType = "text/plain"
+	obj.Data = []byte("first")
+	assert.T(t, obj.StoreIfNotExists() == nil)
+
+	// A second create must fail
+	obj2 := bucket.New("conditional")
+	obj2.ContentType = "text/plain"
+	obj2.Data = []byte("second")
+	assert.T(t, obj2.StoreIfNotExists() == ObjectExists)
+
+	// Compare-and-swap succeeds with the current vclock...
+	obj.Data = []byte("updated")
+	assert.T(t, obj.StoreIfUnmodified() == nil)
+	// ...and fails with a stale one
+	stale, err := bucket.Get("conditional")
+	assert.T(t, err == nil)
+	assert.T(t, obj.Store() == nil)
+	stale.Data = []byte("stale")
+	assert.T(t, stale.StoreIfUnmodified() == ObjectModified)
+
+	// Cleanup
+	assert.T(t, obj.Destroy() == nil)
+}
+
+func TestConditionError(t *testing.T) {
+	assert.T(t, conditionError(&RiakError{Message: "match_found"}) == ObjectExists)
+	assert.T(t, conditionError(&RiakError{Message: "modified"}) == ObjectModified)
+	assert.T(t, conditionError(&RiakError{Message: "notfound"}) == NotFound)
+	assert.T(t, conditionError(BadResponseLength) == BadResponseLength)
+	// The attempts of a retried store are kept
+	err := conditionError(&RetryError{Attempts: 2, Err: &RiakError{Message: "modified"}})
+	var rerr *RetryError
+	assert.T(t, errors.As(err, &rerr))
+	assert.T(t, rerr.Attempts == 2)
+	assert.T(t, errors.Is(err, ObjectModified))
+}
+
 func TestGetAndDeleteObject(t *testing.T) {
 	client := setupConnection(t)
 	assert.T(t, client != nil)
@@ -272,6 +363,33 @@ func TestExists(t *testing.T) {
 	assert.T(t, err == nil)
 }
 
+func TestHead(t *testing.T) {
+	client := setupConnection(t)
+	assert.T(t, client != nil)
+
+	bucket, _ := client.Bucket("client_test.go")
+	obj := bucket.NewObject("head")
+	obj.ContentType = "text/plain"
+	obj.Data = []byte("some data that is not returned")
+	obj.Meta["meta"] = "value"
+	obj.Indexes["test_bin"] = []string{"head"}
+	assert.T(t, obj.Store() == nil)
+
+	head, err := bucket.Head("head")
+	assert.T(t, err == nil)
+	assert.T(t, len(head.Data) == 0)
+	assert.T(t, head.ContentType == "text/plain")
+	assert.T(t, head.Meta["meta"] == "value")
+	assert.T(t, head.Indexes["test_bin"][0] == "head")
+	assert.T(t, head.Vtag != "")
+	assert.T(t, head.LastMod != 0)
+	assert.T(t, string(head.Vclock) == string(obj.Vclock))
+
+	assert.T(t, obj.Destroy() == nil)
+	_, err = bucket.Head("head")
+	assert.T(t, err == NotFound)
+}
+
 func TestObjectLinks(t *testing.T) {
 	client := setupConnection(t)
 	assert.T(t, client != nil)
@@ -377,7 +495,7 @@ func TestObjectIndexes(t *testing.T) {
 	if err == nil {
 		t.Logf("2i query returned : %v\n", keys)
 	} else {
-		if err.Error() == "EOF" {
+		if errors.Is(err, io.EOF) {
 			fmt.Println("2i queries over protobuf is not supported, maybe running a pre 1.2 version of Riak - skipping 2i tests.")
 			return
 		} else if err.Error() == "{error,{indexes_not_supported,riak_kv_bitcask_backend}}" {
@@ -502,6 +620,7 @@ func TestBigObject(t *testing.T) {
 }
 
 func TestRunMapReduce(t *testing.T) {
+	needsRiak(t)
 	// Preparations
 	client := setupConnection(t)
 	assert.T(t, client != nil)
@@ -534,6 +653,7 @@ func TestRunMapReduce(t *testing.T) {
 }
 
 func TestMapReduce(t *testing.T) {
+	needsRiak(t)
 	// Preparations
 	client := setupConnection(t)
 	assert.T(t, client != nil)
@@ -619,6 +739,7 @@ func TestMapReduce(t *testing.T) {
 }
 
 func TestMapReduceExample(t *testing.T) {
+	needsRiak(t)
 	// Run the queries from the example on the Basho site,
 	// http://docs.basho.com/riak/1.2.1/references/appendices/MapReduce-Implementation/
 
@@ -711,6 +832,7 @@ later. Since the operations are not blocking each other the second operation
 should finish before the first (long running) operation.
 */
 func TestRunConnectionPool(t *testing.T) {
+	needsRiak(t)
 	// Skip this test if test.short is set
 	if testing.Short() {
 		t.Log("Skipping TestRunConnectionPool")
@@ -814,6 +936,23 @@ func TestBadConnection(t *testing.T) {
 	c.Close()
 }
 
+func TestReadHeader(t *testing.T) {
+	client := NewClient("127.0.0.1:8088")
+	server, c := net.Pipe()
+	defer server.Close()
+	conn := &nodeConn{Conn: c, node: client.nodes[0]}
+	go server.Write([]byte{0, 0, 0, 3, rpbGetResp, 1, 2, 0, 0, 0, 0, rpbGetResp})
+
+	code, size, err := client.readHeader(conn)
+	assert.T(t, err == nil && code == rpbGetResp && size == 2)
+	_, err = client.read(conn, size)
+	assert.T(t, err == nil)
+
+	// A message must at least have a code
+	_, _, err = client.readHeader(conn)
+	assert.T(t, err == BadResponseLength && conn.broken)
+}
+
 func TestDefaultClientNotInitialized(t *testing.T) {
 	// Check what happens with some calls if the defaultClient is not initialized
 	// Basically this just makes sure there is no panic ...
@@ -838,3 +977,55 @@ func TestDefaultClientNotInitialized(t *testing.T) {
 	assert.T(t, err != nil)
 
 }
+
+func TestContextCancel(t *testing.T) {
+	// A server that accepts connections but never answers
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.T(t, err == nil)
+	defer ln.Close()
+	accepted := make(chan net.Conn, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+	client := NewClient(ln.Addr().String())
+	assert.T(t, client.Connect() == nil)
+
+	// The deadline of the context is applied to the connection
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = client.PingContext(ctx)
+	assert.T(t, err == context.DeadlineExceeded)
+	assert.T(t, client.Nodes()[0].Up)
+
+	// Cancelling aborts a request in progress, the connection that was left
+	// mid-frame was discarded so a new connection is made.
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	err = client.PingContext(ctx)
+	assert.T(t, err == context.Canceled)
+	for i := 0; i < 2; i++ {
+		select {
+		case <-accepted:
+		case <-time.After(time.Second):
+			assert.T(t, false)
+		}
+	}
+
+	// Waiting for a connection from the pool is aborted too
+	err, conn := client.getConn(context.Background())
+	assert.T(t, err == nil)
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = client.GetFromContext(ctx, "client_test.go", "abc")
+	assert.T(t, err == context.DeadlineExceeded)
+	client.releaseConn(conn)
+}
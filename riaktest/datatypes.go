@@ -0,0 +1,312 @@
+package riaktest
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/tpjg/goriakpbc/pb"
+)
+
+// The value of a data type, kind is "counter", "set", "map", "register" or
+// "flag". Maps hold their values by field.
+type dtValue struct {
+	kind     string
+	counter  int64
+	set      map[string]bool
+	register []byte
+	flag     bool
+	fields   map[fieldKey]*dtValue
+}
+
+// Map fields are identified by name and type
+type fieldKey struct {
+	name string
+	typ  pb.MapField_MapFieldType
+}
+
+var fieldKinds = map[pb.MapField_MapFieldType]string{
+	pb.MapField_COUNTER:  "counter",
+	pb.MapField_SET:      "set",
+	pb.MapField_REGISTER: "register",
+	pb.MapField_FLAG:     "flag",
+	pb.MapField_MAP:      "map",
+}
+
+var fetchTypes = map[string]pb.DtFetchResp_DataType{
+	"counter": pb.DtFetchResp_COUNTER,
+	"set":     pb.DtFetchResp_SET,
+	"map":     pb.DtFetchResp_MAP,
+}
+
+func newDtValue(kind string) *dtValue {
+	return &dtValue{kind: kind, set: make(map[string]bool), fields: make(map[fieldKey]*dtValue)}
+}
+
+func (v *dtValue) clone() *dtValue {
+	c := newDtValue(v.kind)
+	c.counter, c.register, c.flag = v.counter, v.register, v.flag
+	for e := range v.set {
+		c.set[e] = true
+	}
+	for k, f := range v.fields {
+		c.fields[k] = f.clone()
+	}
+	return c
+}
+
+// The elements of a set in order
+func (v *dtValue) setValue() (elements [][]byte) {
+	for e := range v.set {
+		elements = append(elements, []byte(e))
+	}
+	sort.Slice(elements, func(i, j int) bool { return bytes.Compare(elements[i], elements[j]) < 0 })
+	return elements
+}
+
+// The fields of a map in order
+func (v *dtValue) mapValue() (entries []*pb.MapEntry) {
+	keys := make([]fieldKey, 0, len(v.fields))
+	for k := range v.fields {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].name != keys[j].name {
+			return keys[i].name < keys[j].name
+		}
+		return keys[i].typ < keys[j].typ
+	})
+	for _, k := range keys {
+		f := v.fields[k]
+		entry := &pb.MapEntry{Field: &pb.MapField{Name: []byte(k.name), Type: k.typ.Enum()}}
+		switch k.typ {
+		case pb.MapField_COUNTER:
+			entry.CounterValue = proto.Int64(f.counter)
+		case pb.MapField_SET:
+			entry.SetValue = f.setValue()
+		case pb.MapField_REGISTER:
+			entry.RegisterValue = f.register
+		case pb.MapField_FLAG:
+			entry.FlagValue = proto.Bool(f.flag)
+		case pb.MapField_MAP:
+			entry.MapValue = f.mapValue()
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func (v *dtValue) applyCounter(op *pb.CounterOp) {
+	v.counter += op.GetIncrement()
+}
+
+func (v *dtValue) applySet(op *pb.SetOp) error {
+	for _, e := range op.Removes {
+		if !v.set[string(e)] {
+			return fmt.Errorf("{precondition,{not_present,<<%q>>}}", e)
+		}
+		delete(v.set, string(e))
+	}
+	for _, e := range op.Adds {
+		v.set[string(e)] = true
+	}
+	return nil
+}
+
+func (v *dtValue) applyMap(op *pb.MapOp) error {
+	for _, field := range op.Removes {
+		k := fieldKey{string(field.Name), field.GetType()}
+		if v.fields[k] == nil {
+			return fmt.Errorf("{precondition,{not_present,{<<%q>>,%s}}}", field.Name, fieldKinds[k.typ])
+		}
+		delete(v.fields, k)
+	}
+	for _, up := range op.Updates {
+		k := fieldKey{string(up.Field.GetName()), up.Field.GetType()}
+		kind, ok := fieldKinds[k.typ]
+		if !ok {
+			return fmt.Errorf("Invalid map field type %v", k.typ)
+		}
+		f := v.fields[k]
+		if f == nil {
+			f = newDtValue(kind)
+		}
+		var err error
+		switch k.typ {
+		case pb.MapField_COUNTER:
+			if up.CounterOp != nil {
+				f.applyCounter(up.CounterOp)
+			}
+		case pb.MapField_SET:
+			if up.SetOp != nil {
+				err = f.applySet(up.SetOp)
+			}
+		case pb.MapField_REGISTER:
+			if up.RegisterOp != nil {
+				f.register = up.RegisterOp
+			}
+		case pb.MapField_FLAG:
+			if up.FlagOp != nil {
+				f.flag = up.GetFlagOp() == pb.MapUpdate_ENABLE
+			}
+		case pb.MapField_MAP:
+			if up.MapOp != nil {
+				err = f.applyMap(up.MapOp)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		v.fields[k] = f
+	}
+	return nil
+}
+
+// Return the data type of a bucket
+func (s *Server) datatype(typ []byte, name []byte) (string, error) {
+	props, err := s.props(typ, name)
+	if err != nil {
+		return "", err
+	}
+	kind := string(props.Datatype)
+	if _, ok := fetchTypes[kind]; !ok {
+		return "", fmt.Errorf("Bucket datatype '%s' is not a supported type", kind)
+	}
+	return kind, nil
+}
+
+func (s *Server) dtFetch(req *pb.DtFetchReq) ([]frame, error) {
+	kind, err := s.datatype(req.Type, req.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	b, _ := s.bucket(req.Type, req.Bucket, false)
+	resp := &pb.DtFetchResp{Type: fetchTypes[kind].Enum()}
+	obj := b.live(string(req.Key))
+	if obj == nil || obj.dt == nil {
+		return one(dtFetchResp, resp)
+	}
+	if req.IncludeContext == nil || req.GetIncludeContext() {
+		resp.Context = obj.vclock
+	}
+	switch kind {
+	case "counter":
+		resp.Value = &pb.DtValue{CounterValue: proto.Int64(obj.dt.counter)}
+	case "set":
+		resp.Value = &pb.DtValue{SetValue: obj.dt.setValue()}
+	case "map":
+		resp.Value = &pb.DtValue{MapValue: obj.dt.mapValue()}
+	}
+	return one(dtFetchResp, resp)
+}
+
+func (s *Server) dtUpdate(req *pb.DtUpdateReq) ([]frame, error) {
+	kind, err := s.datatype(req.Type, req.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	b, _ := s.bucket(req.Type, req.Bucket, true)
+	resp := &pb.DtUpdateResp{}
+	key := string(req.Key)
+	if key == "" {
+		key = s.newKey()
+		resp.Key = []byte(key)
+	}
+
+	// Apply the operation to a copy, so a failed operation changes nothing
+	var value *dtValue
+	if obj := b.live(key); obj != nil && obj.dt != nil {
+		value = obj.dt.clone()
+	} else {
+		value = newDtValue(kind)
+	}
+	op := req.GetOp()
+	switch {
+	case kind == "counter" && op.CounterOp != nil:
+		value.applyCounter(op.CounterOp)
+	case kind == "set" && op.SetOp != nil:
+		err = value.applySet(op.SetOp)
+	case kind == "map" && op.MapOp != nil:
+		err = value.applyMap(op.MapOp)
+	default:
+		err = fmt.Errorf("Operation type is not valid for bucket datatype '%s'", kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	obj := &object{vclock: s.vclock(), dt: value}
+	b.objects[key] = obj
+
+	if req.GetReturnBody() {
+		resp.Context = obj.vclock
+		switch kind {
+		case "counter":
+			resp.CounterValue = proto.Int64(value.counter)
+		case "set":
+			resp.SetValue = value.setValue()
+		case "map":
+			resp.MapValue = value.mapValue()
+		}
+	}
+	return one(dtUpdateResp, resp)
+}
+
+// The content type of the objects that hold a counter in a bucket without a
+// bucket type
+const counterContentType = "application/riak_counter"
+
+// Return the value of a counter object
+func counterValue(obj *object) (int64, error) {
+	if len(obj.siblings) != 1 || string(obj.siblings[0].ContentType) != counterContentType {
+		return 0, fmt.Errorf("Object is not a counter")
+	}
+	return strconv.ParseInt(string(obj.siblings[0].Value), 10, 64)
+}
+
+func (s *Server) counterUpdate(req *pb.RpbCounterUpdateReq) ([]frame, error) {
+	props, err := s.props(nil, req.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	if !props.GetAllowMult() {
+		return nil, fmt.Errorf("Counters require bucket property 'allow_mult=true'")
+	}
+	b, _ := s.bucket(nil, req.Bucket, true)
+	var value int64
+	if obj := b.live(string(req.Key)); obj != nil {
+		if value, err = counterValue(obj); err != nil {
+			return nil, err
+		}
+	}
+	value += req.GetAmount()
+	b.objects[string(req.Key)] = &object{
+		vclock: s.vclock(),
+		siblings: []*pb.RpbContent{{
+			Value:       []byte(strconv.FormatInt(value, 10)),
+			ContentType: []byte(counterContentType),
+		}},
+	}
+	resp := &pb.RpbCounterUpdateResp{}
+	if req.GetReturnvalue() {
+		resp.Value = proto.Int64(value)
+	}
+	return one(counterUpdateResp, resp)
+}
+
+func (s *Server) counterGet(req *pb.RpbCounterGetReq) ([]frame, error) {
+	b, err := s.bucket(nil, req.Bucket, false)
+	if err != nil {
+		return nil, err
+	}
+	resp := &pb.RpbCounterGetResp{}
+	if obj := b.live(string(req.Key)); obj != nil {
+		value, err := counterValue(obj)
+		if err != nil {
+			return nil, err
+		}
+		resp.Value = proto.Int64(value)
+	}
+	return one(counterGetResp, resp)
+}
@@ -0,0 +1,185 @@
+package riaktest
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/tpjg/goriakpbc/pb"
+)
+
+// A single index entry
+type entry struct {
+	term string
+	key  string
+}
+
+// Compare index terms, binary indexes are compared bytewise and integer
+// indexes numerically.
+func binOrder(a, b string) int {
+	return strings.Compare(a, b)
+}
+
+func intOrder(a, b string) int {
+	x, _ := strconv.ParseInt(a, 10, 64)
+	y, _ := strconv.ParseInt(b, 10, 64)
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// Return all entries of an index in a bucket
+func (b *bucket) entries(index string) (entries []entry) {
+	for _, key := range b.keys() {
+		obj := b.objects[key]
+		switch index {
+		case "$bucket", "$key":
+			entries = append(entries, entry{key, key})
+			continue
+		}
+		seen := make(map[string]bool)
+		for _, content := range obj.siblings {
+			for _, idx := range content.Indexes {
+				if string(idx.Key) == index && !seen[string(idx.Value)] {
+					seen[string(idx.Value)] = true
+					entries = append(entries, entry{string(idx.Value), key})
+				}
+			}
+		}
+	}
+	return entries
+}
+
+// The continuation is the last entry that was returned
+func continuation(e entry) []byte {
+	return []byte(base64.StdEncoding.EncodeToString([]byte(e.term + "\x00" + e.key)))
+}
+
+func parseContinuation(c []byte) (e entry, err error) {
+	data, err := base64.StdEncoding.DecodeString(string(c))
+	if err != nil {
+		return e, errors.New("Invalid continuation")
+	}
+	parts := strings.SplitN(string(data), "\x00", 2)
+	if len(parts) != 2 {
+		return e, errors.New("Invalid continuation")
+	}
+	return entry{parts[0], parts[1]}, nil
+}
+
+func (s *Server) index(req *pb.RpbIndexReq) ([]frame, error) {
+	b, err := s.bucket(req.Type, req.Bucket, false)
+	if err != nil {
+		return nil, err
+	}
+	index := string(req.Index)
+	order := binOrder
+	if strings.HasSuffix(index, "_int") {
+		order = intOrder
+	} else if !strings.HasSuffix(index, "_bin") && index != "$key" && index != "$bucket" {
+		return nil, fmt.Errorf("{error,{invalid_index,%q}}", index)
+	}
+
+	// Find the matching entries
+	var min, max string
+	switch req.GetQtype() {
+	case pb.RpbIndexReq_eq:
+		min, max = string(req.Key), string(req.Key)
+	case pb.RpbIndexReq_range:
+		min, max = string(req.RangeMin), string(req.RangeMax)
+	}
+	if strings.HasSuffix(index, "_int") {
+		for _, term := range []string{min, max} {
+			if _, err := strconv.ParseInt(term, 10, 64); err != nil {
+				return nil, fmt.Errorf("Invalid integer index term %q", term)
+			}
+		}
+	}
+	var re *regexp.Regexp
+	if len(req.TermRegex) > 0 {
+		if re, err = regexp.Compile(string(req.TermRegex)); err != nil {
+			return nil, err
+		}
+	}
+	var matches []entry
+	if b != nil {
+		for _, e := range b.entries(index) {
+			if index == "$bucket" || order(e.term, min) >= 0 && order(e.term, max) <= 0 {
+				if re == nil || re.MatchString(e.term) {
+					matches = append(matches, e)
+				}
+			}
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if c := order(matches[i].term, matches[j].term); c != 0 {
+			return c < 0
+		}
+		return matches[i].key < matches[j].key
+	})
+	returnTerms := req.GetReturnTerms() && req.GetQtype() == pb.RpbIndexReq_range
+	if !returnTerms {
+		// Only return each key once
+		var keys []entry
+		seen := make(map[string]bool)
+		for _, e := range matches {
+			if !seen[e.key] {
+				seen[e.key] = true
+				keys = append(keys, e)
+			}
+		}
+		matches = keys
+	}
+
+	// Skip the entries before the continuation and limit the results
+	if len(req.Continuation) > 0 {
+		last, err := parseContinuation(req.Continuation)
+		if err != nil {
+			return nil, err
+		}
+		for len(matches) > 0 {
+			c := order(matches[0].term, last.term)
+			if c > 0 || c == 0 && matches[0].key > last.key {
+				break
+			}
+			matches = matches[1:]
+		}
+	}
+	var next []byte
+	if req.MaxResults != nil && len(matches) >= int(req.GetMaxResults()) {
+		matches = matches[:req.GetMaxResults()]
+		if len(matches) > 0 {
+			next = continuation(matches[len(matches)-1])
+		}
+	}
+
+	// Create the response(s)
+	var frames []frame
+	resp := &pb.RpbIndexResp{}
+	for i, e := range matches {
+		if returnTerms {
+			resp.Results = append(resp.Results, &pb.RpbPair{Key: []byte(e.term), Value: []byte(e.key)})
+		} else {
+			resp.Keys = append(resp.Keys, []byte(e.key))
+		}
+		if req.GetStream() && (i+1)%chunkSize == 0 {
+			frames = append(frames, frame{indexResp, resp})
+			resp = &pb.RpbIndexResp{}
+		}
+	}
+	resp.Continuation = next
+	if req.GetStream() {
+		resp.Done = proto.Bool(true)
+	}
+	frames = append(frames, frame{indexResp, resp})
+	return frames, nil
+}
@@ -0,0 +1,435 @@
+package riaktest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/tpjg/goriakpbc/pb"
+)
+
+// A bucket is identified by its bucket type and name
+type bucketId struct {
+	typ  string
+	name string
+}
+
+type bucket struct {
+	props   *pb.RpbBucketProps // Properties set on the bucket itself
+	objects map[string]*object
+}
+
+// An object with its siblings, deleted objects are kept as tombstones so
+// their vclock can be returned.
+type object struct {
+	vclock   []byte
+	siblings []*pb.RpbContent
+	deleted  bool
+	dt       *dtValue // Set for data types
+}
+
+// The bucket types that exist when the server starts
+func defaultTypes() map[string]*pb.RpbBucketProps {
+	return map[string]*pb.RpbBucketProps{
+		"default":  {AllowMult: proto.Bool(false)},
+		"counters": {Datatype: []byte("counter")},
+		"sets":     {Datatype: []byte("set")},
+		"maps":     {Datatype: []byte("map")},
+	}
+}
+
+/*
+Create or change a bucket type, like "riak-admin bucket-type create". The
+properties apply to all buckets of the type, unless they are overridden on the
+bucket. Set the Datatype property to "counter", "set" or "map" for data types.
+The types "default", "counters", "sets" and "maps" always exist.
+*/
+func (s *Server) SetBucketType(name string, props *pb.RpbBucketProps) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if t, ok := s.types[name]; ok {
+		mergeProps(t, props)
+	} else {
+		s.types[name] = proto.Clone(props).(*pb.RpbBucketProps)
+	}
+}
+
+// Find a bucket, it is created if create is set and the bucket type exists
+func (s *Server) bucket(typ []byte, name []byte, create bool) (*bucket, error) {
+	id := bucketId{string(typ), string(name)}
+	if id.typ == "" {
+		id.typ = "default"
+	}
+	if _, ok := s.types[id.typ]; !ok {
+		return nil, fmt.Errorf("No bucket-type named %q", id.typ)
+	}
+	b := s.buckets[id]
+	if b == nil && create {
+		b = &bucket{objects: make(map[string]*object)}
+		s.buckets[id] = b
+	}
+	return b, nil
+}
+
+// Return the properties of a bucket, including the defaults of the bucket type
+func (s *Server) props(typ []byte, name []byte) (*pb.RpbBucketProps, error) {
+	b, err := s.bucket(typ, name, false)
+	if err != nil {
+		return nil, err
+	}
+	props := s.typeProps(typ)
+	if b != nil && b.props != nil {
+		mergeProps(props, b.props)
+	}
+	return props, nil
+}
+
+// Return the properties of a bucket type, including the defaults, nil if the
+// type does not exist
+func (s *Server) typeProps(typ []byte) *pb.RpbBucketProps {
+	if len(typ) == 0 {
+		typ = []byte("default")
+	}
+	t, ok := s.types[string(typ)]
+	if !ok {
+		return nil
+	}
+	props := &pb.RpbBucketProps{
+		NVal:          proto.Uint32(3),
+		AllowMult:     proto.Bool(true),
+		LastWriteWins: proto.Bool(false),
+		BasicQuorum:   proto.Bool(false),
+		NotfoundOk:    proto.Bool(true),
+		Search:        proto.Bool(false),
+	}
+	mergeProps(props, t)
+	return props
+}
+
+// Merge properties, hooks are replaced rather than appended
+func mergeProps(dst *pb.RpbBucketProps, src *pb.RpbBucketProps) {
+	proto.Merge(dst, src)
+	if len(src.Precommit) > 0 || src.GetHasPrecommit() {
+		dst.Precommit = src.Precommit
+	}
+	if len(src.Postcommit) > 0 || src.GetHasPostcommit() {
+		dst.Postcommit = src.Postcommit
+	}
+}
+
+// Return the live object for a key, nil if it does not exist or is deleted
+func (b *bucket) live(key string) *object {
+	if b == nil {
+		return nil
+	}
+	if obj := b.objects[key]; obj != nil && !obj.deleted {
+		return obj
+	}
+	return nil
+}
+
+// Return the keys of all live objects in order
+func (b *bucket) keys() (keys []string) {
+	if b == nil {
+		return nil
+	}
+	for key, obj := range b.objects {
+		if !obj.deleted {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Create a new vclock, only the latest vclock of an object descends from all
+// its siblings.
+func (s *Server) vclock() []byte {
+	return []byte("vclock-" + strconv.FormatUint(s.next(), 10))
+}
+
+// Copy the siblings of an object, without the values if head is set
+func contents(obj *object, head bool) []*pb.RpbContent {
+	content := make([]*pb.RpbContent, len(obj.siblings))
+	for i, c := range obj.siblings {
+		content[i] = proto.Clone(c).(*pb.RpbContent)
+		if head {
+			content[i].Value = []byte{}
+		}
+	}
+	return content
+}
+
+func (s *Server) get(req *pb.RpbGetReq) ([]frame, error) {
+	b, err := s.bucket(req.Type, req.Bucket, false)
+	if err != nil {
+		return nil, err
+	}
+	resp := &pb.RpbGetResp{}
+	if b == nil || b.objects[string(req.Key)] == nil {
+		return one(getResp, resp)
+	}
+	obj := b.objects[string(req.Key)]
+	if obj.deleted {
+		if req.GetDeletedvclock() {
+			resp.Vclock = obj.vclock
+		}
+		return one(getResp, resp)
+	}
+	if req.IfModified != nil && bytes.Equal(req.IfModified, obj.vclock) {
+		resp.Unchanged = proto.Bool(true)
+		return one(getResp, resp)
+	}
+	resp.Vclock = obj.vclock
+	if obj.dt != nil {
+		resp.Content = []*pb.RpbContent{{Value: []byte{}, ContentType: []byte("application/riak_" + obj.dt.kind)}}
+	} else {
+		resp.Content = contents(obj, req.GetHead())
+	}
+	return one(getResp, resp)
+}
+
+func (s *Server) put(req *pb.RpbPutReq) ([]frame, error) {
+	b, err := s.bucket(req.Type, req.Bucket, true)
+	if err != nil {
+		return nil, err
+	}
+	props, _ := s.props(req.Type, req.Bucket)
+	resp := &pb.RpbPutResp{}
+	key := string(req.Key)
+	if key == "" {
+		key = s.newKey()
+		resp.Key = []byte(key)
+	}
+	obj := b.live(key)
+	if req.GetIfNoneMatch() && obj != nil {
+		return nil, fmt.Errorf("match_found")
+	}
+	if req.GetIfNotModified() {
+		if obj == nil {
+			return nil, fmt.Errorf("notfound")
+		}
+		if !bytes.Equal(req.Vclock, obj.vclock) {
+			return nil, fmt.Errorf("modified")
+		}
+	}
+
+	content := proto.Clone(req.Content).(*pb.RpbContent)
+	now := time.Now()
+	content.Vtag = []byte("vtag-" + strconv.FormatUint(s.next(), 10))
+	content.LastMod = proto.Uint32(uint32(now.Unix()))
+	content.LastModUsecs = proto.Uint32(uint32(now.Nanosecond() / 1000))
+	if content.Value == nil {
+		content.Value = []byte{}
+	}
+	if obj == nil || obj.dt != nil || !props.GetAllowMult() || props.GetLastWriteWins() || bytes.Equal(req.Vclock, obj.vclock) {
+		// The new value replaces the current value(s)
+		obj = &object{siblings: []*pb.RpbContent{content}}
+		b.objects[key] = obj
+	} else {
+		// Concurrent write, add a sibling
+		obj.siblings = append(obj.siblings, content)
+	}
+	obj.vclock = s.vclock()
+
+	if req.GetReturnBody() || req.GetReturnHead() {
+		resp.Vclock = obj.vclock
+		resp.Content = contents(obj, !req.GetReturnBody())
+	}
+	return one(putResp, resp)
+}
+
+func (s *Server) del(req *pb.RpbDelReq) ([]frame, error) {
+	b, err := s.bucket(req.Type, req.Bucket, false)
+	if err != nil {
+		return nil, err
+	}
+	if obj := b.live(string(req.Key)); obj != nil {
+		b.objects[string(req.Key)] = &object{vclock: s.vclock(), deleted: true}
+	}
+	return one(delResp, nil)
+}
+
+// Generate a key for an object stored without a key
+func (s *Server) newKey() string {
+	return "key" + strconv.FormatUint(s.next(), 36)
+}
+
+// Number of keys or buckets sent in a single streamed response
+const chunkSize = 100
+
+func (s *Server) listKeys(req *pb.RpbListKeysReq) ([]frame, error) {
+	b, err := s.bucket(req.Type, req.Bucket, false)
+	if err != nil {
+		return nil, err
+	}
+	keys := b.keys()
+	var frames []frame
+	for len(keys) > 0 {
+		n := chunkSize
+		if n > len(keys) {
+			n = len(keys)
+		}
+		resp := &pb.RpbListKeysResp{}
+		for _, k := range keys[:n] {
+			resp.Keys = append(resp.Keys, []byte(k))
+		}
+		frames = append(frames, frame{listKeysResp, resp})
+		keys = keys[n:]
+	}
+	frames = append(frames, frame{listKeysResp, &pb.RpbListKeysResp{Done: proto.Bool(true)}})
+	return frames, nil
+}
+
+// Fold over the objects of a key range, the continuation is the last key
+// that was returned
+func (s *Server) csBucket(req *pb.RpbCSBucketReq) ([]frame, error) {
+	b, err := s.bucket(req.Type, req.Bucket, false)
+	if err != nil {
+		return nil, err
+	}
+	start, end := string(req.StartKey), string(req.EndKey)
+	if len(req.Continuation) > 0 {
+		last, err := base64.StdEncoding.DecodeString(string(req.Continuation))
+		if err != nil {
+			return nil, errors.New("Invalid continuation")
+		}
+		start = string(last)
+	}
+	var keys []string
+	for _, key := range b.keys() {
+		if key < start || key == start && (!req.GetStartIncl() || len(req.Continuation) > 0) {
+			continue
+		}
+		if len(req.EndKey) > 0 && (key > end || key == end && !req.GetEndIncl()) {
+			break
+		}
+		if req.MaxResults != nil && len(keys) == int(req.GetMaxResults()) {
+			break
+		}
+		keys = append(keys, key)
+	}
+	var next []byte
+	if req.MaxResults != nil && len(keys) == int(req.GetMaxResults()) && len(keys) > 0 {
+		next = []byte(base64.StdEncoding.EncodeToString([]byte(keys[len(keys)-1])))
+	}
+
+	var frames []frame
+	for len(keys) > 0 {
+		n := chunkSize
+		if n > len(keys) {
+			n = len(keys)
+		}
+		resp := &pb.RpbCSBucketResp{}
+		for _, key := range keys[:n] {
+			obj := b.objects[key]
+			resp.Objects = append(resp.Objects, &pb.RpbIndexObject{Key: []byte(key),
+				Object: &pb.RpbGetResp{Vclock: obj.vclock, Content: contents(obj, false)}})
+		}
+		frames = append(frames, frame{csBucketResp, resp})
+		keys = keys[n:]
+	}
+	frames = append(frames, frame{csBucketResp, &pb.RpbCSBucketResp{Continuation: next, Done: proto.Bool(true)}})
+	return frames, nil
+}
+
+func (s *Server) listBuckets(req *pb.RpbListBucketsReq) ([]frame, error) {
+	typ := string(req.Type)
+	if typ == "" {
+		typ = "default"
+	}
+	if _, ok := s.types[typ]; !ok {
+		return nil, fmt.Errorf("No bucket-type named %q", typ)
+	}
+	var names []string
+	for id, b := range s.buckets {
+		if id.typ == typ && len(b.keys()) > 0 {
+			names = append(names, id.name)
+		}
+	}
+	sort.Strings(names)
+	if !req.GetStream() {
+		resp := &pb.RpbListBucketsResp{}
+		for _, name := range names {
+			resp.Buckets = append(resp.Buckets, []byte(name))
+		}
+		return one(listBucketsResp, resp)
+	}
+	var frames []frame
+	for len(names) > 0 {
+		n := chunkSize
+		if n > len(names) {
+			n = len(names)
+		}
+		resp := &pb.RpbListBucketsResp{}
+		for _, name := range names[:n] {
+			resp.Buckets = append(resp.Buckets, []byte(name))
+		}
+		frames = append(frames, frame{listBucketsResp, resp})
+		names = names[n:]
+	}
+	frames = append(frames, frame{listBucketsResp, &pb.RpbListBucketsResp{Done: proto.Bool(true)}})
+	return frames, nil
+}
+
+func (s *Server) getBucket(req *pb.RpbGetBucketReq) ([]frame, error) {
+	props, err := s.props(req.Type, req.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	return one(getBucketResp, &pb.RpbGetBucketResp{Props: props})
+}
+
+func (s *Server) setBucket(req *pb.RpbSetBucketReq) ([]frame, error) {
+	b, err := s.bucket(req.Type, req.Bucket, true)
+	if err != nil {
+		return nil, err
+	}
+	if b.props == nil {
+		b.props = &pb.RpbBucketProps{}
+	}
+	if req.Props != nil {
+		mergeProps(b.props, req.Props)
+	}
+	return one(setBucketResp, nil)
+}
+
+func (s *Server) resetBucket(req *pb.RpbResetBucketReq) ([]frame, error) {
+	b, err := s.bucket(req.Type, req.Bucket, false)
+	if err != nil {
+		return nil, err
+	}
+	if b != nil {
+		b.props = nil
+	}
+	return one(resetBucketResp, nil)
+}
+
+func (s *Server) getBucketType(req *pb.RpbGetBucketTypeReq) ([]frame, error) {
+	props := s.typeProps(req.Type)
+	if props == nil {
+		return nil, fmt.Errorf("No bucket-type named %q", req.Type)
+	}
+	return one(getBucketResp, &pb.RpbGetBucketResp{Props: props})
+}
+
+func (s *Server) setBucketType(req *pb.RpbSetBucketTypeReq) ([]frame, error) {
+	t, ok := s.types[string(req.Type)]
+	if !ok {
+		return nil, fmt.Errorf("No bucket-type named %q", req.Type)
+	}
+	// The data type and consistency of an existing type cannot be changed
+	if req.Props.Datatype != nil && string(req.Props.Datatype) != string(t.Datatype) {
+		return nil, fmt.Errorf("Error, the bucket type could not be updated because the following properties were not valid: [{datatype,cannot_modify_datatype}]")
+	}
+	if req.Props.Consistent != nil && req.Props.GetConsistent() != t.GetConsistent() {
+		return nil, fmt.Errorf("Error, the bucket type could not be updated because the following properties were not valid: [{consistent,cannot_modify_consistent}]")
+	}
+	mergeProps(t, req.Props)
+	return one(setBucketResp, nil)
+}
@@ -0,0 +1,411 @@
+/*
+Package riaktest provides an in-memory Riak server for tests. It speaks the
+same protocol buffers interface as a Riak node on a local TCP port, so a
+riak.Client can connect to it without a real Riak cluster:
+
+	server, err := riaktest.NewServer()
+	...
+	defer server.Close()
+	client := riak.NewClient(server.Addr())
+	err = client.Connect()
+
+The server supports KV get/put/delete with vclocks and siblings (allow_mult),
+bucket properties and bucket types, secondary indexes (eq and range queries
+with pagination), listing keys and buckets, object folds over a key range,
+counters and the counter, set and map data types. MapReduce and search are not supported.
+
+Errors and slow or unresponsive nodes can be simulated with SetFault.
+*/
+package riaktest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/tpjg/goriakpbc/pb"
+)
+
+// A Server is an in-memory Riak node
+type Server struct {
+	ln      net.Listener
+	mutex   sync.Mutex
+	types   map[string]*pb.RpbBucketProps
+	buckets map[bucketId]*bucket
+	fault   Fault
+	seq     uint64
+	conns   map[net.Conn]bool
+	closed  bool
+	wg      sync.WaitGroup // Waits for the accept loop and the connections
+}
+
+/*
+A Fault is called for every request before it is handled, with the message
+code and the decoded request (nil for requests without a body). Return nil to
+handle the request as usual, CloseConnection to close the connection without
+a response or any other error to send an error response instead. An
+*ErrorResponse sets the error code as well. A Fault may also sleep or block to
+simulate a slow or unresponsive node.
+
+	server.SetFault(func(code byte, req proto.Message) error {
+		if code == riaktest.PutReq {
+			return errors.New("overload")
+		}
+		return nil
+	})
+*/
+type Fault func(code byte, req proto.Message) error
+
+// Close the connection without sending a response
+var CloseConnection = errors.New("riaktest: close connection")
+
+// An error response with a specific error code
+type ErrorResponse struct {
+	Code    uint32
+	Message string
+}
+
+func (e *ErrorResponse) Error() string {
+	return e.Message
+}
+
+// Message codes of the requests, for use in a Fault
+const (
+	PingReq          = 1
+	GetClientIdReq   = 3
+	SetClientIdReq   = 5
+	GetServerInfoReq = 7
+	GetReq           = 9
+	PutReq           = 11
+	DelReq           = 13
+	ListBucketsReq   = 15
+	ListKeysReq      = 17
+	GetBucketReq     = 19
+	SetBucketReq     = 21
+	MapRedReq        = 23
+	IndexReq         = 25
+	SearchQueryReq   = 27
+	ResetBucketReq   = 29
+	GetBucketTypeReq = 31
+	SetBucketTypeReq = 32
+	CSBucketReq      = 40
+	CounterUpdateReq = 50
+	CounterGetReq    = 52
+	DtFetchReq       = 80
+	DtUpdateReq      = 82
+)
+
+// Message codes of the responses
+const (
+	errorResp         = 0
+	pingResp          = 2
+	getClientIdResp   = 4
+	setClientIdResp   = 6
+	getServerInfoResp = 8
+	getResp           = 10
+	putResp           = 12
+	delResp           = 14
+	listBucketsResp   = 16
+	listKeysResp      = 18
+	getBucketResp     = 20
+	setBucketResp     = 22
+	indexResp         = 26
+	resetBucketResp   = 30
+	csBucketResp      = 41
+	counterUpdateResp = 51
+	counterGetResp    = 53
+	dtFetchResp       = 81
+	dtUpdateResp      = 83
+)
+
+// The version reported by GetServerInfo
+const ServerVersion = "2.0.0"
+
+// A single response message
+type frame struct {
+	code byte
+	msg  proto.Message
+}
+
+// The state of a client connection
+type session struct {
+	clientId []byte
+}
+
+// Start a new server on a random local port
+func NewServer() (s *Server, err error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s = &Server{
+		ln:      ln,
+		types:   defaultTypes(),
+		buckets: make(map[bucketId]*bucket),
+		conns:   make(map[net.Conn]bool),
+	}
+	s.wg.Add(1)
+	go s.accept()
+	return s, nil
+}
+
+// Return the address of the server, to be used with riak.NewClient
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// Stop the server, close all connections and wait until they are done
+func (s *Server) Close() {
+	s.ln.Close()
+	s.mutex.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mutex.Unlock()
+	s.wg.Wait()
+}
+
+// Set the fault function that is called for every request, nil removes it
+func (s *Server) SetFault(fault Fault) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.fault = fault
+}
+
+// Remove all data, bucket properties and bucket types that were added
+func (s *Server) Reset() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.types = defaultTypes()
+	s.buckets = make(map[bucketId]*bucket)
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		// A connection accepted while the server is closed is not served
+		s.mutex.Lock()
+		if s.closed {
+			s.mutex.Unlock()
+			conn.Close()
+			continue
+		}
+		s.conns[conn] = true
+		s.wg.Add(1)
+		s.mutex.Unlock()
+		go s.serve(conn)
+	}
+}
+
+// Handle the requests on a connection until it is closed
+func (s *Server) serve(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.conns, conn)
+		s.mutex.Unlock()
+		conn.Close()
+	}()
+	sess := &session{}
+	for {
+		code, data, err := readFrame(conn)
+		if err != nil {
+			return
+		}
+		req := newRequest(code)
+		if req != nil {
+			if err = proto.Unmarshal(data, req); err != nil {
+				if writeFrames(conn, errorFrame(err)) != nil {
+					return
+				}
+				continue
+			}
+		}
+		s.mutex.Lock()
+		fault := s.fault
+		s.mutex.Unlock()
+		if fault != nil {
+			if err = fault(code, req); err == CloseConnection {
+				return
+			} else if err != nil {
+				if writeFrames(conn, errorFrame(err)) != nil {
+					return
+				}
+				continue
+			}
+		}
+		s.mutex.Lock()
+		frames, err := s.handle(sess, code, req)
+		s.mutex.Unlock()
+		if err != nil {
+			frames = errorFrame(err)
+		}
+		if writeFrames(conn, frames) != nil {
+			return
+		}
+	}
+}
+
+// Create an empty request message for a message code
+func newRequest(code byte) proto.Message {
+	switch code {
+	case SetClientIdReq:
+		return &pb.RpbSetClientIdReq{}
+	case GetReq:
+		return &pb.RpbGetReq{}
+	case PutReq:
+		return &pb.RpbPutReq{}
+	case DelReq:
+		return &pb.RpbDelReq{}
+	case ListBucketsReq:
+		return &pb.RpbListBucketsReq{}
+	case ListKeysReq:
+		return &pb.RpbListKeysReq{}
+	case GetBucketReq:
+		return &pb.RpbGetBucketReq{}
+	case SetBucketReq:
+		return &pb.RpbSetBucketReq{}
+	case MapRedReq:
+		return &pb.RpbMapRedReq{}
+	case IndexReq:
+		return &pb.RpbIndexReq{}
+	case SearchQueryReq:
+		return &pb.RpbSearchQueryReq{}
+	case ResetBucketReq:
+		return &pb.RpbResetBucketReq{}
+	case GetBucketTypeReq:
+		return &pb.RpbGetBucketTypeReq{}
+	case SetBucketTypeReq:
+		return &pb.RpbSetBucketTypeReq{}
+	case CSBucketReq:
+		return &pb.RpbCSBucketReq{}
+	case CounterUpdateReq:
+		return &pb.RpbCounterUpdateReq{}
+	case CounterGetReq:
+		return &pb.RpbCounterGetReq{}
+	case DtFetchReq:
+		return &pb.DtFetchReq{}
+	case DtUpdateReq:
+		return &pb.DtUpdateReq{}
+	}
+	return nil
+}
+
+// Handle a single request, must be called with the mutex held
+func (s *Server) handle(sess *session, code byte, req proto.Message) ([]frame, error) {
+	switch code {
+	case PingReq:
+		return one(pingResp, nil)
+	case GetClientIdReq:
+		return one(getClientIdResp, &pb.RpbGetClientIdResp{ClientId: sess.clientId})
+	case SetClientIdReq:
+		sess.clientId = req.(*pb.RpbSetClientIdReq).ClientId
+		return one(setClientIdResp, nil)
+	case GetServerInfoReq:
+		return one(getServerInfoResp, &pb.RpbGetServerInfoResp{
+			Node:          []byte("riaktest@" + s.Addr()),
+			ServerVersion: []byte(ServerVersion),
+		})
+	case GetReq:
+		return s.get(req.(*pb.RpbGetReq))
+	case PutReq:
+		return s.put(req.(*pb.RpbPutReq))
+	case DelReq:
+		return s.del(req.(*pb.RpbDelReq))
+	case ListBucketsReq:
+		return s.listBuckets(req.(*pb.RpbListBucketsReq))
+	case ListKeysReq:
+		return s.listKeys(req.(*pb.RpbListKeysReq))
+	case GetBucketReq:
+		return s.getBucket(req.(*pb.RpbGetBucketReq))
+	case SetBucketReq:
+		return s.setBucket(req.(*pb.RpbSetBucketReq))
+	case ResetBucketReq:
+		return s.resetBucket(req.(*pb.RpbResetBucketReq))
+	case GetBucketTypeReq:
+		return s.getBucketType(req.(*pb.RpbGetBucketTypeReq))
+	case SetBucketTypeReq:
+		return s.setBucketType(req.(*pb.RpbSetBucketTypeReq))
+	case IndexReq:
+		return s.index(req.(*pb.RpbIndexReq))
+	case CSBucketReq:
+		return s.csBucket(req.(*pb.RpbCSBucketReq))
+	case CounterUpdateReq:
+		return s.counterUpdate(req.(*pb.RpbCounterUpdateReq))
+	case CounterGetReq:
+		return s.counterGet(req.(*pb.RpbCounterGetReq))
+	case DtFetchReq:
+		return s.dtFetch(req.(*pb.DtFetchReq))
+	case DtUpdateReq:
+		return s.dtUpdate(req.(*pb.DtUpdateReq))
+	case MapRedReq:
+		return nil, errors.New("MapReduce is not supported by riaktest")
+	case SearchQueryReq:
+		return nil, fmt.Errorf("No index <<%q>> found.", req.(*pb.RpbSearchQueryReq).Index)
+	}
+	return nil, fmt.Errorf("Unknown message code: %d", code)
+}
+
+// Return a single response
+func one(code byte, msg proto.Message) ([]frame, error) {
+	return []frame{{code, msg}}, nil
+}
+
+// Return an error response
+func errorFrame(err error) []frame {
+	resp := &pb.RpbErrorResp{Errmsg: []byte(err.Error()), Errcode: new(uint32)}
+	if eresp, ok := err.(*ErrorResponse); ok {
+		resp.Errcode = &eresp.Code
+	}
+	return []frame{{errorResp, resp}}
+}
+
+// Next unique number, used for vclocks, vtags, contexts and generated keys
+func (s *Server) next() uint64 {
+	s.seq++
+	return s.seq
+}
+
+// Read a message: <length:32> <msg_code:8> <pbmsg>
+func readFrame(conn net.Conn) (code byte, data []byte, err error) {
+	header := make([]byte, 5)
+	if _, err = io.ReadFull(conn, header); err != nil {
+		return 0, nil, err
+	}
+	length := int(header[0])<<24 + int(header[1])<<16 + int(header[2])<<8 + int(header[3])
+	if length < 1 {
+		return 0, nil, errors.New("Bad message length")
+	}
+	data = make([]byte, length-1)
+	if _, err = io.ReadFull(conn, data); err != nil {
+		return 0, nil, err
+	}
+	return header[4], data, nil
+}
+
+// Write the response messages
+func writeFrames(conn net.Conn, frames []frame) error {
+	var buf []byte
+	for _, f := range frames {
+		var data []byte
+		if f.msg != nil {
+			var err error
+			if data, err = proto.Marshal(f.msg); err != nil {
+				return err
+			}
+		}
+		i := len(data) + 1
+		buf = append(buf, byte(i>>24), byte(i>>16), byte(i>>8), byte(i), f.code)
+		buf = append(buf, data...)
+	}
+	_, err := conn.Write(buf)
+	return err
+}
@@ -0,0 +1,130 @@
+package riaktest_test
+
+import (
+	"errors"
+	"github.com/bmizerany/assert"
+	"github.com/golang/protobuf/proto"
+	"testing"
+
+	"github.com/tpjg/goriakpbc"
+	"github.com/tpjg/goriakpbc/pb"
+	"github.com/tpjg/goriakpbc/riaktest"
+)
+
+func setup(t *testing.T) (*riaktest.Server, *riak.Client) {
+	server, err := riaktest.NewServer()
+	assert.T(t, err == nil)
+	client := riak.NewClient(server.Addr())
+	assert.T(t, client.Connect() == nil)
+	return server, client
+}
+
+func TestSiblings(t *testing.T) {
+	server, client := setup(t)
+	defer server.Close()
+
+	bucket, err := client.NewBucket("siblings")
+	assert.T(t, err == nil)
+	assert.T(t, bucket.SetAllowMult(true) == nil)
+
+	// Two writes without a vclock create siblings
+	for _, data := range []string{"one", "two"} {
+		obj := bucket.NewObject("key")
+		obj.ContentType = "text/plain"
+		obj.Data = []byte(data)
+		assert.T(t, obj.Store() == nil)
+	}
+	obj, err := bucket.Get("key")
+	assert.T(t, err == nil)
+	assert.T(t, obj.Conflict())
+	assert.T(t, len(obj.Siblings) == 2)
+
+	// Writing with the vclock resolves them
+	obj.Siblings = nil
+	obj.Data = []byte("three")
+	assert.T(t, obj.Store() == nil)
+	obj, err = bucket.Get("key")
+	assert.T(t, err == nil)
+	assert.T(t, !obj.Conflict())
+	assert.T(t, string(obj.Data) == "three")
+
+	// Deleted objects are not found
+	assert.T(t, obj.Destroy() == nil)
+	_, err = bucket.Get("key")
+	assert.T(t, err == riak.NotFound)
+}
+
+func TestDataTypes(t *testing.T) {
+	server, client := setup(t)
+	defer server.Close()
+
+	sets, err := client.NewBucketType("sets", "test")
+	assert.T(t, err == nil)
+	set, err := sets.FetchSet("set")
+	assert.T(t, err == riak.NotFound)
+	set.Add([]byte("a"))
+	set.Add([]byte("b"))
+	assert.T(t, set.Store() == nil)
+	set, err = sets.FetchSet("set")
+	assert.T(t, err == nil)
+	assert.T(t, len(set.GetValue()) == 2)
+
+	// Removing an element that is not in the set fails
+	set.Remove([]byte("c"))
+	err = set.Store()
+	assert.T(t, errors.Is(err, riak.PreconditionFailed))
+
+	maps, err := client.NewBucketType("maps", "test")
+	assert.T(t, err == nil)
+	m, _ := maps.FetchMap("map")
+	m.AddRegister("name").Update([]byte("riak"))
+	m.AddCounter("visits").Increment(3)
+	m.AddMap("nested").AddFlag("enabled").Enable()
+	assert.T(t, m.Store() == nil)
+	m, err = maps.FetchMap("map")
+	assert.T(t, err == nil)
+	assert.T(t, string(m.FetchRegister("name").GetValue()) == "riak")
+	assert.T(t, m.FetchCounter("visits").GetValue() == 3)
+	assert.T(t, m.FetchMap("nested").FetchFlag("enabled").GetValue())
+
+	// Bucket types can be added
+	server.SetBucketType("counters2", &pb.RpbBucketProps{Datatype: []byte("counter")})
+	counters, err := client.NewBucketType("counters2", "test")
+	assert.T(t, err == nil)
+	counter, _ := counters.FetchCounter("counter")
+	counter.Increment(5)
+	assert.T(t, counter.Store() == nil)
+	counter, err = counters.FetchCounter("counter")
+	assert.T(t, err == nil)
+	assert.T(t, counter.GetValue() == 5)
+}
+
+func TestFault(t *testing.T) {
+	server, client := setup(t)
+	defer server.Close()
+
+	bucket, _ := client.NewBucket("fault")
+	server.SetFault(func(code byte, req proto.Message) error {
+		if code == riaktest.PutReq {
+			return errors.New("overload")
+		}
+		return nil
+	})
+	obj := bucket.NewObject("key")
+	err := obj.Store()
+	assert.T(t, errors.Is(err, riak.Overload))
+	assert.T(t, client.Ping() == nil)
+
+	server.SetFault(func(code byte, req proto.Message) error {
+		return riaktest.CloseConnection
+	})
+	var nerr *riak.NetworkError
+	assert.T(t, errors.As(client.Ping(), &nerr))
+
+	// Reset removes all data
+	server.SetFault(nil)
+	assert.T(t, obj.Store() == nil)
+	server.Reset()
+	_, err = bucket.Get("key")
+	assert.T(t, err == riak.NotFound)
+}
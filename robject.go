@@ -1,6 +1,7 @@
 package riak
 
 import (
+	"context"
 	"errors"
 
 	"github.com/tpjg/goriakpbc/pb"
@@ -15,52 +16,139 @@ type Link struct {
 
 // An object van have siblings that can each have their own content
 type Sibling struct {
-	ContentType  string
-	Data         []byte
-	Links        []Link
-	Meta         map[string]string
-	Indexes      map[string][]string
-	Vtag         string
-	LastMod      uint32
-	LastModUsecs uint32
+	ContentType     string
+	ContentEncoding string
+	Charset         string
+	Data            []byte
+	Links           []Link
+	Meta            map[string]string
+	Indexes         map[string][]string
+	Vtag            string
+	LastMod         uint32
+	LastModUsecs    uint32
+	Deleted         bool // The sibling is the tombstone of a deleted value
 }
 
-// An RObject is an object or document that is or can be stored in Riak
+/*
+An RObject is an object or document that is or can be stored in Riak. Data is
+always the uncompressed value, ContentEncoding is the encoding it is stored
+with in Riak, e.g. "gzip" when it is compressed with the compression policy of
+the bucket (see Client.SetBucketCompression). A value with an encoding that
+the client has no codec for is not decompressed.
+*/
 type RObject struct {
-	Bucket       *Bucket
-	Vclock       []byte
-	Key          string
-	ContentType  string
-	Data         []byte
-	Links        []Link
-	Meta         map[string]string
-	Indexes      map[string][]string
-	Vtag         string
-	LastMod      uint32
-	LastModUsecs uint32
-	conflict     bool
-	Siblings     []Sibling
-	Options      []map[string]uint32
+	Bucket          *Bucket
+	Vclock          []byte
+	Key             string
+	ContentType     string
+	ContentEncoding string
+	Charset         string
+	Data            []byte
+	Links           []Link
+	Meta            map[string]string
+	Indexes         map[string][]string
+	Vtag            string
+	LastMod         uint32
+	LastModUsecs    uint32
+	conflict        bool
+	Siblings        []Sibling
+	Options         []map[string]uint32
 }
 
 // Error definitions
 var (
-	NotFound = errors.New("Object not found")
+	NotFound       = errors.New("Object not found")
+	ObjectExists   = classified("Object already exists", PreconditionFailed)
+	ObjectModified = classified("Object was modified", PreconditionFailed)
 )
 
 // Store an RObject
 func (obj *RObject) Store() (err error) {
+	return obj.StoreContext(context.Background())
+}
+
+// Store an RObject, the request is aborted when the context is done
+func (obj *RObject) StoreContext(ctx context.Context) (err error) {
+	return obj.store(ctx, nil)
+}
+
+// Store an RObject only if there is no object with the same key yet, returns
+// ObjectExists otherwise
+func (obj *RObject) StoreIfNotExists() (err error) {
+	return obj.StoreIfNotExistsContext(context.Background())
+}
+
+// Store an RObject only if there is no object with the same key yet, returns
+// ObjectExists otherwise, the request is aborted when the context is done
+func (obj *RObject) StoreIfNotExistsContext(ctx context.Context) (err error) {
+	return obj.store(ctx, IfNoneMatch(true))
+}
+
+/*
+Store an RObject only if the stored object was not changed since it was fetched,
+i.e. the vclock in Riak is still the same as the vclock of the RObject. Returns
+ObjectModified if it was changed and NotFound if it was deleted in the meantime.
+This can be used for optimistic locking:
+
+	obj, err := bucket.Get("key")
+	...modify obj.Data...
+	err = obj.StoreIfUnmodified()
+	if err == riak.ObjectModified {
+		// Get the object again and retry
+	}
+*/
+func (obj *RObject) StoreIfUnmodified() (err error) {
+	return obj.StoreIfUnmodifiedContext(context.Background())
+}
+
+// Store an RObject only if the stored object was not changed since it was
+// fetched, the request is aborted when the context is done
+func (obj *RObject) StoreIfUnmodifiedContext(ctx context.Context) (err error) {
+	return obj.store(ctx, IfNotModified(true))
+}
+
+// Store an RObject, the condition (if any) is added to the options of the object
+func (obj *RObject) store(ctx context.Context, condition Option) (err error) {
+	req, err := obj.putRequest(condition)
+	if err != nil {
+		return err
+	}
+	// Send the request and get the response, ReturnHead is true, so we can store
+	// the vclock. Conditional stores are idempotent, so these can be retried.
+	resp := &pb.RpbPutResp{}
+	err = obj.Bucket.client.do(ctx, condition != nil, req, rpbPutReq, resp)
+	if err != nil {
+		return conditionError(err)
+	}
+	obj.putResult(resp)
+	return nil
+}
+
+// Build the request to store an RObject
+func (obj *RObject) putRequest(condition Option) (*pb.RpbPutReq, error) {
+	// Compress the value if the bucket asks for it
+	value, encoding, err := obj.Bucket.encode(obj.ContentEncoding, obj.Data)
+	if err != nil {
+		return nil, err
+	}
+	obj.ContentEncoding = encoding
 	// Create base pb.RpbPutReq
 	t := true
 	req := &pb.RpbPutReq{
 		Type:   []byte(obj.Bucket.bucket_type),
 		Bucket: []byte(obj.Bucket.name),
 		Content: &pb.RpbContent{
-			Value:       []byte(obj.Data),
+			Value:       value,
 			ContentType: []byte(obj.ContentType),
 		},
 		ReturnHead: &t,
 	}
+	if encoding != "" {
+		req.Content.ContentEncoding = []byte(encoding)
+	}
+	if obj.Charset != "" {
+		req.Content.Charset = []byte(obj.Charset)
+	}
 	if obj.Key != "" {
 		req.Key = []byte(obj.Key)
 	}
@@ -89,70 +177,60 @@ func (obj *RObject) Store() (err error) {
 	}
 
 	// Add the options
-	for _, omap := range obj.Options {
-		for k, v := range omap {
-			switch k {
-			case "w":
-				req.W = &v
-			case "dw":
-				req.Dw = &v
-			case "pw":
-				req.Pw = &v
-			}
-		}
-	}
-
-	// Send the request
-	err, conn := obj.Bucket.client.request(req, rpbPutReq)
+	opts, err := keptOptions("Store", putOptionKeys, obj.Options, objectOperations...)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	// Get response, ReturnHead is true, so we can store the vclock
-	resp := &pb.RpbPutResp{}
-	err = obj.Bucket.client.response(conn, resp)
-	if err != nil {
-		return err
+	opts.applyPut(req)
+	if condition != nil {
+		opts, _ = parseOptions("Store", putOptionKeys, []map[string]uint32{condition})
+		opts.applyPut(req)
 	}
+	return req, nil
+}
+
+// Set the vclock and (if applicable) the key from the response to a store
+func (obj *RObject) putResult(resp *pb.RpbPutResp) {
 	obj.Vclock = resp.Vclock
-	// If applicable, store the key
 	if obj.Key == "" {
 		obj.Key = string(resp.Key)
 	}
+}
 
-	return nil
+// Translate the errors Riak returns when the condition of a put fails, the
+// number of attempts of a retried put is kept
+func conditionError(err error) error {
+	for _, class := range []error{ObjectExists, ObjectModified, NotFound} {
+		if errors.Is(err, class) {
+			var rerr *RetryError
+			if errors.As(err, &rerr) {
+				return &RetryError{Attempts: rerr.Attempts, Err: class}
+			}
+			return class
+		}
+	}
+	return err
 }
 
 // Delete the object from Riak
 func (obj *RObject) Destroy() (err error) {
+	return obj.DestroyContext(context.Background())
+}
+
+// Delete the object from Riak, the request is aborted when the context is done
+func (obj *RObject) DestroyContext(ctx context.Context) (err error) {
 	req := &pb.RpbDelReq{
 		Type:   []byte(obj.Bucket.bucket_type),
 		Bucket: []byte(obj.Bucket.name),
 		Key:    []byte(obj.Key),
 		Vclock: obj.Vclock}
-	for _, omap := range obj.Options {
-		for k, v := range omap {
-			switch k {
-			case "r":
-				req.R = &v
-			case "pr":
-				req.Pr = &v
-			case "rw":
-				req.Rw = &v
-			case "w":
-				req.W = &v
-			case "dw":
-				req.Dw = &v
-			case "pw":
-				req.Pw = &v
-			}
-		}
-	}
-
-	err, conn := obj.Bucket.client.request(req, rpbDelReq)
+	opts, err := keptOptions("Destroy", delOptionKeys, obj.Options, objectOperations...)
 	if err != nil {
 		return err
 	}
-	err = obj.Bucket.client.response(conn, req)
+	opts.applyDel(req)
+
+	err = obj.Bucket.client.do(ctx, false, req, rpbDelReq, req)
 	if err != nil {
 		return err
 	}
@@ -164,19 +242,27 @@ func (obj *RObject) Conflict() bool {
 	return obj.conflict
 }
 
-// Sets the values that returned from a pb.RpbGetResp in the RObject
-func (obj *RObject) setContent(resp *pb.RpbGetResp) {
+// Sets the values that returned from a pb.RpbGetResp in the RObject, the
+// values are decompressed
+func (obj *RObject) setContent(resp *pb.RpbGetResp) error {
 	// Check if there are siblings
 	if len(resp.Content) > 1 {
 		// Mark as conflict, set fields
 		obj.conflict = true
 		obj.Siblings = make([]Sibling, len(resp.Content))
 		for i, content := range resp.Content {
+			data, err := obj.Bucket.decode(string(content.ContentEncoding), content.Value)
+			if err != nil {
+				return err
+			}
 			obj.Siblings[i].ContentType = string(content.ContentType)
-			obj.Siblings[i].Data = content.Value
+			obj.Siblings[i].ContentEncoding = string(content.ContentEncoding)
+			obj.Siblings[i].Charset = string(content.Charset)
+			obj.Siblings[i].Data = data
 			obj.Siblings[i].Vtag = string(content.Vtag)
 			obj.Siblings[i].LastMod = *content.LastMod
 			obj.Siblings[i].LastModUsecs = *content.LastModUsecs
+			obj.Siblings[i].Deleted = content.GetDeleted()
 			obj.Siblings[i].Links = make([]Link, len(content.Links))
 			for j, link := range content.Links {
 				obj.Siblings[i].Links[j] = Link{string(link.Bucket),
@@ -196,9 +282,15 @@ func (obj *RObject) setContent(resp *pb.RpbGetResp) {
 		}
 	} else if len(resp.Content) == 1 {
 		// No conflict, set the fields in object directly
+		data, err := obj.Bucket.decode(string(resp.Content[0].ContentEncoding), resp.Content[0].Value)
+		if err != nil {
+			return err
+		}
 		obj.conflict = false
 		obj.ContentType = string(resp.Content[0].ContentType)
-		obj.Data = resp.Content[0].Value
+		obj.ContentEncoding = string(resp.Content[0].ContentEncoding)
+		obj.Charset = string(resp.Content[0].Charset)
+		obj.Data = data
 		obj.Links = make([]Link, len(resp.Content[0].Links))
 		for j, link := range resp.Content[0].Links {
 			obj.Links[j] = Link{string(link.Bucket),
@@ -219,6 +311,7 @@ func (obj *RObject) setContent(resp *pb.RpbGetResp) {
 		obj.LastMod = *resp.Content[0].LastMod
 		obj.LastModUsecs = *resp.Content[0].LastModUsecs
 	}
+	return nil
 }
 
 // Add a link to another object (does not store the object, must explicitly call "Store()")
@@ -241,6 +334,49 @@ func (obj *RObject) AddLink(link Link) bool {
 
 // Get an object
 func (b *Bucket) Get(key string, options ...map[string]uint32) (obj *RObject, err error) {
+	return b.GetContext(context.Background(), key, options...)
+}
+
+// Get an object, the request is aborted when the context is done
+func (b *Bucket) GetContext(ctx context.Context, key string, options ...map[string]uint32) (obj *RObject, err error) {
+	return b.get(ctx, "Get", key, false, options)
+}
+
+/*
+Get only the metadata of an object: the vclock, content type, links, user
+metadata, indexes, vtag and last modification time are set, but Data is not
+(the same goes for the Siblings). Storing the returned object as is would
+clear the value, so set Data first or use Get.
+*/
+func (b *Bucket) Head(key string, options ...map[string]uint32) (obj *RObject, err error) {
+	return b.HeadContext(context.Background(), key, options...)
+}
+
+// Get only the metadata of an object, the request is aborted when the context is done
+func (b *Bucket) HeadContext(ctx context.Context, key string, options ...map[string]uint32) (obj *RObject, err error) {
+	return b.get(ctx, "Head", key, true, options)
+}
+
+func (b *Bucket) get(ctx context.Context, operation string, key string, head bool, options []map[string]uint32) (obj *RObject, err error) {
+	req, err := b.getRequest(operation, key, head, options)
+	if err != nil {
+		return nil, err
+	}
+	resp := &pb.RpbGetResp{}
+	err = b.client.do(ctx, true, req, rpbGetReq, resp)
+	if err != nil {
+		return nil, err
+	}
+	obj, err = b.getResult(key, options, resp)
+	// Without the data (Head) the siblings cannot be resolved
+	if err == nil && !req.GetHead() {
+		err = obj.resolveSiblings(ctx, true)
+	}
+	return obj, err
+}
+
+// Build the request to get an object
+func (b *Bucket) getRequest(operation string, key string, head bool, options []map[string]uint32) (*pb.RpbGetReq, error) {
 	t := true
 	req := &pb.RpbGetReq{
 		Type:          []byte(b.bucket_type),
@@ -249,25 +385,20 @@ func (b *Bucket) Get(key string, options ...map[string]uint32) (obj *RObject, er
 		NotfoundOk:    &t,
 		Deletedvclock: &t,
 	}
-	for _, omap := range options {
-		for k, v := range omap {
-			switch k {
-			case "r":
-				req.R = &v
-			case "pr":
-				req.Pr = &v
-			}
-		}
-	}
-	err, conn := b.client.request(req, rpbGetReq)
+	opts, err := parseOptions(operation, getOptionKeys, options)
 	if err != nil {
 		return nil, err
 	}
-	resp := &pb.RpbGetResp{}
-	err = b.client.response(conn, resp)
-	if err != nil {
-		return nil, err
+	opts.applyGet(req)
+	if head {
+		req.Head = &head
 	}
+	return req, nil
+}
+
+// Create the object from the response to a get, returns NotFound (and an
+// object with only the vclock) if there is no object with the key
+func (b *Bucket) getResult(key string, options []map[string]uint32, resp *pb.RpbGetResp) (obj *RObject, err error) {
 	// Create a new object (even if only for storing the returned Vclock)
 	obj = &RObject{Key: key, Bucket: b, Vclock: resp.Vclock, Options: options}
 
@@ -276,44 +407,47 @@ func (b *Bucket) Get(key string, options ...map[string]uint32) (obj *RObject, er
 		return obj, NotFound
 	}
 	// Set the fields
-	obj.setContent(resp)
+	if err = obj.setContent(resp); err != nil {
+		return obj, err
+	}
 
 	return obj, nil
 }
 
 // Get directly from a bucket, without creating a bucket first
 func (c *Client) GetFrom(bucketname string, key string, options ...map[string]uint32) (obj *RObject, err error) {
+	return c.GetFromContext(context.Background(), bucketname, key, options...)
+}
+
+// Get directly from a bucket, without creating a bucket first, the request is aborted when the context is done
+func (c *Client) GetFromContext(ctx context.Context, bucketname string, key string, options ...map[string]uint32) (obj *RObject, err error) {
 	var bucket *Bucket
-	bucket, err = c.Bucket(bucketname)
+	bucket, err = c.NewBucketContext(ctx, bucketname)
 	if err != nil {
 		return
 	}
-	return bucket.Get(key, options...)
+	return bucket.GetContext(ctx, key, options...)
 }
 
 // Reload an object if it has changed (new Vclock)
 func (obj *RObject) Reload() (err error) {
+	return obj.ReloadContext(context.Background())
+}
+
+// Reload an object if it has changed (new Vclock), the request is aborted when the context is done
+func (obj *RObject) ReloadContext(ctx context.Context) (err error) {
 	req := &pb.RpbGetReq{
 		Type:       []byte(obj.Bucket.bucket_type),
 		Bucket:     []byte(obj.Bucket.name),
 		Key:        []byte(obj.Key),
 		IfModified: obj.Vclock}
-	for _, omap := range obj.Options {
-		for k, v := range omap {
-			switch k {
-			case "r":
-				req.R = &v
-			case "pr":
-				req.Pr = &v
-			}
-		}
-	}
-	err, conn := obj.Bucket.client.request(req, rpbGetReq)
+	opts, err := keptOptions("Reload", getOptionKeys, obj.Options, objectOperations...)
 	if err != nil {
 		return err
 	}
+	opts.applyGet(req)
 	resp := &pb.RpbGetResp{}
-	err = obj.Bucket.client.response(conn, resp)
+	err = obj.Bucket.client.do(ctx, true, req, rpbGetReq, resp)
 	if err != nil {
 		return err
 	}
@@ -322,7 +456,11 @@ func (obj *RObject) Reload() (err error) {
 	}
 	// Object has new content, reload object
 	obj.Vclock = resp.Vclock
-	obj.setContent(resp)
-
-	return nil
+	if err = obj.setContent(resp); err != nil {
+		return err
+	}
+	if req.GetHead() {
+		return nil
+	}
+	return obj.resolveSiblings(ctx, true)
 }
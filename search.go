@@ -1,8 +1,10 @@
 package riak
 
 import (
-	"github.com/tpjg/goriakpbc/pb"
+	"context"
 	"errors"
+
+	"github.com/tpjg/goriakpbc/pb"
 )
 
 type Search struct {
@@ -32,6 +34,11 @@ type SearchIndex struct {
 }
 
 func (c *Client) Search(s *Search) ([]map[string][]byte, float32, uint32, error) {
+	return c.SearchContext(context.Background(), s)
+}
+
+// SearchContext is the same as Search, but the request is aborted when the context is done
+func (c *Client) SearchContext(ctx context.Context, s *Search) ([]map[string][]byte, float32, uint32, error) {
 	fl := make([][]byte, len(s.Fields))
 	for i, f := range s.Fields {
 		fl[i] = []byte(f)
@@ -57,13 +64,8 @@ func (c *Client) Search(s *Search) ([]map[string][]byte, float32, uint32, error)
 		req.Presort = []byte(s.PreSort)
 	}
 
-	err, conn := c.request(req, rpbSearchQueryReq)
-	if err != nil {
-		return nil, 0.0, 0, err
-	}
-
 	resp := &pb.RpbSearchQueryResp{}
-	err = c.response(conn, resp)
+	err := c.do(ctx, true, req, rpbSearchQueryReq, resp)
 	if err != nil {
 		return nil, 0.0, 0, err
 	}
@@ -92,6 +94,11 @@ func (c *Client) NewSchema(name string, content string) (*Schema, error) {
 
 // StoreSchema validate schema and sends it to Riak
 func (s *Schema) Store() error {
+	return s.StoreContext(context.Background())
+}
+
+// Validate the schema and send it to Riak, the request is aborted when the context is done
+func (s *Schema) StoreContext(ctx context.Context) error {
 	if s.Name == "" {
 		return errors.New("No schema name specified")
 	}
@@ -105,13 +112,8 @@ func (s *Schema) Store() error {
 			Content: []byte(s.Content),
 		},
 	}
-	err, conn := s.c.request(protobuf, rpbYokozunaSchemaPutReq)
-	if err != nil {
-		return err
-	}
-
 	resp := &pb.RpbPutResp{}
-	err = s.c.response(conn, resp)
+	err := s.c.do(ctx, false, protobuf, rpbYokozunaSchemaPutReq, resp)
 	if err != nil {
 		return err
 	}
@@ -119,19 +121,19 @@ func (s *Schema) Store() error {
 }
 
 func (c *Client) FetchSchema(schemaName string) (*Schema, error) {
+	return c.FetchSchemaContext(context.Background(), schemaName)
+}
+
+// FetchSchemaContext is the same as FetchSchema, but the request is aborted when the context is done
+func (c *Client) FetchSchemaContext(ctx context.Context, schemaName string) (*Schema, error) {
 	if schemaName == "" {
 		return nil, errors.New("No schema name specified")
 	}
 	protobuf := &pb.RpbYokozunaSchemaGetReq{
 		Name: []byte(schemaName),
 	}
-	err, conn := c.request(protobuf, rpbYokozunaSchemaGetReq)
-	if err != nil {
-		return nil, err
-	}
-
 	resp := &pb.RpbYokozunaSchemaGetResp{}
-	err = c.response(conn, resp)
+	err := c.do(ctx, true, protobuf, rpbYokozunaSchemaGetReq, resp)
 	if err != nil {
 		return nil, err
 	}
@@ -154,6 +156,11 @@ func (c *Client) NewSearchIndex(indexName string, schemaName string, nval uint32
 
 // !Please wait some (5) seconds for Riak stores indexes, before start using it
 func (s *SearchIndex) Store() error {
+	return s.StoreContext(context.Background())
+}
+
+// Store the index in Riak, the request is aborted when the context is done
+func (s *SearchIndex) StoreContext(ctx context.Context) error {
 	if s.Name == "" {
 		return errors.New("No index name specified")
 	}
@@ -171,12 +178,8 @@ func (s *SearchIndex) Store() error {
 			NVal: &s.NVal,
 		},
 	}
-	err, conn := s.c.request(protobuf, rpbYokozunaIndexPutReq)
-	if err != nil {
-		return err
-	}
 	resp := &pb.RpbPutResp{}
-	err = s.c.response(conn, resp)
+	err := s.c.do(ctx, false, protobuf, rpbYokozunaIndexPutReq, resp)
 	if err != nil {
 		return err
 	}
@@ -8,6 +8,7 @@ import (
 )
 
 func TestSearch(t *testing.T) {
+	needsRiak(t)
 	client := setupConnection(t)
 	assert.T(t, client != nil)
 	_, version, err := client.ServerVersion()
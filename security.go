@@ -0,0 +1,128 @@
+package riak
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/tpjg/goriakpbc/pb"
+)
+
+// Credentials and TLS configuration used when Riak security is enabled
+type security struct {
+	user     string
+	password string
+	config   *tls.Config
+}
+
+// Error definitions
+var (
+	UnexpectedResponse = errors.New("Unexpected response from Riak")
+)
+
+/*
+Enable Riak security on the Client. Every connection (including connections
+that are made again after Close or an error) is upgraded to TLS using the
+given configuration and then authenticated with the user and password. The
+tls.Config can hold the CA pool, client certificates and server name, if no
+ServerName is set the host of the node address is used.
+
+	config := &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{cert}}
+	client := riak.NewClientPool("riak.example.com:8087", 5)
+	client.SetSecurity("riakuser", "secret", config)
+	err := client.Connect()
+
+Must be called before Connect.
+*/
+func (c *Client) SetSecurity(user string, password string, config *tls.Config) {
+	c.security = &security{user: user, password: password, config: config}
+}
+
+// The time the handshake may take when the client has no connect timeout
+const handshakeTimeout = 10 * time.Second
+
+// Perform the StartTls and Auth handshake on a freshly dialed connection to
+// the node, returns the TLS connection that must be used from then on.
+func (s *security) handshake(n *node, conn net.Conn, timeout time.Duration) (net.Conn, error) {
+	if timeout <= 0 {
+		timeout = handshakeTimeout
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	// Ask Riak to switch to TLS
+	err := n.exchange(conn, rpbStartTls, nil, rpbStartTls)
+	if err != nil {
+		conn.Close()
+		return nil, handshakeError(n.addr, err)
+	}
+	var config *tls.Config
+	if s.config != nil {
+		config = s.config.Clone()
+	} else {
+		config = &tls.Config{}
+	}
+	if config.ServerName == "" {
+		if host, _, err := net.SplitHostPort(n.addr); err == nil {
+			config.ServerName = host
+		}
+	}
+	tlsconn := tls.Client(conn, config)
+	err = tlsconn.Handshake()
+	if err != nil {
+		conn.Close()
+		return nil, handshakeError(n.addr, err)
+	}
+	// Authenticate over the encrypted connection
+	req := &pb.RpbAuthReq{User: []byte(s.user), Password: []byte(s.password)}
+	err = n.exchange(tlsconn, rpbAuthReq, req, rpbAuthResp)
+	if err != nil {
+		tlsconn.Close()
+		return nil, handshakeError(n.addr, err)
+	}
+	tlsconn.SetDeadline(time.Time{})
+	return tlsconn, nil
+}
+
+// Send a handshake request and read the reply, it must have the expected code
+// or be an error response.
+func (n *node) exchange(conn net.Conn, code byte, req proto.Message, reply byte) error {
+	msgbuf, err := frame(req, code)
+	if err != nil {
+		return err
+	}
+	if _, err = conn.Write(msgbuf); err != nil {
+		return err
+	}
+	hc := &nodeConn{Conn: conn, node: n, op: code}
+	msgcode, size, err := n.client.readHeader(hc)
+	var pbmsg []byte
+	if err == nil {
+		pbmsg, err = n.client.read(hc, size)
+	}
+	if err != nil {
+		return err
+	}
+	switch msgcode {
+	case reply:
+		return nil
+	case rpbErrorResp:
+		return decode(msgcode, pbmsg, nil)
+	}
+	return UnexpectedResponse
+}
+
+// Wrap an I/O error of the handshake like a failed dial, so it is retried.
+// Errors sent by Riak (e.g. a failed authentication) are returned as is.
+func handshakeError(addr string, err error) error {
+	var nerr *NetworkError
+	if errors.As(err, &nerr) {
+		err = nerr.Err
+	}
+	var neterr net.Error
+	if errors.As(err, &neterr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return &NetworkError{Node: addr, Op: "Connect", Err: err}
+	}
+	return err
+}
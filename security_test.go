@@ -0,0 +1,180 @@
+package riak
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"errors"
+	"github.com/bmizerany/assert"
+	"github.com/golang/protobuf/proto"
+	"github.com/tpjg/goriakpbc/pb"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// Create a self-signed certificate for 127.0.0.1
+func selfSignedCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.T(t, err == nil)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "riak"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.T(t, err == nil)
+	cert, err := x509.ParseCertificate(der)
+	assert.T(t, err == nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+// Read a request with the expected code, it is deserialized in req if that is
+// not nil
+func readRequest(conn net.Conn, code byte, req proto.Message) error {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	size := int(binary.BigEndian.Uint32(header)) - 1
+	if size < 0 || header[4] != code {
+		return UnexpectedResponse
+	}
+	pbmsg := make([]byte, size)
+	if _, err := io.ReadFull(conn, pbmsg); err != nil {
+		return err
+	}
+	if req != nil {
+		return proto.Unmarshal(pbmsg, req)
+	}
+	return nil
+}
+
+// Write a reply, a nil reply has no body
+func writeReply(conn net.Conn, code byte, reply proto.Message) {
+	msgbuf, _ := frame(reply, code)
+	conn.Write(msgbuf)
+}
+
+// Serve StartTls, Auth and Ping requests like a Riak node with security enabled
+func serveSecure(ln net.Listener, cert tls.Certificate, user, password string) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			if readRequest(conn, rpbStartTls, nil) != nil {
+				return
+			}
+			writeReply(conn, rpbStartTls, nil)
+			tlsconn := tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{cert}})
+			auth := &pb.RpbAuthReq{}
+			if readRequest(tlsconn, rpbAuthReq, auth) != nil {
+				return
+			}
+			if string(auth.User) != user || string(auth.Password) != password {
+				writeReply(tlsconn, rpbErrorResp, &pb.RpbErrorResp{Errmsg: []byte("Authentication failed"), Errcode: new(uint32)})
+				return
+			}
+			writeReply(tlsconn, rpbAuthResp, nil)
+			for readRequest(tlsconn, rpbPingReq, nil) == nil {
+				writeReply(tlsconn, rpbPingResp, nil)
+			}
+		}(conn)
+	}
+}
+
+func TestSecurity(t *testing.T) {
+	cert, pool := selfSignedCert(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.T(t, err == nil)
+	defer ln.Close()
+	go serveSecure(ln, cert, "riakuser", "secret")
+
+	client := NewClientPool(ln.Addr().String(), 2)
+	client.SetSecurity("riakuser", "secret", &tls.Config{RootCAs: pool})
+	assert.T(t, client.Connect() == nil)
+	assert.T(t, client.Ping() == nil)
+	assert.T(t, client.Ping() == nil)
+
+	// Connections made after Close are secured again
+	client.Close()
+	assert.T(t, client.Ping() == nil)
+	client.Close()
+
+	// Bad password
+	client = NewClient(ln.Addr().String())
+	client.SetSecurity("riakuser", "wrong", &tls.Config{RootCAs: pool})
+	err = client.Connect()
+	assert.T(t, err != nil)
+	assert.T(t, err.Error() == "Authentication failed")
+	assert.T(t, !IsTransient(err))
+
+	// Unknown certificate authority
+	client = NewClient(ln.Addr().String())
+	client.SetSecurity("riakuser", "secret", &tls.Config{})
+	assert.T(t, client.Connect() != nil)
+}
+
+func TestSecurityHandshakeError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.T(t, err == nil)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			// Hang up during the handshake, or do not answer at all
+			if readRequest(conn, rpbStartTls, nil) == nil {
+				conn.Close()
+			}
+		}
+	}()
+
+	// The handshake fails like a dial, so it is retried
+	client := NewClient(ln.Addr().String())
+	client.SetSecurity("riakuser", "secret", &tls.Config{})
+	err = client.Connect()
+	var nerr *NetworkError
+	assert.T(t, errors.As(err, &nerr))
+	assert.T(t, IsTransient(err))
+	client.Close()
+
+	// The handshake times out with the connect timeout
+	stalled, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.T(t, err == nil)
+	defer stalled.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		if conn, err := stalled.Accept(); err == nil {
+			<-done
+			conn.Close()
+		}
+	}()
+	client = NewClient(stalled.Addr().String())
+	client.SetSecurity("riakuser", "secret", &tls.Config{})
+	client.SetConnectTimeout(50 * time.Millisecond)
+	start := time.Now()
+	err = client.Connect()
+	assert.T(t, errors.As(err, &nerr))
+	assert.T(t, time.Since(start) < 5*time.Second)
+}
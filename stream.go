@@ -0,0 +1,222 @@
+package riak
+
+import (
+	"context"
+	"errors"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/tpjg/goriakpbc/pb"
+)
+
+/*
+Stream the keys of the bucket, fn is called with every batch of keys as it
+arrives from Riak. When fn returns an error the listing stops and the error
+is returned, the rest of the response is not read and the connection is
+closed. Use the Timeout option for a timeout on the Riak side and the context
+to abort the listing.
+
+Listing keys is an expensive operation in Riak, it should not be used in
+production.
+*/
+func (b *Bucket) StreamKeys(ctx context.Context, fn func(keys [][]byte) error, options ...map[string]uint32) error {
+	return b.streamKeys(ctx, "StreamKeys", fn, options)
+}
+
+func (b *Bucket) streamKeys(ctx context.Context, op string, fn func(keys [][]byte) error, options []map[string]uint32) error {
+	req := &pb.RpbListKeysReq{Bucket: []byte(b.name), Type: []byte(b.bucket_type)}
+	opts, err := parseOptions(op, listKeysOptionKeys, options)
+	if err != nil {
+		return err
+	}
+	opts.applyListKeys(req)
+
+	return b.client.stream(ctx, req, rpbListKeysReq, func() proto.Message {
+		return &pb.RpbListKeysResp{}
+	}, func(msg proto.Message) (bool, error) {
+		resp := msg.(*pb.RpbListKeysResp)
+		if len(resp.Keys) > 0 {
+			if err := fn(resp.Keys); err != nil {
+				return false, err
+			}
+		}
+		return resp.GetDone(), nil
+	})
+}
+
+// A batch of keys or bucket names from a streamed listing, the last batch has
+// the error if the listing failed
+type KeyBatch struct {
+	Keys [][]byte
+	Err  error
+}
+
+/*
+Stream the keys of the bucket over a channel, the channel is closed when all
+keys were sent. If the listing failed the last batch has the error. To stop
+early cancel the context, the remaining batches are dropped (the batch with
+the error too) and the channel is closed without waiting for a receiver.
+*/
+func (b *Bucket) KeyBatches(ctx context.Context, options ...map[string]uint32) <-chan KeyBatch {
+	return batches(ctx, func(fn func([][]byte) error) error {
+		return b.StreamKeys(ctx, fn, options...)
+	})
+}
+
+// List the buckets of a bucket type, an empty type is the default type
+func (c *Client) ListBuckets(btype string, options ...map[string]uint32) (buckets [][]byte, err error) {
+	return c.ListBucketsContext(context.Background(), btype, options...)
+}
+
+// List the buckets of a bucket type, the request is aborted when the context is done
+func (c *Client) ListBucketsContext(ctx context.Context, btype string, options ...map[string]uint32) (buckets [][]byte, err error) {
+	req, err := listBucketsRequest("ListBuckets", btype, options)
+	if err != nil {
+		return nil, err
+	}
+	resp := &pb.RpbListBucketsResp{}
+	err = c.do(ctx, true, req, rpbListBucketsReq, resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Buckets, nil
+}
+
+// Stream the buckets of a bucket type, fn is called with every batch of
+// bucket names as it arrives from Riak, see StreamKeys
+func (c *Client) StreamBuckets(ctx context.Context, btype string, fn func(buckets [][]byte) error, options ...map[string]uint32) error {
+	req, err := listBucketsRequest("StreamBuckets", btype, options)
+	if err != nil {
+		return err
+	}
+	req.Stream = proto.Bool(true)
+
+	return c.stream(ctx, req, rpbListBucketsReq, func() proto.Message {
+		return &pb.RpbListBucketsResp{}
+	}, func(msg proto.Message) (bool, error) {
+		resp := msg.(*pb.RpbListBucketsResp)
+		if len(resp.Buckets) > 0 {
+			if err := fn(resp.Buckets); err != nil {
+				return false, err
+			}
+		}
+		return resp.GetDone(), nil
+	})
+}
+
+// Stream the buckets of a bucket type over a channel, see KeyBatches
+func (c *Client) BucketBatches(ctx context.Context, btype string, options ...map[string]uint32) <-chan KeyBatch {
+	return batches(ctx, func(fn func([][]byte) error) error {
+		return c.StreamBuckets(ctx, btype, fn, options...)
+	})
+}
+
+func listBucketsRequest(op string, btype string, options []map[string]uint32) (*pb.RpbListBucketsReq, error) {
+	req := &pb.RpbListBucketsReq{}
+	if btype != "" {
+		req.Type = []byte(btype)
+	}
+	opts, err := parseOptions(op, listBucketsOptionKeys, options)
+	if err != nil {
+		return nil, err
+	}
+	opts.applyListBuckets(req)
+	return req, nil
+}
+
+// Run a streamed listing in a goroutine and send the batches over a channel
+func batches(ctx context.Context, stream func(fn func([][]byte) error) error) <-chan KeyBatch {
+	ch := make(chan KeyBatch)
+	go func() {
+		defer close(ch)
+		err := stream(func(keys [][]byte) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			select {
+			case ch <- KeyBatch{Keys: keys}:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		if err != nil {
+			select {
+			case ch <- KeyBatch{Err: err}:
+			case <-ctx.Done():
+			}
+		}
+	}()
+	return ch
+}
+
+/*
+Send a request with a streamed response and call fn with every response
+message until it returns done. The request is retried as allowed by the retry
+policy until the first message arrived, after that an error ends the stream.
+When fn returns an error the rest of the response is not read, the connection
+is discarded so it is not re-used with messages of this response pending.
+*/
+func (c *Client) stream(ctx context.Context, req proto.Message, code byte, resp func() proto.Message, fn func(msg proto.Message) (done bool, err error)) error {
+	msg := resp()
+	conn, err := c.openStream(ctx, req, code, msg)
+	for err == nil {
+		var done bool
+		if done, err = fn(msg); err != nil {
+			c.abandon(conn, err)
+			return err
+		}
+		if done {
+			c.finish(conn, nil)
+			return nil
+		}
+		msg = resp()
+		err = c.streamed(conn, msg)
+	}
+	return err
+}
+
+// Send a request with a streamed response and read the first message into
+// msg, retrying as allowed by the retry policy. The connection is returned
+// for reading the rest of the response.
+func (c *Client) openStream(ctx context.Context, req proto.Message, code byte, msg proto.Message) (conn *nodeConn, err error) {
+	err = c.retry(ctx, true, func() (err error) {
+		err, conn = c.requestContext(ctx, req, code)
+		if err != nil {
+			return err
+		}
+		return c.streamed(conn, msg)
+	})
+	return conn, err
+}
+
+// Release the connection of a stream that is not read to the end, it is
+// closed because messages of the response are pending
+func (c *Client) abandon(conn *nodeConn, err error) {
+	conn.broken = true
+	c.finish(conn, err)
+}
+
+// Read the next message of a streamed response. The connection is released
+// when an error is returned, it is discarded unless Riak sent the error.
+func (c *Client) streamed(conn *nodeConn, response proto.Message) error {
+	msgcode, size, err := c.readHeader(conn)
+	if err != nil {
+		c.ioError(conn, err)
+		return err
+	}
+	pbmsg, err := c.read(conn, size)
+	if err != nil {
+		c.ioError(conn, err)
+		return err
+	}
+	err = decode(msgcode, pbmsg, response)
+	if err != nil {
+		// An error response ends the stream, anything else leaves it unfinished
+		var rerr *RiakError
+		if !errors.As(err, &rerr) {
+			conn.broken = true
+		}
+		c.finish(conn, err)
+	}
+	return err
+}
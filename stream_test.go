@@ -0,0 +1,125 @@
+package riak
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/bmizerany/assert"
+	"github.com/golang/protobuf/proto"
+	"testing"
+	"time"
+
+	"github.com/tpjg/goriakpbc/pb"
+	"github.com/tpjg/goriakpbc/riaktest"
+)
+
+func TestStreamKeys(t *testing.T) {
+	server, client, done := setupServer(t, 1)
+	defer done()
+	bucket, err := client.NewBucket("stream_test.go")
+	assert.T(t, err == nil)
+	for i := 0; i < 250; i++ {
+		obj := bucket.NewObject(fmt.Sprintf("key%03d", i))
+		obj.ContentType = "text/plain"
+		assert.T(t, obj.Store() == nil)
+	}
+
+	// The keys arrive in batches, the timeout is sent to Riak
+	var timeout uint32
+	server.SetFault(func(code byte, req proto.Message) error {
+		if code == riaktest.ListKeysReq {
+			timeout = req.(*pb.RpbListKeysReq).GetTimeout()
+		}
+		return nil
+	})
+	var sizes []int
+	var keys [][]byte
+	err = bucket.StreamKeys(context.Background(), func(batch [][]byte) error {
+		sizes = append(sizes, len(batch))
+		keys = append(keys, batch...)
+		return nil
+	}, Timeout(2*time.Second))
+	assert.T(t, err == nil)
+	assert.Equal(t, sizes, []int{100, 100, 50})
+	assert.T(t, len(keys) == 250 && string(keys[249]) == "key249")
+	assert.T(t, timeout == 2000)
+	server.SetFault(nil)
+
+	// When the callback stops early the connection is not re-used, the
+	// next request gets its own response
+	stop := errors.New("stop")
+	err = bucket.StreamKeys(context.Background(), func(batch [][]byte) error {
+		return stop
+	})
+	assert.T(t, err == stop)
+	keys, err = bucket.ListKeys()
+	assert.T(t, err == nil)
+	assert.T(t, len(keys) == 250)
+
+	// The request is retried until the first batch arrived
+	client.SetRetryPolicy(&RetryPolicy{MaxAttempts: 3})
+	var count int32
+	server.SetFault(failFirst(riaktest.ListKeysReq, 1, riaktest.CloseConnection, &count))
+	keys, err = bucket.ListKeys()
+	assert.T(t, err == nil)
+	assert.T(t, len(keys) == 250)
+	assert.T(t, count == 2)
+	server.SetFault(nil)
+
+	// Iterate over a channel, cancelling the context stops the listing
+	n := 0
+	for batch := range bucket.KeyBatches(context.Background()) {
+		assert.T(t, batch.Err == nil)
+		n += len(batch.Keys)
+	}
+	assert.T(t, n == 250)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var last KeyBatch
+	for batch := range bucket.KeyBatches(ctx) {
+		cancel()
+		last = batch
+	}
+	assert.T(t, last.Err == nil || last.Err == context.Canceled)
+}
+
+func TestListBuckets(t *testing.T) {
+	// A private server, so only the buckets of this test are listed
+	_, client, done := setupServer(t, 1)
+	defer done()
+	for i := 0; i < 150; i++ {
+		obj, err := client.NewObjectIn(fmt.Sprintf("bucket%03d", i), "key")
+		assert.T(t, err == nil)
+		obj.ContentType = "text/plain"
+		assert.T(t, obj.Store() == nil)
+	}
+	sets, err := client.NewBucketType("sets", "stream_test.go")
+	assert.T(t, err == nil)
+	set, err := sets.FetchSet("set")
+	assert.T(t, err == NotFound)
+	set.Add([]byte("a"))
+	assert.T(t, set.Store() == nil)
+
+	buckets, err := client.ListBuckets("")
+	assert.T(t, err == nil)
+	assert.T(t, len(buckets) == 150 && string(buckets[0]) == "bucket000")
+	buckets, err = client.ListBuckets("sets", Timeout(time.Second))
+	assert.T(t, err == nil)
+	assert.T(t, len(buckets) == 1 && string(buckets[0]) == "stream_test.go")
+	_, err = client.ListBuckets("missing")
+	assert.T(t, err != nil)
+
+	var sizes []int
+	err = client.StreamBuckets(context.Background(), "default", func(batch [][]byte) error {
+		sizes = append(sizes, len(batch))
+		return nil
+	})
+	assert.T(t, err == nil)
+	assert.Equal(t, sizes, []int{100, 50})
+	n := 0
+	for batch := range client.BucketBatches(context.Background(), "") {
+		assert.T(t, batch.Err == nil)
+		n += len(batch.Keys)
+	}
+	assert.T(t, n == 150)
+}
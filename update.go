@@ -0,0 +1,119 @@
+package riak
+
+import (
+	"context"
+	"errors"
+)
+
+// The default maximum number of attempts of Update
+const DefaultUpdateAttempts = 5
+
+// Set the maximum number of times Update fetches and stores an object when
+// it was modified concurrently, 0 uses DefaultUpdateAttempts
+func (c *Client) SetUpdateAttempts(attempts int) {
+	c.updateAttempts = attempts
+}
+
+/*
+Update an object with a read-modify-write cycle: the object is fetched, its
+siblings (if any) are resolved with the ConflictResolver of the bucket, fn
+changes the object and it is stored with the vclock it was fetched with. For
+a key that does not exist fn gets a new, empty object. If fn returns an error
+the object is not stored and the error is returned.
+
+With the IfNotModified(true) option the object is only stored if it was not
+modified since it was fetched (a new object only if the key still does not
+exist), otherwise the update starts over, up to the number of attempts set
+with SetUpdateAttempts. A *RetryError is returned when all attempts failed.
+
+	obj, err := bucket.Update("key", func(obj *riak.RObject) error {
+		obj.Data = append(obj.Data, '!')
+		return nil
+	}, riak.IfNotModified(true))
+*/
+func (b *Bucket) Update(key string, fn func(*RObject) error, options ...map[string]uint32) (obj *RObject, err error) {
+	return b.UpdateContext(context.Background(), key, fn, options...)
+}
+
+// Update an object, the requests are aborted when the context is done
+func (b *Bucket) UpdateContext(ctx context.Context, key string, fn func(*RObject) error, options ...map[string]uint32) (obj *RObject, err error) {
+	// The options of both Get and Store are accepted
+	opts, err := keptOptions("Update", []string{"if_not_modified"}, options, getOptionKeys, putOptionKeys)
+	if err != nil {
+		return nil, err
+	}
+	conditional := false
+	if ifNotModified := opts.flag("if_not_modified"); ifNotModified != nil {
+		conditional = *ifNotModified
+		options = withoutOption(options, "if_not_modified")
+	}
+	attempts := b.client.updateAttempts
+	if attempts <= 0 {
+		attempts = DefaultUpdateAttempts
+	}
+	for attempt := 1; ; attempt++ {
+		obj, err = b.update(ctx, key, fn, conditional, options)
+		if err == nil || !conditional || !concurrentlyModified(err) {
+			return obj, err
+		}
+		if attempt >= attempts {
+			return obj, &RetryError{Attempts: attempt, Err: err}
+		}
+	}
+}
+
+// A single read-modify-write cycle of Update
+func (b *Bucket) update(ctx context.Context, key string, fn func(*RObject) error, conditional bool, options []map[string]uint32) (obj *RObject, err error) {
+	obj, err = b.GetContext(ctx, key, selectOptions(getOptionKeys, options)...)
+	var condition Option
+	switch {
+	case err == NotFound:
+		// Keep the vclock of a deleted object
+		vclock := obj.Vclock
+		obj = b.NewObject(key, options...)
+		obj.Vclock = vclock
+		if conditional {
+			condition = IfNoneMatch(true)
+		}
+	case err != nil:
+		return nil, err
+	default:
+		// Keep the options of the store as well
+		obj.Options = options
+		if err = obj.Resolve(); err != nil {
+			return obj, err
+		}
+		if conditional {
+			condition = IfNotModified(true)
+		}
+	}
+	if err = fn(obj); err != nil {
+		return obj, err
+	}
+	return obj, obj.store(ctx, condition)
+}
+
+// Returns true if a conditional store failed because the object was created,
+// changed or deleted after it was fetched
+func concurrentlyModified(err error) bool {
+	return errors.Is(err, ObjectModified) || errors.Is(err, ObjectExists) || errors.Is(err, NotFound)
+}
+
+// Return the options without the given option
+func withoutOption(options []map[string]uint32, key string) []map[string]uint32 {
+	var result []map[string]uint32
+	for _, omap := range options {
+		if _, ok := omap[key]; !ok {
+			result = append(result, omap)
+			continue
+		}
+		o := make(map[string]uint32, len(omap))
+		for k, v := range omap {
+			if k != key {
+				o[k] = v
+			}
+		}
+		result = append(result, o)
+	}
+	return result
+}
@@ -0,0 +1,118 @@
+package riak
+
+import (
+	"errors"
+	"github.com/bmizerany/assert"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// Resolves siblings by concatenating their values
+type concatResolver struct{}
+
+func (concatResolver) Resolve(siblings []Sibling) (Sibling, error) {
+	resolved := siblings[0]
+	for _, s := range siblings[1:] {
+		resolved.Data = append(append([]byte{}, resolved.Data...), s.Data...)
+	}
+	return resolved, nil
+}
+
+// Increment the number stored in an object
+func increment(obj *RObject) error {
+	n, _ := strconv.Atoi(string(obj.Data))
+	obj.ContentType = "text/plain"
+	obj.Data = []byte(strconv.Itoa(n + 1))
+	return nil
+}
+
+func TestUpdate(t *testing.T) {
+	client := setupConnections(t, 4)
+	defer client.Close()
+	bucket, err := client.NewBucket("update_test.go")
+	assert.T(t, err == nil)
+
+	// A new object is created
+	obj, err := bucket.Update("counter", increment)
+	assert.T(t, err == nil)
+	assert.T(t, string(obj.Data) == "1")
+	obj, err = bucket.Update("counter", increment, IfNotModified(true))
+	assert.T(t, err == nil)
+	assert.T(t, string(obj.Data) == "2")
+
+	// Concurrent conditional updates are retried
+	client.SetUpdateAttempts(100)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := bucket.Update("counter", increment, IfNotModified(true))
+			assert.T(t, err == nil)
+		}()
+	}
+	wg.Wait()
+	obj, err = bucket.Get("counter")
+	assert.T(t, err == nil)
+	assert.T(t, string(obj.Data) == "12")
+
+	// The error of fn is returned and nothing is stored
+	stop := errors.New("stop")
+	_, err = bucket.Update("counter", func(obj *RObject) error { return stop })
+	assert.T(t, err == stop)
+
+	// Give up after the maximum number of attempts
+	client.SetUpdateAttempts(3)
+	count := 0
+	_, err = bucket.Update("counter", func(obj *RObject) error {
+		count++
+		// Modify the object concurrently
+		other, _ := bucket.Get("counter")
+		assert.T(t, other.Store() == nil)
+		return increment(obj)
+	}, IfNotModified(true))
+	var rerr *RetryError
+	assert.T(t, errors.As(err, &rerr))
+	assert.T(t, rerr.Attempts == 3)
+	assert.T(t, errors.Is(err, ObjectModified))
+	assert.T(t, count == 3)
+}
+
+func TestUpdateSiblings(t *testing.T) {
+	client := setupConnection(t)
+	defer client.Close()
+	bucket, err := client.NewBucket("update_test.go")
+	assert.T(t, err == nil)
+	assert.T(t, bucket.SetAllowMult(true) == nil)
+	for _, data := range []string{"a", "b"} {
+		obj := bucket.NewObject("key")
+		obj.ContentType = "text/plain"
+		obj.Data = []byte(data)
+		assert.T(t, obj.Store() == nil)
+	}
+
+	// Without a resolver the siblings cannot be updated
+	_, err = bucket.Update("key", func(obj *RObject) error { return nil })
+	assert.T(t, err == Unresolved)
+	assert.T(t, errors.Is(err, SiblingConflict))
+
+	// The resolved value is stored, replacing the siblings
+	bucket.SetConflictResolver(concatResolver{})
+	obj, err := bucket.Update("key", func(obj *RObject) error {
+		assert.T(t, !obj.Conflict())
+		obj.Data = append(obj.Data, 'c')
+		return nil
+	}, IfNotModified(true))
+	assert.T(t, err == nil)
+	obj, err = bucket.Get("key")
+	assert.T(t, err == nil)
+	assert.T(t, !obj.Conflict())
+	assert.T(t, string(obj.Data) == "abc")
+
+	// Objects that are deleted are created again
+	assert.T(t, bucket.Delete("key") == nil)
+	obj, err = bucket.Update("key", increment, IfNotModified(true))
+	assert.T(t, err == nil)
+	assert.T(t, string(obj.Data) == "1")
+}